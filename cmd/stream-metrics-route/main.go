@@ -8,10 +8,15 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"stream-metrics-route/pkg/auth"
+	"stream-metrics-route/pkg/cardinality"
 	"stream-metrics-route/pkg/receive"
+	"stream-metrics-route/pkg/remote"
 	"stream-metrics-route/pkg/router"
 	"stream-metrics-route/pkg/setting"
 	"stream-metrics-route/pkg/telemetry"
+	"stream-metrics-route/pkg/tenant"
+	"stream-metrics-route/pkg/web"
 	"syscall"
 	"time"
 
@@ -27,12 +32,14 @@ var (
 	confName         = flag.String("config.name", defaultConfigName, "default name 'config.yaml'")
 	logLevel         = flag.String("log.level", "info", "debug or info")
 	listenPort       = flag.String("listen.port", "8080", "listen port")
+	webConfigFile    = flag.String("web.config.file", "", "Path to the web config file enabling TLS, client certificates and HTTP/2 settings.")
 	configFile       = ""
 	receiver         receive.Receive
 	route            = gin.Default()
 	defaultTelemetry = telemetry.NewTelemetry()
 	health           = true
 	defaultCfg       = &setting.Config{}
+	webCfg           *setting.WebConf
 )
 
 func init() {
@@ -44,29 +51,66 @@ func init() {
 		panic(fmt.Errorf("Fatal error config file: %s \n", err))
 	}
 	defaultTelemetry.LevelSet(context.Background(), *logLevel)
+	if *webConfigFile != "" {
+		webCfg, err = setting.LoadWebConfig(*webConfigFile)
+		if err != nil {
+			panic(fmt.Errorf("Fatal error web config file: %s \n", err))
+		}
+	}
+	receiver.Validator, err = receive.NewValidator(defaultCfg.Validation)
+	if err != nil {
+		panic(fmt.Errorf("Fatal error validation config: %s \n", err))
+	}
+	receiver.Decoder, err = receive.NewDecoder(defaultCfg.RequestLimits)
+	if err != nil {
+		panic(fmt.Errorf("Fatal error request limits: %s \n", err))
+	}
+	receiver.Tenants = tenant.New(defaultCfg.Tenant)
+	receiver.Limits, err = tenant.NewLimits(defaultCfg.Tenant)
+	if err != nil {
+		panic(fmt.Errorf("Fatal error tenant limits: %s \n", err))
+	}
+	receiver.Auth, err = auth.New(defaultCfg.Auth)
+	if err != nil {
+		panic(fmt.Errorf("Fatal error auth config: %s \n", err))
+	}
 
 	route.GET("/metrics", gin.WrapH(
 		promhttp.HandlerFor(defaultTelemetry.Metrics, promhttp.HandlerOpts{}),
 	))
-	router.BuildRouters(defaultCfg)
+	if err := router.BuildRouters(defaultCfg); err != nil {
+		panic(fmt.Errorf("Fatal error router config: %s \n", err))
+	}
 }
 
 func main() {
 
-	router_v1 := route.Group("api/v1")
+	router_v1 := route.Group("api/v1", receiver.Auth.Middleware())
 	router_v1.POST("write", receiver.Handler())
 	router_v1.POST("receive", receiver.Handler())
+	router_v1.POST("write/:tenant", receiver.Handler())
+	router_v1.POST("receive/:tenant", receiver.Handler())
 	// Set up channel to receive signals
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
 
 	// Set up router with health and readiness endpoints
 	route.GET("/-/ready", receive.CheckReady)
+	route.GET("/-/cardinality", cardinality.Status)
 	route.GET("/-/health", func(c *gin.Context) {
-		data := gin.H{"code": 0, "msg": "no health", "data": nil}
+		upstreams := remote.HealthStatus()
+		data := gin.H{"code": 0, "msg": "no health", "data": upstreams}
 		if health {
+			// A route with every upstream down can't take writes.
+			for _, cs := range upstreams {
+				if !cs.Healthy() {
+					data["msg"] = "no healthy upstream for route " + cs.Name
+					c.JSON(http.StatusServiceUnavailable, data)
+					return
+				}
+			}
 			if receive.CheckHealthy(c) {
-				data = gin.H{"code": 2000, "msg": "ok", "data": nil}
+				data = gin.H{"code": 2000, "msg": "ok", "data": upstreams}
 				c.JSON(http.StatusOK, data)
 				return
 			}
@@ -76,7 +120,12 @@ func main() {
 	})
 
 	// Start router in a goroutine
-	go route.Run(":" + *listenPort)
+	go func() {
+		if err := web.ListenAndServe(":"+*listenPort, route, webCfg); err != nil {
+			defaultTelemetry.Logger.Error("listener error", "err", err)
+			os.Exit(1)
+		}
+	}()
 
 	// Listen for signals
 	for {
@@ -85,6 +134,7 @@ func main() {
 			// Gracefully shutdown server on signal
 			health = false
 			receive.CheckWriteTask(200 * time.Millisecond)
+			router.GetRouters().Close()
 			os.Exit(0)
 		}
 	}
@@ -0,0 +1,341 @@
+package aggregator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// staleIntervals is how many flushes without samples an output series and
+// the per-series state of `total` survive before being forgotten.
+const staleIntervals = 2
+
+// Aggregators runs the aggregations configured on one route.
+type Aggregators struct {
+	aggs []*aggregator
+}
+
+// New starts the aggregations of a route. Every interval the aggregated
+// series are handed to push, once per tenant, which is expected to send
+// them upstream.
+func New(route string, cfgs []setting.AggregationConf, push func(tenant string, tss []prompb.TimeSeries)) (*Aggregators, error) {
+	a := &Aggregators{}
+	for i, cfg := range cfgs {
+		agg, err := newAggregator(route, cfg, push)
+		if err != nil {
+			a.Stop()
+			return nil, fmt.Errorf("aggregation %d: %v", i, err)
+		}
+		a.aggs = append(a.aggs, agg)
+	}
+	for _, agg := range a.aggs {
+		go agg.run()
+	}
+	return a, nil
+}
+
+// Push feeds the series of tenant to every aggregation selecting them and
+// returns the series that should still be forwarded, i.e. all but those
+// selected by an aggregation with drop_inputs. Tenants are aggregated
+// apart.
+func (a *Aggregators) Push(tenant string, tss []prompb.TimeSeries) []prompb.TimeSeries {
+	if a == nil || len(a.aggs) == 0 {
+		return tss
+	}
+	forward := make([]prompb.TimeSeries, 0, len(tss))
+	for _, ts := range tss {
+		lbs := formatLabelSet(ts.Labels)
+		dropped := false
+		for _, agg := range a.aggs {
+			if !agg.selects(lbs) {
+				continue
+			}
+			agg.push(tenant, lbs, ts.Samples)
+			dropped = dropped || agg.dropInputs
+		}
+		if !dropped {
+			forward = append(forward, ts)
+		}
+	}
+	return forward
+}
+
+// Stop stops the periodic flushes.
+func (a *Aggregators) Stop() {
+	if a == nil {
+		return
+	}
+	for _, agg := range a.aggs {
+		close(agg.stop)
+	}
+}
+
+type group struct {
+	tenant string
+	name   string
+	labels []prompb.Label
+	state  groupState
+}
+
+type lastValue struct {
+	value float64
+	ts    int64
+	idle  int
+}
+
+type aggregator struct {
+	route          string
+	name           string
+	suffix         string
+	relabelConfigs []*relabel.Config
+	interval       time.Duration
+	by             []string
+	without        map[string]bool
+	outputs        []output
+	trackSeries    bool
+	trackValues    bool
+	trackTotal     bool
+	dropInputs     bool
+	send           func(tenant string, tss []prompb.TimeSeries)
+	stop           chan struct{}
+
+	lock       sync.Mutex
+	groups     map[string]*group
+	lastValues map[string]*lastValue
+}
+
+func newAggregator(route string, cfg setting.AggregationConf, push func(tenant string, tss []prompb.TimeSeries)) (*aggregator, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	if len(cfg.By) > 0 && len(cfg.Without) > 0 {
+		return nil, fmt.Errorf("by and without are mutually exclusive")
+	}
+	if len(cfg.Outputs) == 0 {
+		return nil, fmt.Errorf("at least one output is required")
+	}
+	agg := &aggregator{
+		route:          route,
+		relabelConfigs: cfg.MetricRelabelConfigs,
+		interval:       time.Duration(cfg.Interval),
+		without:        make(map[string]bool),
+		dropInputs:     cfg.DropInputs,
+		send:           push,
+		stop:           make(chan struct{}),
+		groups:         make(map[string]*group),
+		lastValues:     make(map[string]*lastValue),
+	}
+	for _, s := range cfg.Outputs {
+		o, err := parseOutput(s)
+		if err != nil {
+			return nil, err
+		}
+		agg.outputs = append(agg.outputs, o)
+		agg.trackSeries = agg.trackSeries || o.kind == countSeries
+		agg.trackValues = agg.trackValues || o.kind == quantiles
+		agg.trackTotal = agg.trackTotal || o.kind == total
+	}
+
+	// Output names follow the vmagent convention
+	// <metric>:<interval>[_by_<labels>|_without_<labels>]_<output>.
+	agg.name = model.Duration(cfg.Interval).String()
+	if len(cfg.By) > 0 {
+		agg.by = append([]string(nil), cfg.By...)
+		sort.Strings(agg.by)
+		agg.name += "_by_" + strings.Join(agg.by, "_")
+	}
+	if len(cfg.Without) > 0 {
+		without := append([]string(nil), cfg.Without...)
+		sort.Strings(without)
+		for _, l := range without {
+			agg.without[l] = true
+		}
+		agg.name += "_without_" + strings.Join(without, "_")
+	}
+	agg.suffix = ":" + agg.name + "_"
+	return agg, nil
+}
+
+func (agg *aggregator) selects(lbs labels.Labels) bool {
+	if len(agg.relabelConfigs) == 0 {
+		return true
+	}
+	lbls, keep := relabel.Process(lbs, agg.relabelConfigs...)
+	return keep && !lbls.IsEmpty()
+}
+
+func (agg *aggregator) outputLabels(lbs labels.Labels) []prompb.Label {
+	out := make([]prompb.Label, 0, lbs.Len())
+	lbs.Range(func(l labels.Label) {
+		switch {
+		case l.Name == labels.MetricName:
+			return
+		case len(agg.by) > 0:
+			i := sort.SearchStrings(agg.by, l.Name)
+			if i == len(agg.by) || agg.by[i] != l.Name {
+				return
+			}
+		case agg.without[l.Name]:
+			return
+		}
+		out = append(out, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return out
+}
+
+func (agg *aggregator) push(tenant string, lbs labels.Labels, samples []prompb.Sample) {
+	seriesKey := tenant + "\xfc" + lbs.String()
+	name := lbs.Get(labels.MetricName)
+	out := agg.outputLabels(lbs)
+	groupKey := tenant + "\xfc" + name + "\xfd" + labelsKey(out)
+	aggInputSamples.WithLabelValues(agg.route, agg.name).Add(float64(len(samples)))
+
+	agg.lock.Lock()
+	defer agg.lock.Unlock()
+	g, ok := agg.groups[groupKey]
+	if !ok {
+		g = &group{tenant: tenant, name: name, labels: out}
+		if agg.trackSeries {
+			g.state.series = make(map[string]struct{})
+		}
+		agg.groups[groupKey] = g
+	}
+	for _, s := range samples {
+		g.state.add(seriesKey, s.Timestamp, s.Value, agg.trackSeries, agg.trackValues)
+		if !agg.trackTotal {
+			continue
+		}
+		// total sums the increases of every input series, treating a drop
+		// in value as a counter reset.
+		lv, ok := agg.lastValues[seriesKey]
+		if !ok {
+			agg.lastValues[seriesKey] = &lastValue{value: s.Value, ts: s.Timestamp}
+			continue
+		}
+		if s.Timestamp < lv.ts {
+			continue
+		}
+		delta := s.Value - lv.value
+		if s.Value < lv.value {
+			delta = s.Value
+		}
+		g.state.total += delta
+		lv.value, lv.ts, lv.idle = s.Value, s.Timestamp, 0
+	}
+}
+
+func (agg *aggregator) run() {
+	ticker := time.NewTicker(agg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-agg.stop:
+			return
+		case now := <-ticker.C:
+			for tenant, tss := range agg.flush(now) {
+				aggOutputSeries.WithLabelValues(agg.route, agg.name).Add(float64(len(tss)))
+				agg.send(tenant, tss)
+			}
+		}
+	}
+}
+
+// flush emits the aggregated series grouped by tenant.
+func (agg *aggregator) flush(now time.Time) map[string][]prompb.TimeSeries {
+	ts := now.UnixMilli()
+	tss := make(map[string][]prompb.TimeSeries)
+	emit := func(g *group, o output, extra *prompb.Label, value float64) {
+		lbs := make([]prompb.Label, 0, len(g.labels)+2)
+		lbs = append(lbs, g.labels...)
+		if extra != nil {
+			lbs = append(lbs, *extra)
+		}
+		sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+		lbs = append([]prompb.Label{{Name: labels.MetricName, Value: g.name + agg.suffix + o.name}}, lbs...)
+		tss[g.tenant] = append(tss[g.tenant], prompb.TimeSeries{Labels: lbs, Samples: []prompb.Sample{{Value: value, Timestamp: ts}}})
+	}
+
+	agg.lock.Lock()
+	defer agg.lock.Unlock()
+	for key, g := range agg.groups {
+		st := &g.state
+		if st.samples == 0 {
+			if st.idle++; st.idle > staleIntervals {
+				delete(agg.groups, key)
+				continue
+			}
+			// The running total stays valid while its inputs pause.
+			for _, o := range agg.outputs {
+				if o.kind == total {
+					emit(g, o, nil, st.total)
+				}
+			}
+			continue
+		}
+		st.idle = 0
+		var sorted []float64
+		for _, o := range agg.outputs {
+			switch o.kind {
+			case sumSamples:
+				emit(g, o, nil, st.sum)
+			case countSamples:
+				emit(g, o, nil, float64(st.samples))
+			case countSeries:
+				emit(g, o, nil, float64(len(st.series)))
+			case total:
+				emit(g, o, nil, st.total)
+			case last:
+				emit(g, o, nil, st.last)
+			case minimum:
+				emit(g, o, nil, st.min)
+			case maximum:
+				emit(g, o, nil, st.max)
+			case avg:
+				emit(g, o, nil, st.sum/float64(st.samples))
+			case quantiles:
+				if sorted == nil {
+					sorted = sortedCopy(st.values)
+				}
+				for _, phi := range o.phis {
+					emit(g, o, &prompb.Label{Name: "quantile", Value: strconv.FormatFloat(phi, 'g', -1, 64)}, quantile(phi, sorted))
+				}
+			}
+		}
+		st.reset()
+	}
+	for key, lv := range agg.lastValues {
+		if lv.idle++; lv.idle > staleIntervals+1 {
+			delete(agg.lastValues, key)
+		}
+	}
+	aggGroups.WithLabelValues(agg.route, agg.name).Set(float64(len(agg.groups)))
+	return tss
+}
+
+func labelsKey(lbs []prompb.Label) string {
+	var b strings.Builder
+	for _, l := range lbs {
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xfe)
+	}
+	return b.String()
+}
+
+func formatLabelSet(lb []prompb.Label) labels.Labels {
+	var m = make(map[string]string, 0)
+	for _, v := range lb {
+		m[v.Name] = v.Value
+	}
+	return labels.FromMap(m)
+}
@@ -0,0 +1,201 @@
+package aggregator_test
+
+import (
+	"sort"
+	"stream-metrics-route/pkg/aggregator"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func series(name string, value float64, ts int64, kv ...string) prompb.TimeSeries {
+	lbs := []prompb.Label{{Name: "__name__", Value: name}}
+	for i := 0; i < len(kv); i += 2 {
+		lbs = append(lbs, prompb.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return prompb.TimeSeries{Labels: lbs, Samples: []prompb.Sample{{Value: value, Timestamp: ts}}}
+}
+
+// seriesString renders a series as `name{k="v",...}` for comparisons.
+func seriesString(ts prompb.TimeSeries) string {
+	var name string
+	var pairs []string
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		pairs = append(pairs, l.Name+"=\""+l.Value+"\"")
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// collect starts the aggregations and returns a function waiting for the
+// next non-empty flush, keyed by series.
+func collect(t *testing.T, cfgs []setting.AggregationConf) (*aggregator.Aggregators, func() map[string]float64) {
+	out := make(chan []prompb.TimeSeries, 16)
+	a, err := aggregator.New("test", cfgs, func(_ string, tss []prompb.TimeSeries) { out <- tss })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Stop)
+	return a, func() map[string]float64 {
+		select {
+		case tss := <-out:
+			got := make(map[string]float64)
+			for _, ts := range tss {
+				got[seriesString(ts)] = ts.Samples[0].Value
+			}
+			return got
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a flush")
+			return nil
+		}
+	}
+}
+
+func assertOutputs(t *testing.T, got, want map[string]float64) {
+	t.Helper()
+	var keys []string
+	for k := range got {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(got) != len(want) {
+		t.Fatalf("got %d series %v, want %d", len(got), keys, len(want))
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Fatalf("%s = %v (present %v), want %v; got %v", k, g, ok, v, keys)
+		}
+	}
+}
+
+func TestAggregatorsOutputs(t *testing.T) {
+	a, next := collect(t, []setting.AggregationConf{{
+		Interval: model.Duration(200 * time.Millisecond),
+		By:       []string{"job"},
+		Outputs:  []string{"sum_samples", "count_samples", "count_series", "last", "min", "max", "avg", "quantiles(0, 0.5, 1)"},
+	}})
+	forward := a.Push("", []prompb.TimeSeries{
+		series("latency", 1, 1000, "job", "api", "instance", "a"),
+		series("latency", 5, 2000, "job", "api", "instance", "a"),
+		series("latency", 3, 1500, "job", "api", "instance", "b"),
+		series("latency", 10, 1000, "job", "db", "instance", "a"),
+	})
+	if len(forward) != 4 {
+		t.Fatalf("expected inputs to be forwarded, got %d", len(forward))
+	}
+	assertOutputs(t, next(), map[string]float64{
+		`latency:200ms_by_job_sum_samples{job="api"}`:              9,
+		`latency:200ms_by_job_count_samples{job="api"}`:            3,
+		`latency:200ms_by_job_count_series{job="api"}`:             2,
+		`latency:200ms_by_job_last{job="api"}`:                     5,
+		`latency:200ms_by_job_min{job="api"}`:                      1,
+		`latency:200ms_by_job_max{job="api"}`:                      5,
+		`latency:200ms_by_job_avg{job="api"}`:                      3,
+		`latency:200ms_by_job_quantiles{job="api",quantile="0"}`:   1,
+		`latency:200ms_by_job_quantiles{job="api",quantile="0.5"}`: 3,
+		`latency:200ms_by_job_quantiles{job="api",quantile="1"}`:   5,
+		`latency:200ms_by_job_sum_samples{job="db"}`:               10,
+		`latency:200ms_by_job_count_samples{job="db"}`:             1,
+		`latency:200ms_by_job_count_series{job="db"}`:              1,
+		`latency:200ms_by_job_last{job="db"}`:                      10,
+		`latency:200ms_by_job_min{job="db"}`:                       10,
+		`latency:200ms_by_job_max{job="db"}`:                       10,
+		`latency:200ms_by_job_avg{job="db"}`:                       10,
+		`latency:200ms_by_job_quantiles{job="db",quantile="0"}`:    10,
+		`latency:200ms_by_job_quantiles{job="db",quantile="0.5"}`:  10,
+		`latency:200ms_by_job_quantiles{job="db",quantile="1"}`:    10,
+	})
+}
+
+func TestAggregatorsTotalDropInputs(t *testing.T) {
+	a, next := collect(t, []setting.AggregationConf{{
+		MetricRelabelConfigs: []*relabel.Config{{
+			SourceLabels: model.LabelNames{"__name__"},
+			Regex:        relabel.MustNewRegexp("requests_total"),
+			Action:       relabel.Keep,
+		}},
+		Interval:   model.Duration(200 * time.Millisecond),
+		Without:    []string{"instance"},
+		Outputs:    []string{"total"},
+		DropInputs: true,
+	}})
+	forward := a.Push("", []prompb.TimeSeries{
+		series("requests_total", 10, 1000, "job", "api", "instance", "a"),
+		series("requests_total", 4, 1000, "job", "api", "instance", "b"),
+		series("up", 1, 1000, "job", "api", "instance", "a"),
+	})
+	if len(forward) != 1 || seriesString(forward[0]) != `up{job="api",instance="a"}` {
+		t.Fatalf("expected only the unselected series to be forwarded, got %v", forward)
+	}
+	// The first sample of every series only establishes its baseline.
+	assertOutputs(t, next(), map[string]float64{`requests_total:200ms_without_instance_total{job="api"}`: 0})
+
+	a.Push("", []prompb.TimeSeries{
+		series("requests_total", 15, 2000, "job", "api", "instance", "a"),
+		series("requests_total", 6, 2000, "job", "api", "instance", "b"),
+		// b restarted: its value is counted from zero.
+		series("requests_total", 2, 3000, "job", "api", "instance", "b"),
+	})
+	assertOutputs(t, next(), map[string]float64{`requests_total:200ms_without_instance_total{job="api"}`: 9})
+	// Without new samples the running total keeps being reported.
+	assertOutputs(t, next(), map[string]float64{`requests_total:200ms_without_instance_total{job="api"}`: 9})
+}
+
+func TestAggregatorsConfigErrors(t *testing.T) {
+	for _, cfg := range []setting.AggregationConf{
+		{Outputs: []string{"sum_samples"}},
+		{Interval: model.Duration(time.Minute)},
+		{Interval: model.Duration(time.Minute), Outputs: []string{"median"}},
+		{Interval: model.Duration(time.Minute), Outputs: []string{"quantiles(1.5)"}},
+		{Interval: model.Duration(time.Minute), Outputs: []string{"sum_samples"}, By: []string{"job"}, Without: []string{"instance"}},
+	} {
+		if _, err := aggregator.New("test", []setting.AggregationConf{cfg}, func(string, []prompb.TimeSeries) {}); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestAggregatorsTenants(t *testing.T) {
+	type flushed struct {
+		tenant string
+		tss    []prompb.TimeSeries
+	}
+	out := make(chan flushed, 16)
+	a, err := aggregator.New("test", []setting.AggregationConf{{
+		Interval: model.Duration(100 * time.Millisecond),
+		Without:  []string{"instance"},
+		Outputs:  []string{"sum_samples"},
+	}}, func(tenant string, tss []prompb.TimeSeries) { out <- flushed{tenant, tss} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	// The same group of two tenants is aggregated and sent apart.
+	a.Push("team-a", []prompb.TimeSeries{series("up", 1, 1000, "job", "api", "instance", "a")})
+	a.Push("team-b", []prompb.TimeSeries{series("up", 2, 1000, "job", "api", "instance", "a")})
+	got := make(map[string]float64)
+	deadline := time.After(10 * time.Second)
+	for len(got) < 2 {
+		select {
+		case f := <-out:
+			if len(f.tss) != 1 {
+				t.Fatalf("expected one series per tenant, got %v", f.tss)
+			}
+			got[f.tenant] = f.tss[0].Samples[0].Value
+		case <-deadline:
+			t.Fatalf("timed out waiting for a flush, got %v", got)
+		}
+	}
+	if got["team-a"] != 1 || got["team-b"] != 2 {
+		t.Fatalf("unexpected aggregates per tenant %v", got)
+	}
+}
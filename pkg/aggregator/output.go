@@ -0,0 +1,125 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type outputKind int
+
+const (
+	sumSamples outputKind = iota
+	countSamples
+	countSeries
+	total
+	last
+	minimum
+	maximum
+	avg
+	quantiles
+)
+
+var outputKinds = map[string]outputKind{
+	"sum_samples":   sumSamples,
+	"count_samples": countSamples,
+	"count_series":  countSeries,
+	"total":         total,
+	"last":          last,
+	"min":           minimum,
+	"max":           maximum,
+	"avg":           avg,
+}
+
+type output struct {
+	kind outputKind
+	name string
+	phis []float64
+}
+
+// parseOutput parses an output name such as `sum_samples` or
+// `quantiles(0.5, 0.99)`.
+func parseOutput(s string) (output, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "quantiles(") && strings.HasSuffix(s, ")") {
+		o := output{kind: quantiles, name: "quantiles"}
+		for _, arg := range strings.Split(s[len("quantiles("):len(s)-1], ",") {
+			phi, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+			if err != nil || phi < 0 || phi > 1 {
+				return o, fmt.Errorf("invalid quantile %q in %q, must be within [0..1]", arg, s)
+			}
+			o.phis = append(o.phis, phi)
+		}
+		return o, nil
+	}
+	kind, ok := outputKinds[s]
+	if !ok {
+		return output{}, fmt.Errorf("unknown aggregation output %q", s)
+	}
+	return output{kind: kind, name: s}, nil
+}
+
+// groupState accumulates the samples of one output series. Everything but
+// the running total is reset after every flush.
+type groupState struct {
+	samples int
+	sum     float64
+	min     float64
+	max     float64
+	last    float64
+	lastTs  int64
+	series  map[string]struct{}
+	values  []float64
+	total   float64
+	idle    int
+}
+
+func (g *groupState) add(seriesKey string, ts int64, v float64, trackSeries, trackValues bool) {
+	if g.samples == 0 || v < g.min {
+		g.min = v
+	}
+	if g.samples == 0 || v > g.max {
+		g.max = v
+	}
+	if g.samples == 0 || ts >= g.lastTs {
+		g.last, g.lastTs = v, ts
+	}
+	g.samples++
+	g.sum += v
+	if trackSeries {
+		g.series[seriesKey] = struct{}{}
+	}
+	if trackValues {
+		g.values = append(g.values, v)
+	}
+}
+
+func (g *groupState) reset() {
+	g.samples = 0
+	g.sum = 0
+	g.values = g.values[:0]
+	for k := range g.series {
+		delete(g.series, k)
+	}
+}
+
+// quantile returns the phi-quantile of the sorted values, interpolating
+// between the closest ranks like PromQL's quantile().
+func quantile(phi float64, sorted []float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := phi * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+func sortedCopy(values []float64) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted
+}
@@ -0,0 +1,39 @@
+package aggregator
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_aggregation"
+
+var (
+	aggInputSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "input_samples_total",
+			Help:      "Count of samples fed to an aggregation",
+		}, []string{"route_name", "aggregation"})
+	aggOutputSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "output_series_total",
+			Help:      "Count of aggregated series emitted to the route upstream",
+		}, []string{"route_name", "aggregation"})
+	aggGroups = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "groups",
+			Help:      "Number of output series an aggregation currently tracks",
+		}, []string{"route_name", "aggregation"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(aggInputSamples)
+	defaultTelemetry.Register(aggOutputSeries)
+	defaultTelemetry.Register(aggGroups)
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	defaultReloadInterval = time.Minute
+	// maxVerifiedPasswords bounds the cache of bcrypt verifications, which
+	// are far too slow to run on every remote write request.
+	maxVerifiedPasswords = 1024
+	// dummyHash is compared against for unknown users, so they take as long
+	// to refuse as a wrong password and don't reveal which users exist.
+	dummyHash = []byte("$2a$10$jWkwqx0/V44XofZkY/JqPuI/dec7FwGgXJnEsyXCXu23S8lOk4Xe2")
+
+	errNoCredentials = errors.New("no credentials")
+)
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the authenticated identity.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity carried by ctx, if any.
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(contextKey{}).(string)
+	return identity
+}
+
+// Authenticator authenticates receive requests.
+type Authenticator struct {
+	cfg      *setting.AuthConf
+	tenants  map[string]string
+	label    string
+	asTenant bool
+
+	lock     sync.RWMutex
+	tokens   map[string]string
+	users    map[string][]byte
+	verified map[[sha256.Size]byte]bool
+	roots    *x509.CertPool
+	keys     []verifyKey
+}
+
+// New returns nil, authenticating nothing, when cfg is nil. Files must
+// load at startup; later reload failures keep the previous content.
+func New(cfg *setting.AuthConf) (*Authenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.BearerTokens) == 0 && cfg.BasicAuthFile == "" && cfg.ClientCert == nil && cfg.JWT == nil {
+		return nil, errors.New("auth requires at least one of bearer_tokens, basic_auth_file, client_cert or jwt")
+	}
+	if cfg.ClientCert != nil && cfg.ClientCert.Identity != "" && cfg.ClientCert.Identity != "cn" && cfg.ClientCert.Identity != "san" {
+		return nil, fmt.Errorf("unknown client_cert identity %q, must be cn or san", cfg.ClientCert.Identity)
+	}
+	a := &Authenticator{
+		cfg:      cfg,
+		tenants:  cfg.Tenants,
+		label:    cfg.IdentityLabel,
+		asTenant: cfg.IdentityAsTenant,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	if cfg.BasicAuthFile == "" && cfg.ClientCert == nil && cfg.JWT == nil && !hasTokenFile(cfg.BearerTokens) {
+		return a, nil
+	}
+	interval := time.Duration(cfg.ReloadInterval)
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := a.load(); err != nil {
+				defaultTelemetry.Logger.Error("auth reload error", "err", err)
+			}
+		}
+	}()
+	return a, nil
+}
+
+func hasTokenFile(tokens []setting.BearerTokenConf) bool {
+	for _, t := range tokens {
+		if t.TokenFile != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the tokens, users, client CA and JWKS files.
+func (a *Authenticator) load() error {
+	tokens := make(map[string]string, len(a.cfg.BearerTokens))
+	for _, t := range a.cfg.BearerTokens {
+		token := string(t.Token)
+		if t.TokenFile != "" {
+			b, err := os.ReadFile(t.TokenFile)
+			if err != nil {
+				return err
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token == "" || t.Identity == "" {
+			return errors.New("bearer tokens require a token and an identity")
+		}
+		tokens[token] = t.Identity
+	}
+
+	var users map[string][]byte
+	if a.cfg.BasicAuthFile != "" {
+		var err error
+		if users, err = loadUsers(a.cfg.BasicAuthFile); err != nil {
+			return err
+		}
+	}
+
+	var roots *x509.CertPool
+	if a.cfg.ClientCert != nil {
+		b, err := os.ReadFile(a.cfg.ClientCert.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate found in %s", a.cfg.ClientCert.CAFile)
+		}
+	}
+
+	var keys []verifyKey
+	if a.cfg.JWT != nil {
+		b, err := os.ReadFile(a.cfg.JWT.JWKSFile)
+		if err != nil {
+			return err
+		}
+		if keys, err = parseJWKS(b); err != nil {
+			return fmt.Errorf("parsing JWKS %s: %v", a.cfg.JWT.JWKSFile, err)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tokens, a.users, a.roots, a.keys = tokens, users, roots, keys
+	a.verified = make(map[[sha256.Size]byte]bool)
+	return nil
+}
+
+// loadUsers reads a file of `user:bcrypt-hash` lines.
+func loadUsers(file string) (map[string][]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:bcrypt-hash", file, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
+
+// Middleware refuses unauthenticated requests with 401, and carries the
+// identity of the others, and their tenant with identity_as_tenant, in
+// the request context.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			return
+		}
+		method, identity, err := a.authenticate(c.Request)
+		if err != nil {
+			authRequests.WithLabelValues(method, "failure").Inc()
+			defaultTelemetry.Logger.Debug("authentication failed", "method", method, "client", c.ClientIP(), "err", err)
+			if a.cfg.BasicAuthFile != "" {
+				c.Header("WWW-Authenticate", `Basic realm="stream-metrics-route"`)
+			} else if len(a.cfg.BearerTokens) > 0 || a.cfg.JWT != nil {
+				c.Header("WWW-Authenticate", "Bearer")
+			}
+			c.String(http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+		authRequests.WithLabelValues(method, "success").Inc()
+		ctx := WithIdentity(c.Request.Context(), identity)
+		if a.asTenant {
+			ctx = tenant.WithTenant(ctx, a.tenant(identity))
+		}
+		c.Request = c.Request.WithContext(ctx)
+	}
+}
+
+func (a *Authenticator) authenticate(req *http.Request) (method, identity string, err error) {
+	if a.cfg.ClientCert != nil && req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		identity, err := a.verifyClientCert(req.TLS.PeerCertificates)
+		return "client_cert", identity, err
+	}
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return "none", "", errNoCredentials
+	}
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	switch {
+	case strings.EqualFold(scheme, "Bearer") && (len(a.cfg.BearerTokens) > 0 || a.cfg.JWT != nil):
+		if identity, ok := a.token(credentials); ok {
+			return "bearer", identity, nil
+		}
+		if a.cfg.JWT != nil && strings.Count(credentials, ".") == 2 {
+			identity, err := a.verifyJWT(credentials, time.Now())
+			return "jwt", identity, err
+		}
+		return "bearer", "", errors.New("invalid bearer token")
+	case strings.EqualFold(scheme, "Basic") && a.cfg.BasicAuthFile != "":
+		user, password, ok := req.BasicAuth()
+		if !ok {
+			return "basic", "", errors.New("malformed basic auth")
+		}
+		return "basic", user, a.checkPassword(user, password)
+	}
+	return "none", "", fmt.Errorf("unsupported authorization scheme %q", scheme)
+}
+
+// token compares every token in constant time, so that response times
+// don't leak them.
+func (a *Authenticator) token(credentials string) (string, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	identity, found := "", false
+	for token, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(credentials)) == 1 {
+			identity, found = id, true
+		}
+	}
+	return identity, found
+}
+
+func (a *Authenticator) checkPassword(user, password string) error {
+	a.lock.RLock()
+	hash, ok := a.users[user]
+	a.lock.RUnlock()
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return fmt.Errorf("unknown user %s", user)
+	}
+	key := sha256.Sum256([]byte(string(hash) + "\x00" + password))
+	a.lock.RLock()
+	verified := a.verified[key]
+	a.lock.RUnlock()
+	if verified {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return fmt.Errorf("invalid password for user %s", user)
+	}
+	a.lock.Lock()
+	if len(a.verified) >= maxVerifiedPasswords {
+		a.verified = make(map[[sha256.Size]byte]bool)
+	}
+	a.verified[key] = true
+	a.lock.Unlock()
+	return nil
+}
+
+// verifyClientCert verifies the chain presented by the client against
+// the client CA, as the listener may only ask for a certificate.
+func (a *Authenticator) verifyClientCert(certs []*x509.Certificate) (string, error) {
+	a.lock.RLock()
+	roots := a.roots
+	a.lock.RUnlock()
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return "", err
+	}
+	cert := certs[0]
+	if a.cfg.ClientCert.Identity != "san" {
+		if cert.Subject.CommonName == "" {
+			return "", errors.New("client certificate without common name")
+		}
+		return cert.Subject.CommonName, nil
+	}
+	sans := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	if len(sans) == 0 {
+		return "", errors.New("client certificate without SAN")
+	}
+	for _, san := range sans {
+		if _, ok := a.tenants[san]; ok {
+			return san, nil
+		}
+	}
+	return sans[0], nil
+}
+
+// tenant returns the tenant of an identity.
+func (a *Authenticator) tenant(identity string) string {
+	if t, ok := a.tenants[identity]; ok {
+		return t
+	}
+	return identity
+}
+
+// Label sets the identity label of tss to the identity carried by ctx,
+// replacing any value sent by the client. Labels aren't expected sorted; a
+// missing label is inserted before the first greater name.
+func (a *Authenticator) Label(ctx context.Context, tss []prompb.TimeSeries) []prompb.TimeSeries {
+	if a == nil || a.label == "" {
+		return tss
+	}
+	identity := IdentityFromContext(ctx)
+	if identity == "" {
+		return tss
+	}
+	for i := range tss {
+		lbs := tss[i].Labels
+		found, j := false, len(lbs)
+		for k := range lbs {
+			switch {
+			case lbs[k].Name == a.label:
+				lbs[k].Value, found = identity, true
+			case lbs[k].Name > a.label && k < j:
+				j = k
+			}
+		}
+		if found {
+			continue
+		}
+		lbs = append(lbs, prompb.Label{})
+		copy(lbs[j+1:], lbs[j:])
+		lbs[j] = prompb.Label{Name: a.label, Value: identity}
+		tss[i].Labels = lbs
+	}
+	return tss
+}
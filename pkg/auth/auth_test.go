@@ -0,0 +1,292 @@
+package auth_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stream-metrics-route/pkg/auth"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// engine answers authenticated requests with their identity and tenant.
+func engine(t *testing.T, cfg *setting.AuthConf) *gin.Engine {
+	a, err := auth.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Group("api/v1", a.Middleware()).POST("write", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.String(http.StatusOK, auth.IdentityFromContext(ctx)+"/"+tenant.FromContext(ctx))
+	})
+	return e
+}
+
+func send(e http.Handler, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func expect(t *testing.T, rec *httptest.ResponseRecorder, code int, body string) {
+	t.Helper()
+	if rec.Code != code || (body != "" && rec.Body.String() != body) {
+		t.Fatalf("expected %d %q, got %d %q", code, body, rec.Code, rec.Body.String())
+	}
+}
+
+func TestBearerAndBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := filepath.Join(dir, "users")
+	if err := os.WriteFile(users, []byte("# users\nalice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e := engine(t, &setting.AuthConf{
+		BearerTokens:     []setting.BearerTokenConf{{Token: "token-a", Identity: "prometheus-a"}},
+		BasicAuthFile:    users,
+		Tenants:          map[string]string{"alice": "team-a"},
+		IdentityAsTenant: true,
+	})
+
+	expect(t, send(e, map[string]string{"Authorization": "Bearer token-a"}), http.StatusOK, "prometheus-a/prometheus-a")
+	expect(t, send(e, map[string]string{"Authorization": "Bearer token-b"}), http.StatusUnauthorized, "")
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	for i := 0; i < 2; i++ {
+		expect(t, send(e, map[string]string{"Authorization": basic}), http.StatusOK, "alice/team-a")
+	}
+	wrong := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:wrong"))
+	expect(t, send(e, map[string]string{"Authorization": wrong}), http.StatusUnauthorized, "")
+	unknown := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:secret"))
+	expect(t, send(e, map[string]string{"Authorization": unknown}), http.StatusUnauthorized, "")
+	rec := send(e, nil)
+	expect(t, rec, http.StatusUnauthorized, "")
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("expected a WWW-Authenticate header")
+	}
+
+	if _, err := auth.New(&setting.AuthConf{BasicAuthFile: filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("expected an error for a missing users file")
+	}
+	if _, err := auth.New(&setting.AuthConf{IdentityLabel: "identity"}); err == nil {
+		t.Fatal("expected an error without any method")
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := engine(t, &setting.AuthConf{
+		JWT:              &setting.JWTAuthConf{JWKSFile: file, Issuer: "issuer", Audience: "stream", IdentityClaim: "tenant"},
+		IdentityAsTenant: true,
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "issuer", "aud": []string{"other", "stream"}, "exp": exp, "tenant": "team-a"}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	expect(t, send(e, bearer(sign(t, "RS256", "rsa", rsaKey, claims(nil)))), http.StatusOK, "team-a/team-a")
+	expect(t, send(e, bearer(sign(t, "ES256", "ec", ecKey, claims(nil)))), http.StatusOK, "team-a/team-a")
+	for name, token := range map[string]string{
+		"expired":       sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"not yet valid": sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": exp})),
+		"audience":      sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+		"issuer":        sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "other"})),
+		"identity":      sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"tenant": nil})),
+		"wrong key":     sign(t, "RS256", "ec", rsaKey, claims(nil)),
+		"algorithm":     sign(t, "ES256", "rsa", ecKey, claims(nil)),
+		"none":          b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"tenant":"team-a"}`)) + ".",
+	} {
+		if rec := send(e, bearer(token)); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, rec.Code)
+		}
+	}
+}
+
+func certificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClientCertAuth(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := certificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	client, clientKey := certificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "prometheus-a"},
+		DNSNames:     []string{"prometheus.example", "prometheus-a.example"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	rogue, rogueKey := certificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "prometheus-a"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(cfg *setting.AuthConf, cert *x509.Certificate, key *ecdsa.PrivateKey) (int, string) {
+		srv := httptest.NewUnstartedServer(engine(t, cfg))
+		srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		srv.StartTLS()
+		defer srv.Close()
+		transport := srv.Client().Transport.(*http.Transport)
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		resp, err := srv.Client().Post(srv.URL+"/api/v1/write", "application/x-protobuf", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	cfg := &setting.AuthConf{
+		ClientCert:       &setting.ClientCertAuthConf{CAFile: caFile},
+		Tenants:          map[string]string{"prometheus-a": "team-a", "prometheus-a.example": "team-b"},
+		IdentityAsTenant: true,
+	}
+	if code, body := get(cfg, client, clientKey); code != http.StatusOK || body != "prometheus-a/team-a" {
+		t.Fatalf("expected the common name identity, got %d %q", code, body)
+	}
+	if code, _ := get(cfg, rogue, rogueKey); code != http.StatusUnauthorized {
+		t.Fatalf("expected a certificate of another CA to be refused, got %d", code)
+	}
+	cfg.ClientCert.Identity = "san"
+	if code, body := get(cfg, client, clientKey); code != http.StatusOK || body != "prometheus-a.example/team-b" {
+		t.Fatalf("expected the mapped SAN identity, got %d %q", code, body)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	a, err := auth.New(&setting.AuthConf{
+		BearerTokens:  []setting.BearerTokenConf{{Token: "token", Identity: "prometheus-a"}},
+		IdentityLabel: "identity",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tss := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "identity", Value: "spoofed"}}},
+	}
+	tss = a.Label(auth.WithIdentity(context.Background(), "prometheus-a"), tss)
+	for _, ts := range tss {
+		if ts.Labels[1].Name != "identity" || ts.Labels[1].Value != "prometheus-a" {
+			t.Fatalf("expected the identity label in order, got %v", ts.Labels)
+		}
+	}
+	// Unsorted labels keep a single identity label.
+	unsorted := []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "zone", Value: "eu"}, {Name: "identity", Value: "spoofed"}, {Name: "__name__", Value: "up"}}}}
+	unsorted = a.Label(auth.WithIdentity(context.Background(), "prometheus-a"), unsorted)
+	if lbs := unsorted[0].Labels; len(lbs) != 3 || lbs[1].Value != "prometheus-a" {
+		t.Fatalf("expected the identity label to be replaced, got %v", lbs)
+	}
+	var none *auth.Authenticator
+	if got := none.Label(context.Background(), tss); len(got) != 2 {
+		t.Fatal("expected a nil authenticator to keep series")
+	}
+}
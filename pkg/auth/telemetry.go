@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_auth"
+
+var (
+	authRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "requests_total",
+			Help:      "Count of authenticated requests by method and result",
+		}, []string{"method", "result"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(authRequests)
+}
@@ -0,0 +1,157 @@
+package batcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Batcher groups the items added by concurrent Store calls and hands them to
+// flush in batches of at most maxItems, or whatever has accumulated once
+// interval elapsed. Batches are flushed by a fixed number of workers; Add
+// blocks when all of them are busy so memory stays bounded.
+type Batcher[T any] struct {
+	maxItems int
+	interval time.Duration
+	flush    func([]T)
+
+	lock    sync.Mutex
+	items   []T
+	closed  bool
+	sending sync.RWMutex
+	queue   chan []T
+	stop    chan struct{}
+	workers sync.WaitGroup
+}
+
+func New[T any](maxItems int, interval time.Duration, workers int, flush func([]T)) *Batcher[T] {
+	if workers <= 0 {
+		workers = 1
+	}
+	b := &Batcher[T]{
+		maxItems: maxItems,
+		interval: interval,
+		flush:    flush,
+		queue:    make(chan []T, workers),
+		stop:     make(chan struct{}),
+	}
+	b.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer b.workers.Done()
+			for items := range b.queue {
+				b.flush(items)
+			}
+		}()
+	}
+	go b.tick()
+	return b
+}
+
+// Add buffers items. Once the Batcher is closed they are flushed right away.
+func (b *Batcher[T]) Add(items ...T) {
+	var full [][]T
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		if len(items) > 0 {
+			b.flush(items)
+		}
+		return
+	}
+	for len(items) > 0 {
+		n := b.maxItems - len(b.items)
+		if n > len(items) {
+			n = len(items)
+		}
+		b.items = append(b.items, items[:n]...)
+		items = items[n:]
+		if len(b.items) >= b.maxItems {
+			full = append(full, b.items)
+			b.items = make([]T, 0, b.maxItems)
+		}
+	}
+	// Close waits for the batches taken from the buffer before it was closed.
+	b.sending.RLock()
+	defer b.sending.RUnlock()
+	b.lock.Unlock()
+
+	for _, items := range full {
+		b.queue <- items
+	}
+}
+
+// Flush hands the buffered items to a worker without waiting for the batch
+// to fill up.
+func (b *Batcher[T]) Flush() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	items := b.items
+	b.items = nil
+	b.sending.RLock()
+	defer b.sending.RUnlock()
+	b.lock.Unlock()
+	if len(items) > 0 {
+		b.queue <- items
+	}
+}
+
+// Close flushes the buffered items and waits until the workers handed every
+// batch to flush.
+func (b *Batcher[T]) Close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	items := b.items
+	b.items = nil
+	b.lock.Unlock()
+
+	close(b.stop)
+	b.sending.Lock()
+	defer b.sending.Unlock()
+	if len(items) > 0 {
+		b.queue <- items
+	}
+	close(b.queue)
+	b.workers.Wait()
+}
+
+func (b *Batcher[T]) tick() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
+
+// Retry calls fn until it succeeds, reports the error as not retryable or
+// retries extra attempts were made, sleeping between attempts with an
+// exponential backoff starting at minBackoff and capped at maxBackoff.
+func Retry(ctx context.Context, retries int, minBackoff, maxBackoff time.Duration, fn func() (bool, error)) error {
+	backoff := minBackoff
+	for i := 0; ; i++ {
+		retryable, err := fn()
+		if err == nil || !retryable || i >= retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package batcher_test
+
+import (
+	"context"
+	"errors"
+	"stream-metrics-route/pkg/batcher"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flushed struct {
+	lock    sync.Mutex
+	batches [][]int
+}
+
+func (f *flushed) flush(items []int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.batches = append(f.batches, items)
+}
+
+func (f *flushed) get() [][]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([][]int(nil), f.batches...)
+}
+
+func TestBatcherFlushOnSize(t *testing.T) {
+	f := &flushed{}
+	b := batcher.New(3, time.Hour, 1, f.flush)
+	b.Add(1, 2)
+	b.Add(3, 4, 5, 6, 7)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.get()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two full batches, got %v", f.get())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	batches := f.get()
+	if len(batches) != 2 || len(batches[0]) != 3 || batches[0][2] != 3 || len(batches[1]) != 3 || batches[1][2] != 6 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+
+	b.Close()
+	batches = f.get()
+	if len(batches) != 3 || len(batches[2]) != 1 || batches[2][0] != 7 {
+		t.Fatalf("expected the last item flushed on close, got %v", batches)
+	}
+	// Items added once closed are flushed right away.
+	b.Add(8)
+	if batches = f.get(); len(batches) != 4 || batches[3][0] != 8 {
+		t.Fatalf("expected the item flushed after close, got %v", batches)
+	}
+	b.Close()
+}
+
+func TestBatcherFlushOnInterval(t *testing.T) {
+	f := &flushed{}
+	b := batcher.New(100, 20*time.Millisecond, 2, f.flush)
+	defer b.Close()
+	b.Add(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the batch flushed after the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if batches := f.get(); len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+}
+
+func TestBatcherCloseWaitsForWorkers(t *testing.T) {
+	var lock sync.Mutex
+	flushed := 0
+	b := batcher.New(1, time.Hour, 2, func(items []int) {
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		flushed += len(items)
+		lock.Unlock()
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b.Add(i)
+		}(i)
+	}
+	wg.Wait()
+	b.Close()
+	lock.Lock()
+	defer lock.Unlock()
+	if flushed != 4 {
+		t.Fatalf("expected close to wait for all batches, got %d", flushed)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	fail := errors.New("fail")
+	for _, tc := range []struct {
+		name      string
+		retries   int
+		retryable bool
+		succeedAt int
+		calls     int
+		err       error
+	}{
+		{"success", 3, true, 1, 1, nil},
+		{"retried until success", 3, true, 3, 3, nil},
+		{"retries exhausted", 2, true, 0, 3, fail},
+		{"no retries", 0, true, 0, 1, fail},
+		{"not retryable", 3, false, 0, 1, fail},
+	} {
+		calls := 0
+		var sleeps []time.Duration
+		last := time.Now()
+		err := batcher.Retry(context.Background(), tc.retries, time.Millisecond, 2*time.Millisecond, func() (bool, error) {
+			now := time.Now()
+			sleeps = append(sleeps, now.Sub(last))
+			last = now
+			calls++
+			if calls == tc.succeedAt {
+				return false, nil
+			}
+			return tc.retryable, fail
+		})
+		if err != tc.err || calls != tc.calls {
+			t.Fatalf("%s: expected %d calls and %v, got %d and %v", tc.name, tc.calls, tc.err, calls, err)
+		}
+		for i, sleep := range sleeps[1:] {
+			if sleep < time.Millisecond {
+				t.Fatalf("%s: expected a backoff before attempt %d, got %v", tc.name, i+2, sleep)
+			}
+		}
+	}
+
+	// Retries stop once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := batcher.Retry(ctx, 10, time.Hour, time.Hour, func() (bool, error) {
+		calls++
+		cancel()
+		return true, fail
+	})
+	if err != fail || calls != 1 {
+		t.Fatalf("expected one call after cancel, got %d and %v", calls, err)
+	}
+}
@@ -0,0 +1,147 @@
+package cardinality
+
+import (
+	"stream-metrics-route/pkg/common"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bloomfilter"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxOffenders bounds the metric and label names remembered for the status
+// endpoint so a cardinality explosion can't turn into a memory one.
+const maxOffenders = 10000
+
+// Limiter drops series first seen after the hourly or daily cap on unique
+// series was reached. Series are tracked by their label hash in bloom
+// filters that are reset every hour and every day.
+type Limiter struct {
+	scope  string
+	name   string
+	hourly *bloomfilter.Limiter
+	daily  *bloomfilter.Limiter
+
+	lock           sync.Mutex
+	droppedByName  map[string]uint64
+	droppedByLabel map[string]uint64
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*Limiter)
+)
+
+// Limiters are either the global one, applied before routing, or the one
+// of a route.
+const (
+	scopeGlobal = "global"
+	scopeRoute  = "route"
+)
+
+// New returns the limiter of the route name, or nil when cfg sets no cap;
+// a nil Limiter passes every series. The limiter is registered under name
+// for the status endpoint, replacing and stopping any previous limiter of
+// that route.
+func New(name string, cfg setting.CardinalityLimitsConf) *Limiter {
+	return newLimiter(scopeRoute, name, cfg)
+}
+
+// NewGlobal is New for the global limiter, kept apart from every route
+// whatever its name.
+func NewGlobal(cfg setting.CardinalityLimitsConf) *Limiter {
+	return newLimiter(scopeGlobal, scopeGlobal, cfg)
+}
+
+func newLimiter(scope, name string, cfg setting.CardinalityLimitsConf) *Limiter {
+	if cfg.MaxHourlySeries <= 0 && cfg.MaxDailySeries <= 0 {
+		return nil
+	}
+	l := &Limiter{
+		scope:          scope,
+		name:           name,
+		droppedByName:  make(map[string]uint64),
+		droppedByLabel: make(map[string]uint64),
+	}
+	if cfg.MaxHourlySeries > 0 {
+		l.hourly = bloomfilter.NewLimiter(cfg.MaxHourlySeries, time.Hour)
+		cardinalityMaxSeries.WithLabelValues(scope, name, "hourly").Set(float64(cfg.MaxHourlySeries))
+	}
+	if cfg.MaxDailySeries > 0 {
+		l.daily = bloomfilter.NewLimiter(cfg.MaxDailySeries, 24*time.Hour)
+		cardinalityMaxSeries.WithLabelValues(scope, name, "daily").Set(float64(cfg.MaxDailySeries))
+	}
+
+	registryLock.Lock()
+	if prev := registry[scope+"/"+name]; prev != nil {
+		prev.stop()
+	}
+	registry[scope+"/"+name] = l
+	registryLock.Unlock()
+	defaultTelemetry.Logger.Debug("create cardinality limiter", "scope", scope, "name", name, "max_hourly_series", cfg.MaxHourlySeries, "max_daily_series", cfg.MaxDailySeries)
+	return l
+}
+
+func (l *Limiter) stop() {
+	if l.hourly != nil {
+		l.hourly.MustStop()
+	}
+	if l.daily != nil {
+		l.daily.MustStop()
+	}
+}
+
+// Filter drops the series over the caps; tss is filtered in place.
+func (l *Limiter) Filter(tss []prompb.TimeSeries) []prompb.TimeSeries {
+	if l == nil {
+		return tss
+	}
+	out := tss[:0]
+	var droppedHourly, droppedDaily int
+	for _, ts := range tss {
+		h := uint64(common.SortLabelsHashKey(ts.Labels))
+		if l.hourly != nil && !l.hourly.Add(h) {
+			droppedHourly++
+			l.recordDropped(ts.Labels)
+			continue
+		}
+		if l.daily != nil && !l.daily.Add(h) {
+			droppedDaily++
+			l.recordDropped(ts.Labels)
+			continue
+		}
+		out = append(out, ts)
+	}
+	if l.hourly != nil {
+		cardinalityCurrentSeries.WithLabelValues(l.scope, l.name, "hourly").Set(float64(l.hourly.CurrentItems()))
+		if droppedHourly > 0 {
+			cardinalityDroppedSeries.WithLabelValues(l.scope, l.name, "hourly").Add(float64(droppedHourly))
+		}
+	}
+	if l.daily != nil {
+		cardinalityCurrentSeries.WithLabelValues(l.scope, l.name, "daily").Set(float64(l.daily.CurrentItems()))
+		if droppedDaily > 0 {
+			cardinalityDroppedSeries.WithLabelValues(l.scope, l.name, "daily").Add(float64(droppedDaily))
+		}
+	}
+	return out
+}
+
+func (l *Limiter) recordDropped(labels []prompb.Label) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, lb := range labels {
+		if lb.Name == "__name__" {
+			countOffender(l.droppedByName, lb.Value)
+			continue
+		}
+		countOffender(l.droppedByLabel, lb.Name)
+	}
+}
+
+func countOffender(m map[string]uint64, key string) {
+	if _, ok := m[key]; ok || len(m) < maxOffenders {
+		m[key]++
+	}
+}
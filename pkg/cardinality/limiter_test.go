@@ -0,0 +1,136 @@
+package cardinality_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"stream-metrics-route/pkg/cardinality"
+	"stream-metrics-route/pkg/setting"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func makeSeries(name string, n int, label string) []prompb.TimeSeries {
+	tss := make([]prompb.TimeSeries, 0, n)
+	for i := 0; i < n; i++ {
+		tss = append(tss, prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: label, Value: strconv.Itoa(i)},
+			},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	return tss
+}
+
+func TestLimiter(t *testing.T) {
+	if l := cardinality.New("disabled", setting.CardinalityLimitsConf{}); l != nil {
+		t.Fatal("expected no limiter without caps")
+	}
+
+	l := cardinality.New("test", setting.CardinalityLimitsConf{MaxHourlySeries: 5, MaxDailySeries: 8})
+	if out := l.Filter(makeSeries("http_requests_total", 5, "instance")); len(out) != 5 {
+		t.Fatalf("expected series within the cap to pass, got %d", len(out))
+	}
+	// Known series keep passing once the cap is reached, new ones are dropped.
+	if out := l.Filter(makeSeries("http_requests_total", 8, "instance")); len(out) != 5 {
+		t.Fatalf("expected only the known series to pass, got %d", len(out))
+	}
+	if out := l.Filter(makeSeries("api_latency", 3, "request_id")); len(out) != 0 {
+		t.Fatalf("expected new series over the hourly cap to be dropped, got %d", len(out))
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/-/cardinality", cardinality.Status)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/cardinality?top=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status endpoint returned %d", rec.Code)
+	}
+	var resp struct {
+		Data []struct {
+			Name                string `json:"name"`
+			MaxHourlySeries     int    `json:"max_hourly_series"`
+			CurrentHourlySeries int    `json:"current_hourly_series"`
+			MaxDailySeries      int    `json:"max_daily_series"`
+			TopMetricNames      []struct {
+				Name          string `json:"name"`
+				DroppedSeries uint64 `json:"dropped_series"`
+			} `json:"top_metric_names"`
+			TopLabelNames []struct {
+				Name          string `json:"name"`
+				DroppedSeries uint64 `json:"dropped_series"`
+			} `json:"top_label_names"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, s := range resp.Data {
+		if s.Name != "test" {
+			continue
+		}
+		found = true
+		if s.MaxHourlySeries != 5 || s.CurrentHourlySeries != 5 || s.MaxDailySeries != 8 {
+			t.Fatalf("unexpected limits %+v", s)
+		}
+		if len(s.TopMetricNames) != 2 || s.TopMetricNames[0].Name != "api_latency" || s.TopMetricNames[0].DroppedSeries != 3 ||
+			s.TopMetricNames[1].Name != "http_requests_total" || s.TopMetricNames[1].DroppedSeries != 3 {
+			t.Fatalf("unexpected top metric names %+v", s.TopMetricNames)
+		}
+		if len(s.TopLabelNames) != 2 || s.TopLabelNames[0].Name != "instance" || s.TopLabelNames[1].Name != "request_id" {
+			t.Fatalf("unexpected top label names %+v", s.TopLabelNames)
+		}
+	}
+	if !found {
+		t.Fatal("limiter missing from the status endpoint")
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/cardinality?top=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid top, got %d", rec.Code)
+	}
+}
+
+func TestGlobalLimiterApartFromRoutes(t *testing.T) {
+	global := cardinality.NewGlobal(setting.CardinalityLimitsConf{MaxHourlySeries: 2})
+	route := cardinality.New("global", setting.CardinalityLimitsConf{MaxHourlySeries: 4})
+	if out := global.Filter(makeSeries("up", 3, "instance")); len(out) != 2 {
+		t.Fatalf("expected the global cap, got %d series", len(out))
+	}
+	if out := route.Filter(makeSeries("up", 3, "instance")); len(out) != 3 {
+		t.Fatalf("expected the route cap, got %d series", len(out))
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/-/cardinality", cardinality.Status)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/cardinality", nil))
+	var resp struct {
+		Data []struct {
+			Scope           string `json:"scope"`
+			Name            string `json:"name"`
+			MaxHourlySeries int    `json:"max_hourly_series"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	caps := make(map[string]int)
+	for _, s := range resp.Data {
+		if s.Name == "global" {
+			caps[s.Scope] = s.MaxHourlySeries
+		}
+	}
+	if len(caps) != 2 || caps["global"] != 2 || caps["route"] != 4 {
+		t.Fatalf("expected the global limiter and the route named global listed apart, got %v", caps)
+	}
+}
@@ -0,0 +1,92 @@
+package cardinality
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var defaultTopN = 10
+
+type offender struct {
+	Name          string `json:"name"`
+	DroppedSeries uint64 `json:"dropped_series"`
+}
+
+type limiterStatus struct {
+	Scope               string     `json:"scope"`
+	Name                string     `json:"name"`
+	MaxHourlySeries     int        `json:"max_hourly_series"`
+	CurrentHourlySeries int        `json:"current_hourly_series"`
+	MaxDailySeries      int        `json:"max_daily_series"`
+	CurrentDailySeries  int        `json:"current_daily_series"`
+	TopMetricNames      []offender `json:"top_metric_names"`
+	TopLabelNames       []offender `json:"top_label_names"`
+}
+
+// Status lists every limiter with the metric names and label names of
+// the series it dropped most often. `?top=N` sets how many are listed.
+func Status(c *gin.Context) {
+	topN := defaultTopN
+	if v := c.Query("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 4000, "msg": "top must be a positive integer", "data": nil})
+			return
+		}
+		topN = n
+	}
+
+	registryLock.Lock()
+	limiters := make([]*Limiter, 0, len(registry))
+	for _, l := range registry {
+		limiters = append(limiters, l)
+	}
+	registryLock.Unlock()
+	sort.Slice(limiters, func(i, j int) bool {
+		if limiters[i].scope != limiters[j].scope {
+			return limiters[i].scope < limiters[j].scope
+		}
+		return limiters[i].name < limiters[j].name
+	})
+
+	data := make([]limiterStatus, 0, len(limiters))
+	for _, l := range limiters {
+		data = append(data, l.status(topN))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 2000, "msg": "ok", "data": data})
+}
+
+func (l *Limiter) status(topN int) limiterStatus {
+	s := limiterStatus{Scope: l.scope, Name: l.name}
+	if l.hourly != nil {
+		s.MaxHourlySeries, s.CurrentHourlySeries = l.hourly.MaxItems(), l.hourly.CurrentItems()
+	}
+	if l.daily != nil {
+		s.MaxDailySeries, s.CurrentDailySeries = l.daily.MaxItems(), l.daily.CurrentItems()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s.TopMetricNames = topOffenders(l.droppedByName, topN)
+	s.TopLabelNames = topOffenders(l.droppedByLabel, topN)
+	return s
+}
+
+func topOffenders(m map[string]uint64, n int) []offender {
+	all := make([]offender, 0, len(m))
+	for k, v := range m {
+		all = append(all, offender{Name: k, DroppedSeries: v})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].DroppedSeries != all[j].DroppedSeries {
+			return all[i].DroppedSeries > all[j].DroppedSeries
+		}
+		return all[i].Name < all[j].Name
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
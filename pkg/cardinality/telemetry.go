@@ -0,0 +1,39 @@
+package cardinality
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_cardinality"
+
+var (
+	cardinalityMaxSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "max_series",
+			Help:      "Configured cap on unique series per window",
+		}, []string{"scope", "limiter", "window"})
+	cardinalityCurrentSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "current_series",
+			Help:      "Unique series seen in the current window",
+		}, []string{"scope", "limiter", "window"})
+	cardinalityDroppedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dropped_series_total",
+			Help:      "Count of series dropped because a cap was reached",
+		}, []string{"scope", "limiter", "window"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(cardinalityMaxSeries)
+	defaultTelemetry.Register(cardinalityCurrentSeries)
+	defaultTelemetry.Register(cardinalityDroppedSeries)
+}
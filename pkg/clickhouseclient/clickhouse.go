@@ -0,0 +1,209 @@
+package clickhouseclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"stream-metrics-route/pkg/batcher"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultBatchSize     = 10000
+	defaultFlushInterval = 5 * time.Second
+	defaultConcurrency   = 2
+	defaultTimeout       = 30 * time.Second
+	defaultMaxRetries    = 3
+)
+
+const maxErrMsgLen = 1024
+
+// ClickHouseClient batches routed samples and inserts them through the
+// ClickHouse HTTP interface.
+type ClickHouseClient struct {
+	name       string
+	insertURL  string
+	username   string
+	password   string
+	format     string
+	columns    [4]string
+	timeout    time.Duration
+	maxRetries int
+	client     *http.Client
+	batcher    *batcher.Batcher[row]
+}
+
+func NewClickHouse(name string, cfg setting.ClickHouseConfig) (*ClickHouseClient, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil || cfg.URL == "" {
+		return nil, fmt.Errorf("couldn't parse the clickhouse url %q", cfg.URL)
+	}
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("clickhouse table is required")
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = "RowBinary"
+	case "RowBinary", "JSONEachRow":
+	default:
+		return nil, fmt.Errorf("unknown clickhouse format %q", cfg.Format)
+	}
+	columns := [4]string{cfg.Columns.Name, cfg.Columns.Labels, cfg.Columns.Timestamp, cfg.Columns.Value}
+	for i, def := range [4]string{"name", "labels", "ts", "value"} {
+		if columns[i] == "" {
+			columns[i] = def
+		}
+	}
+
+	table := quoteIdentifier(cfg.Table)
+	if cfg.Database != "" {
+		table = quoteIdentifier(cfg.Database) + "." + table
+	}
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdentifier(c)
+	}
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("INSERT INTO %s (%s) FORMAT %s", table, strings.Join(quoted, ", "), cfg.Format))
+	if cfg.AsyncInsert {
+		query.Set("async_insert", "1")
+		if cfg.WaitForAsyncInsert {
+			query.Set("wait_for_async_insert", "1")
+		} else {
+			query.Set("wait_for_async_insert", "0")
+		}
+	}
+	base.RawQuery = query.Encode()
+
+	httpConfig := config.DefaultHTTPClientConfig
+	httpConfig.TLSConfig = cfg.TLSConfig
+	httpClient, err := config.NewClientFromConfig(httpConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ClickHouseClient{
+		name:       name,
+		insertURL:  base.String(),
+		username:   cfg.Username,
+		password:   cfg.Password,
+		format:     cfg.Format,
+		columns:    columns,
+		timeout:    time.Duration(cfg.Timeout),
+		maxRetries: defaultMaxRetries,
+		client:     httpClient,
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("clickhouse max_retries must not be negative")
+		}
+		c.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	c.batcher = batcher.New(cfg.BatchSize, flushInterval, cfg.Concurrency, c.insert)
+	defaultTelemetry.Logger.Debug("create clickhouse client", "name", name, "url", cfg.URL, "table", table, "format", cfg.Format)
+	return c, nil
+}
+
+func (c *ClickHouseClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	clickhouseTimeseries.WithLabelValues(c.name).Add(float64(len(req)))
+	var rows []row
+	for _, ts := range req {
+		rows = newRows(rows, ts)
+	}
+	c.batcher.Add(rows...)
+	return 0, nil
+}
+
+// Close inserts the buffered rows and waits for the inserts in flight.
+func (c *ClickHouseClient) Close() error {
+	c.batcher.Close()
+	return nil
+}
+
+func (c *ClickHouseClient) insert(rows []row) {
+	var body []byte
+	var err error
+	if c.format == "JSONEachRow" {
+		body, err = encodeJSONEachRow(nil, rows, c.columns)
+	} else {
+		body = encodeRowBinary(nil, rows)
+	}
+	if err != nil {
+		clickhouseRowsFailed.WithLabelValues(c.name).Add(float64(len(rows)))
+		defaultTelemetry.Logger.Error("clickhouse encode error", "name", c.name, "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout*time.Duration(c.maxRetries+1))
+	defer cancel()
+	err = batcher.Retry(ctx, c.maxRetries, 500*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return c.post(ctx, body)
+	})
+	if err != nil {
+		clickhouseRowsFailed.WithLabelValues(c.name).Add(float64(len(rows)))
+		defaultTelemetry.Logger.Error("clickhouse insert error", "name", c.name, "rows", len(rows), "err", err)
+		return
+	}
+	clickhouseRowsWritten.WithLabelValues(c.name).Add(float64(len(rows)))
+}
+
+// post sends one INSERT and reports whether a failure is worth retrying.
+func (c *ClickHouseClient) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.insertURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", "stream-metrics-route")
+	if c.username != "" {
+		req.Header.Set("X-ClickHouse-User", c.username)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+	line := ""
+	if scanner.Scan() {
+		line = scanner.Text()
+	}
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+func quoteIdentifier(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "\\`") + "`"
+}
@@ -0,0 +1,198 @@
+package clickhouseclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"stream-metrics-route/pkg/clickhouseclient"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/sinktest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// exception answers the first `failures` inserts like an overloaded server.
+func exception(failures int) sinktest.Handler {
+	return func(sinktest.Request) (int, string) {
+		if failures--; failures >= 0 {
+			return http.StatusServiceUnavailable, "Code: 202. DB::Exception: Too many simultaneous queries"
+		}
+		return http.StatusOK, ""
+	}
+}
+
+func testSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{sinktest.Series("up", []string{"instance", "a:9100", "job", "node"},
+		prompb.Sample{Value: 1, Timestamp: 1700000000123}, prompb.Sample{Value: 0.5, Timestamp: 1700000001000})}
+}
+
+func readString(t *testing.T, r *bufio.Reader) string {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestClickHouseRowBinary(t *testing.T) {
+	s := sinktest.NewServer(t, exception(1))
+	c, err := clickhouseclient.NewClickHouse("test", setting.ClickHouseConfig{
+		URL:           s.URL,
+		Database:      "metrics",
+		Table:         "samples",
+		Username:      "writer",
+		Password:      "secret",
+		AsyncInsert:   true,
+		BatchSize:     2,
+		FlushInterval: model.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.WaitRequests(t, 2)
+	r := bufio.NewReader(bytes.NewReader(requests[1].Body))
+
+	wantTs := []int64{1700000000123, 1700000001000}
+	wantValue := []float64{1, 0.5}
+	for i := 0; i < 2; i++ {
+		if name := readString(t, r); name != "up" {
+			t.Fatalf("row %d: name = %q", i, name)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n != 2 {
+			t.Fatalf("row %d: map size = %d, %v", i, n, err)
+		}
+		labels := map[string]string{}
+		for j := uint64(0); j < n; j++ {
+			k := readString(t, r)
+			labels[k] = readString(t, r)
+		}
+		if labels["instance"] != "a:9100" || labels["job"] != "node" {
+			t.Fatalf("row %d: labels = %v", i, labels)
+		}
+		var fixed [16]byte
+		if _, err := io.ReadFull(r, fixed[:]); err != nil {
+			t.Fatal(err)
+		}
+		if ts := int64(binary.LittleEndian.Uint64(fixed[:8])); ts != wantTs[i] {
+			t.Fatalf("row %d: ts = %d", i, ts)
+		}
+		if v := math.Float64frombits(binary.LittleEndian.Uint64(fixed[8:])); v != wantValue[i] {
+			t.Fatalf("row %d: value = %v", i, v)
+		}
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatal("unexpected trailing data in RowBinary body")
+	}
+
+	query := requests[1].Query
+	if want := "INSERT INTO `metrics`.`samples` (`name`, `labels`, `ts`, `value`) FORMAT RowBinary"; query.Get("query") != want {
+		t.Fatalf("query = %q, want %q", query.Get("query"), want)
+	}
+	if query.Get("async_insert") != "1" || query.Get("wait_for_async_insert") != "0" {
+		t.Fatalf("unexpected settings %v", query)
+	}
+	if user := requests[1].Header.Get("X-ClickHouse-User"); user != "writer" {
+		t.Fatalf("X-ClickHouse-User = %q", user)
+	}
+}
+
+func TestClickHouseJSONEachRow(t *testing.T) {
+	s := sinktest.NewServer(t, exception(0))
+	c, err := clickhouseclient.NewClickHouse("test", setting.ClickHouseConfig{
+		URL:           s.URL,
+		Table:         "samples",
+		Format:        "JSONEachRow",
+		Columns:       setting.ClickHouseColumns{Name: "metric", Timestamp: "time"},
+		BatchSize:     2,
+		FlushInterval: model.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	request := s.WaitRequests(t, 1)[0]
+	lines := bytes.Split(bytes.TrimSpace(request.Body), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(lines))
+	}
+	var doc struct {
+		Metric string            `json:"metric"`
+		Labels map[string]string `json:"labels"`
+		Time   string            `json:"time"`
+		Value  float64           `json:"value"`
+	}
+	if err := json.Unmarshal(lines[0], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Metric != "up" || doc.Labels["job"] != "node" || doc.Time != "1700000000.123" || doc.Value != 1 {
+		t.Fatalf("unexpected row %s", lines[0])
+	}
+
+	if want := "INSERT INTO `samples` (`metric`, `labels`, `time`, `value`) FORMAT JSONEachRow"; request.Query.Get("query") != want {
+		t.Fatalf("query = %q, want %q", request.Query.Get("query"), want)
+	}
+	if request.Query.Has("async_insert") {
+		t.Fatal("async_insert set without being configured")
+	}
+}
+
+func TestClickHouseRetries(t *testing.T) {
+	zero := 0
+	for _, tc := range []struct {
+		name       string
+		handler    sinktest.Handler
+		maxRetries *int
+		inserts    int
+	}{
+		{"retried server error", exception(2), nil, 3},
+		{"retries disabled", exception(1), &zero, 1},
+		{"client error", sinktest.Statuses(http.StatusBadRequest), nil, 1},
+	} {
+		s := sinktest.NewServer(t, tc.handler)
+		c, err := clickhouseclient.NewClickHouse("test", setting.ClickHouseConfig{URL: s.URL, Table: "samples", MaxRetries: tc.maxRetries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Store(context.Background(), testSeries()); err != nil {
+			t.Fatal(err)
+		}
+		// Close inserts the buffered rows before returning.
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.Requests()); n != tc.inserts {
+			t.Fatalf("%s: expected %d inserts, got %d", tc.name, tc.inserts, n)
+		}
+	}
+}
+
+func TestClickHouseConfigErrors(t *testing.T) {
+	negative := -1
+	sinktest.ConfigErrors(t, func(cfg setting.ClickHouseConfig) error {
+		_, err := clickhouseclient.NewClickHouse("test", cfg)
+		return err
+	},
+		setting.ClickHouseConfig{Table: "samples"},
+		setting.ClickHouseConfig{URL: "http://localhost:8123"},
+		setting.ClickHouseConfig{URL: "http://localhost:8123", Table: "samples", Format: "Native"},
+		setting.ClickHouseConfig{URL: "http://localhost:8123", Table: "samples", MaxRetries: &negative},
+	)
+}
@@ -0,0 +1,94 @@
+package clickhouseclient
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// row is a single sample matching the (name, labels, ts, value) schema.
+type row struct {
+	name   string
+	labels []prompb.Label
+	ts     int64
+	value  float64
+}
+
+func newRows(dst []row, ts prompb.TimeSeries) []row {
+	var name string
+	labels := make([]prompb.Label, 0, len(ts.Labels))
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		labels = append(labels, l)
+	}
+	for _, s := range ts.Samples {
+		dst = append(dst, row{name: name, labels: labels, ts: s.Timestamp, value: s.Value})
+	}
+	return dst
+}
+
+// encodeRowBinary encodes rows for a table declared as
+// (String, Map(String, String), DateTime64(3), Float64).
+func encodeRowBinary(dst []byte, rows []row) []byte {
+	appendString := func(dst []byte, s string) []byte {
+		dst = binary.AppendUvarint(dst, uint64(len(s)))
+		return append(dst, s...)
+	}
+	for _, r := range rows {
+		dst = appendString(dst, r.name)
+		dst = binary.AppendUvarint(dst, uint64(len(r.labels)))
+		for _, l := range r.labels {
+			dst = appendString(dst, l.Name)
+			dst = appendString(dst, l.Value)
+		}
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(r.ts))
+		dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(r.value))
+	}
+	return dst
+}
+
+// encodeJSONEachRow writes one JSON object per row. Timestamps are unix
+// seconds with millisecond fraction, which DateTime64(3) parses without
+// depending on the server timezone.
+func encodeJSONEachRow(dst []byte, rows []row, columns [4]string) ([]byte, error) {
+	for _, r := range rows {
+		labels := make(map[string]string, len(r.labels))
+		for _, l := range r.labels {
+			labels[l.Name] = l.Value
+		}
+		doc := map[string]interface{}{
+			columns[0]: r.name,
+			columns[1]: labels,
+			columns[2]: strconv.FormatFloat(float64(r.ts)/1000, 'f', 3, 64),
+			columns[3]: jsonFloat(r.value),
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, data...)
+		dst = append(dst, '\n')
+	}
+	return dst, nil
+}
+
+// jsonFloat keeps NaN and Inf, which encoding/json refuses, as the strings
+// ClickHouse accepts for Float64 columns.
+func jsonFloat(v float64) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	default:
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
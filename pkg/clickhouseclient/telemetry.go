@@ -0,0 +1,39 @@
+package clickhouseclient
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_clickhouse"
+
+var (
+	clickhouseTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of handle timeseries total",
+		}, []string{"route_name"})
+	clickhouseRowsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "rows_written_total",
+			Help:      "Count of all rows inserted into ClickHouse",
+		}, []string{"route_name"})
+	clickhouseRowsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "rows_failed_total",
+			Help:      "Count of all row insert failures to ClickHouse",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(clickhouseTimeseries)
+	defaultTelemetry.Register(clickhouseRowsWritten)
+	defaultTelemetry.Register(clickhouseRowsFailed)
+}
@@ -91,10 +91,12 @@ func sortLabelsHashMod(m int, labels []prompb.Label) int {
 	if len(labels) == 0 {
 		return 0
 	}
-	return hashMod(m, sortLabelsHashKey(labels))
+	return hashMod(m, SortLabelsHashKey(labels))
 }
 
-func sortLabelsHashKey(labels []prompb.Label) uint32 {
+// SortLabelsHashKey hashes the label names and values of a series
+// independently of their order.
+func SortLabelsHashKey(labels []prompb.Label) uint32 {
 	newLabel := make([]string, 0, len(labels)*2)
 	for _, lal := range labels {
 		newLabel = append(newLabel, lal.Name)
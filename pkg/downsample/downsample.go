@@ -0,0 +1,325 @@
+package downsample
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultShards = 16
+	// maxFlushInterval bounds how long an interval of a series that stopped
+	// receiving samples waits before being emitted.
+	maxFlushInterval = time.Minute
+)
+
+type function int
+
+const (
+	last function = iota
+	minimum
+	maximum
+	avg
+	sum
+)
+
+func parseFunction(s string) (function, error) {
+	switch s {
+	case "", "last":
+		return last, nil
+	case "min":
+		return minimum, nil
+	case "max":
+		return maximum, nil
+	case "avg":
+		return avg, nil
+	case "sum":
+		return sum, nil
+	}
+	return 0, fmt.Errorf("unknown downsample function %q", s)
+}
+
+// Downsampler keeps one sample per series per interval for a route.
+//
+// Intervals are aligned on sample timestamps. An interval is emitted as
+// soon as a sample of a later interval arrives for the series, or by the
+// periodic flush once the series received nothing for a whole interval.
+// Samples of an interval already emitted are dropped as late. Series of
+// different tenants never share an interval.
+type Downsampler struct {
+	route    string
+	interval int64
+	fn       function
+	idle     time.Duration
+	send     func(tenant string, tss []prompb.TimeSeries)
+	shards   []*shard
+	stop     chan struct{}
+}
+
+type shard struct {
+	lock   sync.Mutex
+	series map[string]*seriesState
+}
+
+type seriesState struct {
+	tenant   string
+	labels   []prompb.Label
+	bucket   int64
+	flushed  bool
+	lastSeen time.Time
+
+	// samples counts every sample of the open interval, count only those
+	// that aren't stale markers and so feed min, max, avg and sum.
+	samples int
+	count   int
+	last    float64
+	lastTs  int64
+	min     float64
+	max     float64
+	sum     float64
+}
+
+// New starts the downsampler of a route. It returns nil, downsampling
+// nothing, when cfg is nil. Intervals of idle series are handed to send
+// by the periodic flush, once per tenant.
+func New(route string, cfg *setting.DownsampleConf, send func(tenant string, tss []prompb.TimeSeries)) (*Downsampler, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	interval := time.Duration(cfg.Interval)
+	if interval < time.Millisecond {
+		return nil, fmt.Errorf("downsample interval must be at least 1ms")
+	}
+	fn, err := parseFunction(cfg.Function)
+	if err != nil {
+		return nil, err
+	}
+	idle := time.Duration(cfg.IdleTimeout)
+	if idle == 0 {
+		idle = 2 * interval
+	}
+	if idle < interval {
+		return nil, fmt.Errorf("downsample idle_timeout must not be shorter than the interval")
+	}
+	shards := cfg.Shards
+	if shards <= 0 {
+		shards = defaultShards
+	}
+	d := &Downsampler{
+		route:    route,
+		interval: interval.Milliseconds(),
+		fn:       fn,
+		idle:     idle,
+		send:     send,
+		shards:   make([]*shard, shards),
+		stop:     make(chan struct{}),
+	}
+	for i := range d.shards {
+		d.shards[i] = &shard{series: make(map[string]*seriesState)}
+	}
+	go d.run(interval)
+	return d, nil
+}
+
+// Push absorbs the samples of tss, series of tenant, and returns the
+// series of the intervals they completed.
+func (d *Downsampler) Push(tenant string, tss []prompb.TimeSeries) []prompb.TimeSeries {
+	if d == nil {
+		return tss
+	}
+	now := time.Now()
+	var out []prompb.TimeSeries
+	var input, late int
+	for _, ts := range tss {
+		lbs := ts.Labels
+		if !sort.SliceIsSorted(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name }) {
+			lbs = append([]prompb.Label(nil), lbs...)
+			sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+		}
+		key := tenant + "\xfc" + labelsKey(lbs)
+		sh := d.shard(key)
+		input += len(ts.Samples)
+
+		var samples []prompb.Sample
+		sh.lock.Lock()
+		st, ok := sh.series[key]
+		if !ok {
+			st = &seriesState{tenant: tenant, labels: append([]prompb.Label(nil), lbs...), bucket: math.MinInt64}
+			sh.series[key] = st
+		}
+		st.lastSeen = now
+		for _, s := range ts.Samples {
+			b := s.Timestamp - mod(s.Timestamp, d.interval)
+			if b < st.bucket || (b == st.bucket && st.flushed) {
+				late++
+				continue
+			}
+			if b > st.bucket {
+				if st.samples > 0 {
+					samples = append(samples, d.result(st))
+				}
+				st.reset(b)
+			}
+			st.add(s)
+		}
+		sh.lock.Unlock()
+		if len(samples) > 0 {
+			out = append(out, prompb.TimeSeries{Labels: st.labels, Samples: samples})
+		}
+	}
+	downsampleInputSamples.WithLabelValues(d.route).Add(float64(input))
+	if late > 0 {
+		downsampleLateSamples.WithLabelValues(d.route).Add(float64(late))
+	}
+	if len(out) > 0 {
+		downsampleOutputSamples.WithLabelValues(d.route).Add(float64(countSamples(out)))
+	}
+	return out
+}
+
+// Stop stops the periodic flush.
+func (d *Downsampler) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+}
+
+func (d *Downsampler) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return d.shards[h.Sum32()%uint32(len(d.shards))]
+}
+
+func (d *Downsampler) run(interval time.Duration) {
+	if interval > maxFlushInterval {
+		interval = maxFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			for tenant, tss := range d.flush(now) {
+				downsampleOutputSamples.WithLabelValues(d.route).Add(float64(len(tss)))
+				d.send(tenant, tss)
+			}
+		}
+	}
+}
+
+// flush emits the open interval of every series idle for a whole interval,
+// grouped by tenant, and evicts the series idle for longer than the idle
+// timeout.
+func (d *Downsampler) flush(now time.Time) map[string][]prompb.TimeSeries {
+	tss := make(map[string][]prompb.TimeSeries)
+	var series, evicted int
+	interval := time.Duration(d.interval) * time.Millisecond
+	for _, sh := range d.shards {
+		sh.lock.Lock()
+		for key, st := range sh.series {
+			idle := now.Sub(st.lastSeen)
+			if st.samples > 0 && idle >= interval {
+				tss[st.tenant] = append(tss[st.tenant], prompb.TimeSeries{Labels: st.labels, Samples: []prompb.Sample{d.result(st)}})
+				st.samples = 0
+				st.flushed = true
+			}
+			if st.samples == 0 && idle > d.idle {
+				delete(sh.series, key)
+				evicted++
+				continue
+			}
+			series++
+		}
+		sh.lock.Unlock()
+	}
+	downsampleSeries.WithLabelValues(d.route).Set(float64(series))
+	if evicted > 0 {
+		downsampleEvictedSeries.WithLabelValues(d.route).Add(float64(evicted))
+	}
+	return tss
+}
+
+// result computes the sample of the open interval, stamped with the
+// timestamp of its last sample. An interval holding only stale markers
+// yields a stale marker whatever the function.
+func (d *Downsampler) result(st *seriesState) prompb.Sample {
+	s := prompb.Sample{Value: st.last, Timestamp: st.lastTs}
+	if st.count == 0 {
+		return s
+	}
+	switch d.fn {
+	case minimum:
+		s.Value = st.min
+	case maximum:
+		s.Value = st.max
+	case avg:
+		s.Value = st.sum / float64(st.count)
+	case sum:
+		s.Value = st.sum
+	}
+	return s
+}
+
+func (st *seriesState) reset(bucket int64) {
+	st.bucket = bucket
+	st.flushed = false
+	st.samples, st.count = 0, 0
+	st.sum = 0
+}
+
+func (st *seriesState) add(s prompb.Sample) {
+	st.samples++
+	if s.Timestamp >= st.lastTs || st.samples == 1 {
+		st.last, st.lastTs = s.Value, s.Timestamp
+	}
+	if value.IsStaleNaN(s.Value) {
+		return
+	}
+	st.count++
+	if st.count == 1 {
+		st.min, st.max = s.Value, s.Value
+	}
+	st.min = math.Min(st.min, s.Value)
+	st.max = math.Max(st.max, s.Value)
+	st.sum += s.Value
+}
+
+// mod is the non-negative remainder of a by b, aligning negative
+// timestamps on the same grid as positive ones.
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func countSamples(tss []prompb.TimeSeries) int {
+	n := 0
+	for _, ts := range tss {
+		n += len(ts.Samples)
+	}
+	return n
+}
+
+func labelsKey(lbs []prompb.Label) string {
+	var b strings.Builder
+	for _, l := range lbs {
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xfe)
+	}
+	return b.String()
+}
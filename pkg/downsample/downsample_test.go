@@ -0,0 +1,148 @@
+package downsample_test
+
+import (
+	"math"
+	"stream-metrics-route/pkg/downsample"
+	"stream-metrics-route/pkg/setting"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func series(name string, samples ...prompb.Sample) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "job", Value: "node"}},
+		Samples: samples,
+	}
+}
+
+func TestDownsampleFunctions(t *testing.T) {
+	stale := math.Float64frombits(value.StaleNaN)
+	input := []prompb.Sample{
+		{Value: 4, Timestamp: 1000},
+		{Value: 1, Timestamp: 1300},
+		{Value: 7, Timestamp: 1600},
+		{Value: stale, Timestamp: 1900},
+		// Opens the next interval, completing [1000, 2000).
+		{Value: 5, Timestamp: 2100},
+	}
+	for fn, want := range map[string]float64{"": stale, "last": stale, "min": 1, "max": 7, "avg": 4, "sum": 12} {
+		d, err := downsample.New("test", &setting.DownsampleConf{Interval: model.Duration(time.Second), Function: fn}, func(string, []prompb.TimeSeries) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := d.Push("", []prompb.TimeSeries{series("up", input...)})
+		d.Stop()
+		if len(out) != 1 || len(out[0].Samples) != 1 {
+			t.Fatalf("%s: expected one completed interval, got %v", fn, out)
+		}
+		got := out[0].Samples[0]
+		if got.Timestamp != 1900 {
+			t.Fatalf("%s: expected the timestamp of the last sample, got %d", fn, got.Timestamp)
+		}
+		if math.Float64bits(got.Value) != math.Float64bits(want) && got.Value != want {
+			t.Fatalf("%s: expected %v, got %v", fn, want, got.Value)
+		}
+	}
+}
+
+func TestDownsampleFlush(t *testing.T) {
+	out := make(chan []prompb.TimeSeries, 16)
+	d, err := downsample.New("test", &setting.DownsampleConf{
+		Interval: model.Duration(100 * time.Millisecond),
+		Function: "max",
+		Shards:   4,
+	}, func(_ string, tss []prompb.TimeSeries) { out <- tss })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	if got := d.Push("", []prompb.TimeSeries{
+		series("a", prompb.Sample{Value: 1, Timestamp: 0}, prompb.Sample{Value: 3, Timestamp: 50}),
+		series("b", prompb.Sample{Value: 2, Timestamp: 10}),
+	}); len(got) != 0 {
+		t.Fatalf("expected no completed interval yet, got %v", got)
+	}
+	// Idle series have their open interval emitted by the periodic flush.
+	got := make(map[string]prompb.Sample)
+	deadline := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case tss := <-out:
+			for _, ts := range tss {
+				got[ts.Labels[0].Value] = ts.Samples[0]
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for a flush, got %v", got)
+		}
+	}
+	if got["a"].Value != 3 || got["a"].Timestamp != 50 || got["b"].Value != 2 {
+		t.Fatalf("unexpected flushed samples %v", got)
+	}
+	// The interval was emitted, so further samples in it are late.
+	if late := d.Push("", []prompb.TimeSeries{series("a", prompb.Sample{Value: 9, Timestamp: 60})}); len(late) != 0 {
+		t.Fatalf("expected late samples to be dropped, got %v", late)
+	}
+
+	var nilDownsampler *downsample.Downsampler
+	in := []prompb.TimeSeries{series("a", prompb.Sample{Value: 1, Timestamp: 0})}
+	if got := nilDownsampler.Push("", in); len(got) != 1 {
+		t.Fatal("expected a nil downsampler to forward everything")
+	}
+}
+
+func TestDownsampleConfig(t *testing.T) {
+	if d, err := downsample.New("test", nil, nil); d != nil || err != nil {
+		t.Fatal("expected no downsampler without config")
+	}
+	for _, cfg := range []setting.DownsampleConf{
+		{},
+		{Interval: model.Duration(time.Minute), Function: "median"},
+		{Interval: model.Duration(time.Minute), IdleTimeout: model.Duration(time.Second)},
+	} {
+		if _, err := downsample.New("test", &cfg, nil); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestDownsampleTenants(t *testing.T) {
+	type flushed struct {
+		tenant string
+		tss    []prompb.TimeSeries
+	}
+	out := make(chan flushed, 16)
+	d, err := downsample.New("test", &setting.DownsampleConf{Interval: model.Duration(100 * time.Millisecond), Function: "sum"}, func(tenant string, tss []prompb.TimeSeries) {
+		out <- flushed{tenant, tss}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	// The same series of two tenants keeps an interval per tenant.
+	d.Push("team-a", []prompb.TimeSeries{series("up", prompb.Sample{Value: 1, Timestamp: 0})})
+	if got := d.Push("team-b", []prompb.TimeSeries{series("up", prompb.Sample{Value: 2, Timestamp: 150})}); len(got) != 0 {
+		t.Fatalf("expected the interval of team-a to stay open, got %v", got)
+	}
+	got := make(map[string]float64)
+	deadline := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case f := <-out:
+			if len(f.tss) != 1 {
+				t.Fatalf("expected one series per tenant, got %v", f.tss)
+			}
+			got[f.tenant] = f.tss[0].Samples[0].Value
+		case <-deadline:
+			t.Fatalf("timed out waiting for a flush, got %v", got)
+		}
+	}
+	if got["team-a"] != 1 || got["team-b"] != 2 {
+		t.Fatalf("unexpected flushed samples per tenant %v", got)
+	}
+}
@@ -0,0 +1,53 @@
+package downsample
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_downsample"
+
+var (
+	downsampleInputSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "input_samples_total",
+			Help:      "Count of samples fed to a route downsampler",
+		}, []string{"route_name"})
+	downsampleOutputSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "output_samples_total",
+			Help:      "Count of downsampled samples emitted to the route upstream",
+		}, []string{"route_name"})
+	downsampleLateSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "late_samples_total",
+			Help:      "Count of samples dropped because their interval was already emitted",
+		}, []string{"route_name"})
+	downsampleEvictedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "evicted_series_total",
+			Help:      "Count of idle series whose state was evicted",
+		}, []string{"route_name"})
+	downsampleSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "series",
+			Help:      "Number of series a route downsampler currently tracks",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(downsampleInputSamples)
+	defaultTelemetry.Register(downsampleOutputSamples)
+	defaultTelemetry.Register(downsampleLateSamples)
+	defaultTelemetry.Register(downsampleEvictedSeries)
+	defaultTelemetry.Register(downsampleSeries)
+}
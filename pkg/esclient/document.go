@@ -0,0 +1,121 @@
+package esclient
+
+import (
+	"encoding/json"
+	"math"
+	"stream-metrics-route/pkg/serialize"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// dateMarker delimits the layout recorded by the `date` template function.
+// The index template is rendered once per series and the markers are
+// expanded per sample, since samples of one series can straddle midnight.
+const dateMarker = "\x00"
+
+// doc is one bulk action: the target index and the encoded source.
+type doc struct {
+	index  string
+	source []byte
+}
+
+// parseIndexTemplate parses an index name template. Besides the topic
+// template helpers it offers `date "2006.01.02"`, formatting the sample
+// timestamp in UTC with a Go time layout.
+func parseIndexTemplate(tpl string) (*template.Template, error) {
+	return template.New("index").Funcs(serialize.TemplateFuncMap()).Funcs(template.FuncMap{
+		"date": func(layout string) string {
+			return dateMarker + layout + dateMarker
+		},
+	}).Parse(tpl)
+}
+
+// expandDates replaces every date marker in index with the timestamp
+// formatted by the recorded layout.
+func expandDates(index string, ts int64) string {
+	if !strings.Contains(index, dateMarker) {
+		return index
+	}
+	t := time.UnixMilli(ts).UTC()
+	parts := strings.Split(index, dateMarker)
+	for i := 1; i < len(parts); i += 2 {
+		parts[i] = t.Format(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+// documentBuilder turns series into bulk documents. With the flattened
+// mapping labels become top-level fields next to name, value and
+// @timestamp, labels clashing with those get a `label_` prefix; with the
+// nested mapping they are kept under `labels`.
+type documentBuilder struct {
+	index  *template.Template
+	nested bool
+}
+
+// appendDocs appends one document per sample and returns the number of
+// samples dropped because JSON can't carry NaN or Inf values.
+func (b *documentBuilder) appendDocs(dst []doc, ts prompb.TimeSeries) ([]doc, int, error) {
+	labels := make(map[string]string, len(ts.Labels))
+	for _, l := range ts.Labels {
+		labels[l.Name] = l.Value
+	}
+	name := labels["__name__"]
+	delete(labels, "__name__")
+	index := serialize.Topic(*b.index, labels)
+	var flattened map[string]string
+	if !b.nested {
+		flattened = make(map[string]string, len(labels))
+		for k, v := range labels {
+			flattened[flattenedField(k, labels)] = v
+		}
+	}
+
+	dropped := 0
+	for _, s := range ts.Samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			dropped++
+			continue
+		}
+		fields := make(map[string]interface{}, len(labels)+3)
+		if b.nested {
+			fields["labels"] = labels
+		} else {
+			for k, v := range flattened {
+				fields[k] = v
+			}
+		}
+		fields["@timestamp"] = time.UnixMilli(s.Timestamp).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+		fields["name"] = name
+		fields["value"] = s.Value
+		source, err := json.Marshal(fields)
+		if err != nil {
+			return dst, dropped, err
+		}
+		dst = append(dst, doc{index: expandDates(index, s.Timestamp), source: source})
+	}
+	return dst, dropped, nil
+}
+
+// flattenedField returns the field of a label in the flattened mapping,
+// prefixed until it clashes neither with the sample fields nor with another
+// label.
+func flattenedField(label string, labels map[string]string) string {
+	field := label
+	for {
+		switch field {
+		case "name", "value", "@timestamp":
+		default:
+			if field == label {
+				return field
+			}
+			if _, ok := labels[field]; !ok {
+				return field
+			}
+		}
+		field = "label_" + field
+	}
+}
@@ -0,0 +1,282 @@
+package esclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"stream-metrics-route/pkg/batcher"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultBulkSize      = 5000
+	defaultFlushInterval = 5 * time.Second
+	defaultConcurrency   = 2
+	defaultTimeout       = 30 * time.Second
+	defaultMaxRetries    = 3
+)
+
+const maxErrMsgLen = 1024
+
+// ElasticsearchClient indexes routed samples as documents through the
+// `_bulk` API of Elasticsearch or OpenSearch.
+type ElasticsearchClient struct {
+	name       string
+	bulkURLs   []string
+	next       uint32
+	username   string
+	password   string
+	apiKey     string
+	action     string
+	gzip       bool
+	timeout    time.Duration
+	maxRetries int
+	builder    *documentBuilder
+	client     *http.Client
+	batcher    *batcher.Batcher[doc]
+}
+
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+func NewElasticsearch(name string, cfg setting.ElasticsearchConfig) (*ElasticsearchClient, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("elasticsearch urls are required")
+	}
+	if cfg.Index == "" {
+		return nil, fmt.Errorf("elasticsearch index is required")
+	}
+	var bulkURLs []string
+	for _, u := range cfg.URLs {
+		base, err := url.Parse(u)
+		if err != nil || u == "" {
+			return nil, fmt.Errorf("couldn't parse the elasticsearch url %q", u)
+		}
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/_bulk"
+		bulkURLs = append(bulkURLs, base.String())
+	}
+	index, err := parseIndexTemplate(cfg.Index)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the elasticsearch index template: %v", err)
+	}
+	builder := &documentBuilder{index: index}
+	switch cfg.Mapping {
+	case "", "flattened":
+	case "nested":
+		builder.nested = true
+	default:
+		return nil, fmt.Errorf("unknown elasticsearch mapping %q", cfg.Mapping)
+	}
+
+	httpConfig := config.DefaultHTTPClientConfig
+	httpConfig.TLSConfig = cfg.TLSConfig
+	httpClient, err := config.NewClientFromConfig(httpConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ElasticsearchClient{
+		name:       name,
+		bulkURLs:   bulkURLs,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		apiKey:     cfg.APIKey,
+		action:     "index",
+		gzip:       cfg.Gzip,
+		timeout:    time.Duration(cfg.Timeout),
+		maxRetries: defaultMaxRetries,
+		builder:    builder,
+		client:     httpClient,
+	}
+	// Data streams are append-only and reject anything but create.
+	if cfg.DataStream {
+		e.action = "create"
+	}
+	if e.timeout <= 0 {
+		e.timeout = defaultTimeout
+	}
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("elasticsearch max_retries must not be negative")
+		}
+		e.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.BulkSize <= 0 {
+		cfg.BulkSize = defaultBulkSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	e.batcher = batcher.New(cfg.BulkSize, flushInterval, cfg.Concurrency, e.bulk)
+	defaultTelemetry.Logger.Debug("create elasticsearch client", "name", name, "urls", cfg.URLs, "index", cfg.Index, "data_stream", cfg.DataStream)
+	return e, nil
+}
+
+func (e *ElasticsearchClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	esTimeseries.WithLabelValues(e.name).Add(float64(len(req)))
+	var docs []doc
+	dropped := 0
+	for _, ts := range req {
+		var n int
+		var err error
+		docs, n, err = e.builder.appendDocs(docs, ts)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("couldn't encode elasticsearch document %v", err)
+		}
+		dropped += n
+	}
+	if dropped > 0 {
+		esDroppedSamples.WithLabelValues(e.name).Add(float64(dropped))
+	}
+	e.batcher.Add(docs...)
+	return 0, nil
+}
+
+// Close indexes the buffered documents and waits for the bulk requests in
+// flight.
+func (e *ElasticsearchClient) Close() error {
+	e.batcher.Close()
+	return nil
+}
+
+// bulk indexes docs, resending only the items rejected with a retryable
+// status, and accounts every item that finally failed by its error type.
+func (e *ElasticsearchClient) bulk(docs []doc) {
+	pending := docs
+	reason := "request_failed"
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout*time.Duration(e.maxRetries+1))
+	defer cancel()
+	err := batcher.Retry(ctx, e.maxRetries, 500*time.Millisecond, 5*time.Second, func() (bool, error) {
+		resp, retryable, err := e.post(ctx, pending)
+		if err != nil {
+			reason = "request_failed"
+			return retryable, err
+		}
+		if len(resp.Items) != len(pending) {
+			reason = "invalid_response"
+			return false, fmt.Errorf("bulk response has %d items for %d documents", len(resp.Items), len(pending))
+		}
+		var retry []doc
+		indexed := 0
+		for i, item := range resp.Items {
+			var result bulkItem
+			for _, r := range item {
+				result = r
+			}
+			switch {
+			case result.Status/100 == 2:
+				indexed++
+			case result.Status == http.StatusTooManyRequests || result.Status/100 == 5:
+				retry = append(retry, pending[i])
+			default:
+				errType := "unknown"
+				if result.Error != nil {
+					errType = result.Error.Type
+					defaultTelemetry.Logger.Debug("elasticsearch document rejected", "name", e.name, "index", pending[i].index, "type", errType, "reason", result.Error.Reason)
+				}
+				esDocumentsFailed.WithLabelValues(e.name, errType).Inc()
+			}
+		}
+		esDocumentsIndexed.WithLabelValues(e.name).Add(float64(indexed))
+		pending = retry
+		if len(pending) > 0 {
+			reason = "retries_exhausted"
+			return true, fmt.Errorf("%d documents rejected with a retryable status", len(pending))
+		}
+		return false, nil
+	})
+	if err != nil && len(pending) > 0 {
+		esDocumentsFailed.WithLabelValues(e.name, reason).Add(float64(len(pending)))
+		defaultTelemetry.Logger.Error("elasticsearch bulk error", "name", e.name, "documents", len(pending), "err", err)
+	}
+}
+
+// post sends one bulk request to the next url and reports whether a
+// failure is worth retrying.
+func (e *ElasticsearchClient) post(ctx context.Context, docs []doc) (*bulkResponse, bool, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if e.gzip {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	for _, d := range docs {
+		action, _ := json.Marshal(map[string]map[string]string{e.action: {"_index": d.index}})
+		w.Write(action)
+		w.Write([]byte("\n"))
+		w.Write(d.source)
+		w.Write([]byte("\n"))
+	}
+	if gz != nil {
+		gz.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	bulkURL := e.bulkURLs[int(atomic.AddUint32(&e.next, 1)-1)%len(e.bulkURLs)]
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bulkURL, &buf)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "stream-metrics-route")
+	if e.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+		return nil, resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, true, fmt.Errorf("couldn't decode bulk response %v", err)
+	}
+	return &result, false, nil
+}
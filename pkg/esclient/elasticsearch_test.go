@@ -0,0 +1,229 @@
+package esclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"stream-metrics-route/pkg/esclient"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/sinktest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type bulkLine struct {
+	action string
+	index  string
+	source map[string]interface{}
+}
+
+func parseBulk(r sinktest.Request) []bulkLine {
+	var lines []bulkLine
+	scanner := bufio.NewScanner(bytes.NewReader(r.Body))
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		var line bulkLine
+		json.Unmarshal(scanner.Bytes(), &line.source)
+		for k, v := range action {
+			line.action, line.index = k, v["_index"]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// bulk plays the `_bulk` API. Documents whose value is listed in reject are
+// answered with that status, once for 429 and every time for anything else.
+func bulk(reject map[float64]int) sinktest.Handler {
+	return func(r sinktest.Request) (int, string) {
+		if r.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			return http.StatusBadRequest, "unexpected request"
+		}
+		var items []string
+		for _, l := range parseBulk(r) {
+			value := l.source["value"].(float64)
+			status := 201
+			if code, ok := reject[value]; ok {
+				status = code
+				if code == http.StatusTooManyRequests {
+					delete(reject, value)
+				}
+			}
+			if status == 201 {
+				items = append(items, fmt.Sprintf(`{%q:{"status":201}}`, l.action))
+			} else {
+				items = append(items, fmt.Sprintf(`{%q:{"status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed"}}}`, l.action, status))
+			}
+		}
+		return http.StatusOK, fmt.Sprintf(`{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}
+}
+
+// unavailable answers the first n requests with 503, then passes them to h.
+func unavailable(n int, h sinktest.Handler) sinktest.Handler {
+	return func(r sinktest.Request) (int, string) {
+		if n--; n >= 0 {
+			return http.StatusServiceUnavailable, "unavailable"
+		}
+		return h(r)
+	}
+}
+
+func testSeries() []prompb.TimeSeries {
+	// 2026-10-18T23:59:59.500Z and 2026-10-19T00:00:00.500Z
+	return []prompb.TimeSeries{sinktest.Series("up", []string{"instance", "a:9100", "job", "node"},
+		prompb.Sample{Value: 1, Timestamp: 1792367999500},
+		prompb.Sample{Value: 2, Timestamp: 1792368000500},
+		prompb.Sample{Value: math.NaN(), Timestamp: 1792368001000},
+		prompb.Sample{Value: 3, Timestamp: 1792368001500},
+	)}
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	s := sinktest.NewServer(t, bulk(map[float64]int{2: http.StatusTooManyRequests, 3: http.StatusBadRequest}))
+	e, err := esclient.NewElasticsearch("test", setting.ElasticsearchConfig{
+		URLs:          []string{s.URL},
+		Index:         `metrics-{{.job}}-{{date "2006.01.02"}}`,
+		BulkSize:      3,
+		FlushInterval: model.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.WaitRequests(t, 2)
+
+	first := parseBulk(requests[0])
+	if len(first) != 3 {
+		t.Fatalf("expected NaN to be dropped, got %d documents", len(first))
+	}
+	if first[0].action != "index" || first[0].index != "metrics-node-2026.10.18" || first[1].index != "metrics-node-2026.10.19" {
+		t.Fatalf("unexpected actions %+v", first)
+	}
+	src := first[0].source
+	if src["name"] != "up" || src["job"] != "node" || src["instance"] != "a:9100" || src["@timestamp"] != "2026-10-18T23:59:59.500Z" {
+		t.Fatalf("unexpected flattened document %v", src)
+	}
+	// Only the document rejected with 429 is resent.
+	if second := parseBulk(requests[1]); len(second) != 1 || second[0].source["value"] != 2.0 {
+		t.Fatalf("unexpected retry %+v", second)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(s.Requests()); n != 2 {
+		t.Fatalf("expected no further retries, got %d requests", n)
+	}
+}
+
+func TestElasticsearchLabelClash(t *testing.T) {
+	s := sinktest.NewServer(t, bulk(nil))
+	e, err := esclient.NewElasticsearch("test", setting.ElasticsearchConfig{URLs: []string{s.URL}, Index: "metrics", BulkSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := sinktest.Series("up", []string{"name", "a", "value", "b", "@timestamp", "c", "label_name", "d"},
+		prompb.Sample{Value: 1, Timestamp: 1792367999500})
+	if _, err := e.Store(context.Background(), []prompb.TimeSeries{series}); err != nil {
+		t.Fatal(err)
+	}
+	src := parseBulk(s.WaitRequests(t, 1)[0])[0].source
+	want := map[string]interface{}{
+		"name":             "up",
+		"value":            1.0,
+		"@timestamp":       "2026-10-18T23:59:59.500Z",
+		"label_label_name": "a",
+		"label_value":      "b",
+		"label_@timestamp": "c",
+		"label_name":       "d",
+	}
+	if len(src) != len(want) {
+		t.Fatalf("unexpected document %v", src)
+	}
+	for k, v := range want {
+		if src[k] != v {
+			t.Fatalf("field %s = %v, want %v in %v", k, src[k], v, src)
+		}
+	}
+}
+
+func TestElasticsearchDataStreamNested(t *testing.T) {
+	s := sinktest.NewServer(t, bulk(nil))
+	e, err := esclient.NewElasticsearch("test", setting.ElasticsearchConfig{
+		URLs:          []string{s.URL + "/"},
+		Index:         "metrics-{{.job}}-default",
+		DataStream:    true,
+		Mapping:       "nested",
+		BulkSize:      1,
+		FlushInterval: model.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	line := parseBulk(s.WaitRequests(t, 1)[0])[0]
+	if line.action != "create" || line.index != "metrics-node-default" {
+		t.Fatalf("unexpected action %+v", line)
+	}
+	labels, ok := line.source["labels"].(map[string]interface{})
+	if !ok || labels["job"] != "node" || line.source["job"] != nil {
+		t.Fatalf("unexpected nested document %v", line.source)
+	}
+}
+
+func TestElasticsearchRetries(t *testing.T) {
+	zero := 0
+	for _, tc := range []struct {
+		name       string
+		handler    sinktest.Handler
+		maxRetries *int
+		requests   int
+	}{
+		{"retried rejection", bulk(map[float64]int{1: http.StatusTooManyRequests}), nil, 2},
+		{"retried server error", unavailable(1, bulk(nil)), nil, 2},
+		{"retries disabled", bulk(map[float64]int{1: http.StatusTooManyRequests}), &zero, 1},
+		{"client error", sinktest.Statuses(http.StatusUnauthorized), nil, 1},
+	} {
+		s := sinktest.NewServer(t, tc.handler)
+		e, err := esclient.NewElasticsearch("test", setting.ElasticsearchConfig{URLs: []string{s.URL}, Index: "metrics", MaxRetries: tc.maxRetries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Store(context.Background(), testSeries()[:1]); err != nil {
+			t.Fatal(err)
+		}
+		// Close sends the buffered documents before returning.
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.Requests()); n != tc.requests {
+			t.Fatalf("%s: expected %d bulk requests, got %d", tc.name, tc.requests, n)
+		}
+	}
+}
+
+func TestElasticsearchConfigErrors(t *testing.T) {
+	negative := -1
+	sinktest.ConfigErrors(t, func(cfg setting.ElasticsearchConfig) error {
+		_, err := esclient.NewElasticsearch("test", cfg)
+		return err
+	},
+		setting.ElasticsearchConfig{Index: "metrics"},
+		setting.ElasticsearchConfig{URLs: []string{"http://localhost:9200"}},
+		setting.ElasticsearchConfig{URLs: []string{"http://localhost:9200"}, Index: "metrics-{{.job"},
+		setting.ElasticsearchConfig{URLs: []string{"http://localhost:9200"}, Index: "metrics", Mapping: "object"},
+		setting.ElasticsearchConfig{URLs: []string{"http://localhost:9200"}, Index: "metrics", MaxRetries: &negative},
+	)
+}
@@ -0,0 +1,46 @@
+package esclient
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_elasticsearch"
+
+var (
+	esTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of handle timeseries total",
+		}, []string{"route_name"})
+	esDroppedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dropped_samples_total",
+			Help:      "Count of NaN or Inf samples that can't be indexed as JSON",
+		}, []string{"route_name"})
+	esDocumentsIndexed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "documents_indexed_total",
+			Help:      "Count of all documents accepted by the bulk API",
+		}, []string{"route_name"})
+	esDocumentsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "documents_failed_total",
+			Help:      "Count of all documents that failed to index, by error type",
+		}, []string{"route_name", "reason"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(esTimeseries)
+	defaultTelemetry.Register(esDroppedSamples)
+	defaultTelemetry.Register(esDocumentsIndexed)
+	defaultTelemetry.Register(esDocumentsFailed)
+}
@@ -0,0 +1,318 @@
+package filestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-metrics-route/pkg/setting"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultMaxSize int64 = 100 * 1024 * 1024
+)
+
+// FileStore appends routed series to a local file and rotates it by size or
+// age. Rotated files are optionally compressed and only the newest
+// `retention` of them are kept.
+type FileStore struct {
+	name           string
+	dir            string
+	encoder        Encoder
+	maxSize        int64
+	rotateInterval time.Duration
+	compression    string
+	retention      int
+	rotatedName    *regexp.Regexp
+
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	seq      int
+	closed   bool
+
+	stop      chan struct{}
+	finishing sync.WaitGroup
+}
+
+func NewFileStore(name string, cfg setting.FileConfig) (*FileStore, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+	encoder, err := parseFormat(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Compression {
+	case "", "none", "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("unknown file compression %q", cfg.Compression)
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxSize
+	}
+	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		name:           name,
+		dir:            cfg.Path,
+		encoder:        encoder,
+		maxSize:        cfg.MaxSize,
+		rotateInterval: time.Duration(cfg.RotateInterval),
+		compression:    cfg.Compression,
+		retention:      cfg.Retention,
+		rotatedName:    regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-\d{8}T\d{6}\.\d{3}-\d{3}\.` + regexp.QuoteMeta(encoder.Ext()) + `(\.gz|\.zst)?$`),
+		stop:           make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if f.rotateInterval > 0 {
+		go f.rotateLoop()
+	}
+	defaultTelemetry.Logger.Debug("create file store", "name", name, "file", f.activePath())
+	return f, nil
+}
+
+func (f *FileStore) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	var buf bytes.Buffer
+	if err := f.encoder.Encode(&buf, req); err != nil {
+		fileFalseTimeseries.WithLabelValues(f.name).Add(float64(len(req)))
+		return http.StatusInternalServerError, fmt.Errorf("couldn't encode timeseries %v", err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		fileFalseTimeseries.WithLabelValues(f.name).Add(float64(len(req)))
+		return http.StatusServiceUnavailable, fmt.Errorf("file store %s is closed", f.name)
+	}
+	if f.size > 0 && f.size+int64(buf.Len()) > f.maxSize {
+		f.rotate()
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			fileFalseTimeseries.WithLabelValues(f.name).Add(float64(len(req)))
+			return http.StatusInternalServerError, err
+		}
+	}
+	n, err := f.file.Write(buf.Bytes())
+	f.size += int64(n)
+	fileWrittenBytes.WithLabelValues(f.name).Add(float64(n))
+	if err != nil {
+		fileFalseTimeseries.WithLabelValues(f.name).Add(float64(len(req)))
+		defaultTelemetry.Logger.Error("write file error", "name", f.name, "err", err)
+		return http.StatusInternalServerError, err
+	}
+	fileTimeseries.WithLabelValues(f.name).Add(float64(len(req)))
+	return 0, nil
+}
+
+func (f *FileStore) activePath() string {
+	return filepath.Join(f.dir, f.name+"."+f.encoder.Ext())
+}
+
+// open must be called with the lock held or before the store is shared.
+func (f *FileStore) open() error {
+	file, err := os.OpenFile(f.activePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+func (f *FileStore) rotateLoop() {
+	ticker := time.NewTicker(f.rotateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		f.lock.Lock()
+		if !f.closed && f.size > 0 && time.Since(f.openedAt) >= f.rotateInterval {
+			f.rotate()
+		}
+		f.lock.Unlock()
+	}
+}
+
+// Close syncs and closes the active file, and waits for the compression
+// of rotated files. Later writes fail.
+func (f *FileStore) Close() error {
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.stop)
+	var err error
+	if f.file != nil {
+		err = f.file.Sync()
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+		f.file = nil
+	}
+	f.lock.Unlock()
+	f.finishing.Wait()
+	return err
+}
+
+// rotate must be called with the lock held. The compression and retention
+// work runs in the background so writers are not blocked by it.
+func (f *FileStore) rotate() {
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			defaultTelemetry.Logger.Error("close file error", "name", f.name, "err", err)
+		}
+		f.file = nil
+	}
+	rotated := f.rotatedPath(time.Now())
+	if err := os.Rename(f.activePath(), rotated); err != nil {
+		fileRotateFailed.WithLabelValues(f.name).Inc()
+		defaultTelemetry.Logger.Error("rotate file error", "name", f.name, "err", err)
+	} else {
+		fileRotations.WithLabelValues(f.name).Inc()
+		f.finishing.Add(1)
+		go f.finishRotate(rotated)
+	}
+	if err := f.open(); err != nil {
+		defaultTelemetry.Logger.Error("open file error", "name", f.name, "err", err)
+	}
+}
+
+// rotatedPath names a rotated file after the rotation time and a sequence
+// number, so that rotations within the same millisecond don't overwrite
+// each other and names still sort by age. Names taken by earlier files,
+// compressed or not, are skipped.
+func (f *FileStore) rotatedPath(now time.Time) string {
+	for {
+		f.seq = (f.seq + 1) % 1000
+		path := filepath.Join(f.dir, fmt.Sprintf("%s-%s-%03d.%s", f.name, now.UTC().Format("20060102T150405.000"), f.seq, f.encoder.Ext()))
+		if !exists(path) && !exists(path+".gz") && !exists(path+".zst") {
+			return path
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (f *FileStore) finishRotate(path string) {
+	defer f.finishing.Done()
+	if err := compressFile(path, f.compression); err != nil {
+		fileRotateFailed.WithLabelValues(f.name).Inc()
+		defaultTelemetry.Logger.Error("compress file error", "file", path, "err", err)
+	}
+	if err := f.prune(); err != nil {
+		fileRotateFailed.WithLabelValues(f.name).Inc()
+		defaultTelemetry.Logger.Error("prune files error", "name", f.name, "err", err)
+	}
+}
+
+// prune removes the oldest rotated files so at most `retention` remain.
+// Only names of the exact rotatedPath shape are considered, so files of
+// other routes sharing the directory and a name prefix are left alone.
+func (f *FileStore) prune() error {
+	if f.retention <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, e := range entries {
+		if !e.IsDir() && f.rotatedName.MatchString(e.Name()) {
+			rotated = append(rotated, filepath.Join(f.dir, e.Name()))
+		}
+	}
+	if len(rotated) <= f.retention {
+		return nil
+	}
+	sort.Strings(rotated)
+	for _, m := range rotated[:len(rotated)-f.retention] {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressFile(path, compression string) error {
+	var ext string
+	switch compression {
+	case "gzip":
+		ext = ".gz"
+	case "zstd":
+		ext = ".zst"
+	default:
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ext + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var w io.WriteCloser
+	if compression == "gzip" {
+		w = gzip.NewWriter(dst)
+	} else {
+		w, err = zstd.NewWriter(dst)
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if _, err = io.Copy(w, src); err == nil {
+		err = w.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+ext); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,118 @@
+package filestore_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"stream-metrics-route/pkg/filestore"
+	"stream-metrics-route/pkg/setting"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestFileStoreRotate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := filestore.NewFileStore("audit", setting.FileConfig{
+		Path:        dir,
+		Format:      "prometheus",
+		MaxSize:     64,
+		Compression: "gzip",
+		Retention:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node-exporter"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+	}}
+	for i := 0; i < 5; i++ {
+		if _, err := store.Store(context.Background(), ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rotated []string
+	for i := 0; i < 50; i++ {
+		rotated, _ = filepath.Glob(filepath.Join(dir, "audit-*.prom.gz"))
+		tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		plain, _ := filepath.Glob(filepath.Join(dir, "audit-*.prom"))
+		if len(rotated) == 2 && len(tmp) == 0 && len(plain) == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 retained files, got %v", rotated)
+	}
+	active, _ := filepath.Glob(filepath.Join(dir, "audit.prom"))
+	if len(active) != 1 {
+		t.Fatalf("expected the active file to exist, got %v", active)
+	}
+}
+
+func TestFileStoreClose(t *testing.T) {
+	dir := t.TempDir()
+	store, err := filestore.NewFileStore("audit", setting.FileConfig{Path: dir, Format: "prometheus", MaxSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node-exporter"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+	}}
+	// Rotations within the same millisecond keep every file.
+	for i := 0; i < 5; i++ {
+		if _, err := store.Store(context.Background(), ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.prom"))
+	if len(rotated) != 4 {
+		t.Fatalf("expected 4 rotated files, got %v", rotated)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "audit.prom"))
+	if err != nil || len(b) == 0 {
+		t.Fatalf("expected the active file to be written, got %q %v", b, err)
+	}
+	if _, err := store.Store(context.Background(), ts); err == nil {
+		t.Fatal("expected writes to fail once closed")
+	}
+}
+
+func TestFileStorePruneOwnFiles(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "audit-eu-20231018T030000.000-001.prom")
+	if err := os.WriteFile(other, []byte("up 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := filestore.NewFileStore("audit", setting.FileConfig{Path: dir, Format: "prometheus", MaxSize: 64, Retention: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node-exporter"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+	}}
+	for i := 0; i < 3; i++ {
+		if _, err := store.Store(context.Background(), ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("expected the file of route audit-eu to be kept: %v", err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-2*.prom"))
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 retained file, got %v", rotated)
+	}
+}
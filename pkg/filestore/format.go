@@ -0,0 +1,142 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Encoder turns a batch of timeseries into the bytes appended to the active file.
+type Encoder interface {
+	Encode(buf *bytes.Buffer, req []prompb.TimeSeries) error
+	Ext() string
+}
+
+func parseFormat(value string) (Encoder, error) {
+	switch value {
+	case "", "ndjson", "json":
+		return &NDJSONEncoder{}, nil
+	case "prometheus", "text":
+		return &TextEncoder{}, nil
+	case "prompb", "protobuf":
+		return &PrompbEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown file format %q", value)
+	}
+}
+
+// NDJSONEncoder writes one JSON document per sample, using the same shape as
+// the kafka JSON serializer.
+type NDJSONEncoder struct {
+}
+
+func (e *NDJSONEncoder) Ext() string { return "ndjson" }
+
+func (e *NDJSONEncoder) Encode(buf *bytes.Buffer, req []prompb.TimeSeries) error {
+	enc := json.NewEncoder(buf)
+	for _, ts := range req {
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			labels[l.Name] = l.Value
+		}
+		for _, sample := range ts.Samples {
+			m := map[string]interface{}{
+				"timestamp": time.UnixMilli(sample.Timestamp).UTC().Format(time.RFC3339Nano),
+				"value":     strconv.FormatFloat(sample.Value, 'f', -1, 64),
+				"name":      labels["__name__"],
+				"labels":    labels,
+			}
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TextEncoder writes samples in the Prometheus text exposition format with
+// explicit millisecond timestamps.
+type TextEncoder struct {
+}
+
+func (e *TextEncoder) Ext() string { return "prom" }
+
+func (e *TextEncoder) Encode(buf *bytes.Buffer, req []prompb.TimeSeries) error {
+	for _, ts := range req {
+		name := ""
+		lbs := make([]prompb.Label, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+				continue
+			}
+			lbs = append(lbs, l)
+		}
+		sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+
+		var series strings.Builder
+		series.WriteString(name)
+		if len(lbs) > 0 {
+			series.WriteByte('{')
+			for i, l := range lbs {
+				if i > 0 {
+					series.WriteByte(',')
+				}
+				series.WriteString(l.Name)
+				series.WriteString("=")
+				series.WriteString(strconv.Quote(l.Value))
+			}
+			series.WriteByte('}')
+		}
+		for _, sample := range ts.Samples {
+			buf.WriteString(series.String())
+			buf.WriteByte(' ')
+			buf.WriteString(formatValue(sample.Value))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+			buf.WriteByte('\n')
+		}
+	}
+	return nil
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// PrompbEncoder writes each batch as a prompb.WriteRequest prefixed with its
+// length as a big-endian uint32.
+type PrompbEncoder struct {
+}
+
+func (e *PrompbEncoder) Ext() string { return "pb" }
+
+func (e *PrompbEncoder) Encode(buf *bytes.Buffer, req []prompb.TimeSeries) error {
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: req})
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	buf.Write(size[:])
+	buf.Write(data)
+	return nil
+}
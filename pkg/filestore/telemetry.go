@@ -0,0 +1,53 @@
+package filestore
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_file"
+
+var (
+	fileTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of timeseries written to file",
+		}, []string{"route_name"})
+	fileFalseTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_false_total",
+			Help:      "Count of timeseries write failures to file",
+		}, []string{"route_name"})
+	fileWrittenBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "written_bytes_total",
+			Help:      "Count of bytes written to file",
+		}, []string{"route_name"})
+	fileRotations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "rotations_total",
+			Help:      "Count of file rotations",
+		}, []string{"route_name"})
+	fileRotateFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "rotations_failed_total",
+			Help:      "Count of file rotation, compression or retention failures",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(fileTimeseries)
+	defaultTelemetry.Register(fileFalseTimeseries)
+	defaultTelemetry.Register(fileWrittenBytes)
+	defaultTelemetry.Register(fileRotations)
+	defaultTelemetry.Register(fileRotateFailed)
+}
@@ -0,0 +1,127 @@
+package graphiteclient
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// lineBuilder renders samples in the carbon plaintext protocol, either as
+// dotted paths or with Graphite tags.
+type lineBuilder struct {
+	tagged bool
+	prefix string
+	// labels are appended to the path in order, or restrict the tags sent
+	// when tagged; an empty list sends every label as a tag.
+	labels []string
+}
+
+// appendLines appends one line per sample and returns the number of
+// samples dropped because carbon can't store NaN or Inf.
+func (b *lineBuilder) appendLines(dst [][]byte, ts prompb.TimeSeries) ([][]byte, int) {
+	name := ""
+	values := make(map[string]string, len(ts.Labels))
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		values[l.Name] = l.Value
+	}
+
+	var key []byte
+	if b.tagged {
+		key = b.appendTagged(nil, name, values)
+	} else {
+		key = b.appendPath(nil, name, values)
+	}
+
+	dropped := 0
+	for _, s := range ts.Samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			dropped++
+			continue
+		}
+		line := make([]byte, 0, len(key)+32)
+		line = append(line, key...)
+		line = append(line, ' ')
+		line = strconv.AppendFloat(line, s.Value, 'g', -1, 64)
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, s.Timestamp/1000, 10)
+		line = append(line, '\n')
+		dst = append(dst, line)
+	}
+	return dst, dropped
+}
+
+func (b *lineBuilder) appendPath(dst []byte, name string, values map[string]string) []byte {
+	if b.prefix != "" {
+		dst = append(dst, b.prefix...)
+		dst = append(dst, '.')
+	}
+	dst = appendPathNode(dst, name)
+	for _, l := range b.labels {
+		if v := values[l]; v != "" {
+			dst = append(dst, '.')
+			dst = appendPathNode(dst, v)
+		}
+	}
+	return dst
+}
+
+func (b *lineBuilder) appendTagged(dst []byte, name string, values map[string]string) []byte {
+	if b.prefix != "" {
+		dst = append(dst, b.prefix...)
+		dst = append(dst, '.')
+	}
+	dst = appendPathNode(dst, name)
+	keys := b.labels
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+	} else {
+		keys = append([]string(nil), keys...)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := values[k]
+		if v == "" {
+			continue
+		}
+		dst = append(dst, ';')
+		dst = appendSanitized(dst, k, ";!^= ~")
+		dst = append(dst, '=')
+		dst = appendSanitized(dst, v, "; ~")
+	}
+	return dst
+}
+
+// appendPathNode writes s as a single path node: dots would split it into
+// several nodes and whitespace ends the path, so both become underscores
+// along with anything outside the characters carbon handles cleanly.
+func appendPathNode(dst []byte, s string) []byte {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("_-:", r):
+			dst = append(dst, byte(r))
+		default:
+			dst = append(dst, '_')
+		}
+	}
+	return dst
+}
+
+func appendSanitized(dst []byte, s, reserved string) []byte {
+	for _, r := range s {
+		if r < ' ' || strings.ContainsRune(reserved, r) {
+			r = '_'
+		}
+		dst = append(dst, string(r)...)
+	}
+	return dst
+}
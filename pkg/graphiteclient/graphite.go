@@ -0,0 +1,152 @@
+package graphiteclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"stream-metrics-route/pkg/batcher"
+	"stream-metrics-route/pkg/setting"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultPoolSize      = 2
+	defaultBatchSize     = 5000
+	defaultFlushInterval = 2 * time.Second
+	defaultTimeout       = 10 * time.Second
+	defaultMaxRetries    = 3
+)
+
+// GraphiteClient ships routed samples to carbon over the plaintext
+// protocol. Batches are written by pool_size workers, each borrowing one of
+// pool_size connections that are dialed lazily and redialed after a failed
+// write.
+type GraphiteClient struct {
+	name       string
+	address    string
+	timeout    time.Duration
+	maxRetries int
+	builder    *lineBuilder
+	pool       chan net.Conn
+	batcher    *batcher.Batcher[[]byte]
+}
+
+func NewGraphite(name string, cfg setting.GraphiteConfig) (*GraphiteClient, error) {
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("couldn't parse the graphite address %q: %v", cfg.Address, err)
+	}
+	builder := &lineBuilder{prefix: cfg.Prefix, labels: cfg.Labels}
+	switch cfg.Format {
+	case "", "path":
+	case "tagged":
+		builder.tagged = true
+	default:
+		return nil, fmt.Errorf("unknown graphite format %q", cfg.Format)
+	}
+
+	g := &GraphiteClient{
+		name:       name,
+		address:    cfg.Address,
+		timeout:    time.Duration(cfg.Timeout),
+		maxRetries: defaultMaxRetries,
+		builder:    builder,
+	}
+	if g.timeout <= 0 {
+		g.timeout = defaultTimeout
+	}
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("graphite max_retries must not be negative")
+		}
+		g.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = defaultPoolSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	g.pool = make(chan net.Conn, cfg.PoolSize)
+	for i := 0; i < cfg.PoolSize; i++ {
+		g.pool <- nil
+	}
+	g.batcher = batcher.New(cfg.BatchSize, flushInterval, cfg.PoolSize, g.write)
+	defaultTelemetry.Logger.Debug("create graphite client", "name", name, "address", cfg.Address, "format", cfg.Format)
+	return g, nil
+}
+
+func (g *GraphiteClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	graphiteTimeseries.WithLabelValues(g.name).Add(float64(len(req)))
+	var lines [][]byte
+	dropped := 0
+	for _, ts := range req {
+		var n int
+		lines, n = g.builder.appendLines(lines, ts)
+		dropped += n
+	}
+	if dropped > 0 {
+		graphiteDroppedSamples.WithLabelValues(g.name).Add(float64(dropped))
+	}
+	g.batcher.Add(lines...)
+	return 0, nil
+}
+
+// Close writes the buffered lines, waits for the writes in flight and closes
+// the pooled connections.
+func (g *GraphiteClient) Close() error {
+	g.batcher.Close()
+	for i := 0; i < cap(g.pool); i++ {
+		if conn := <-g.pool; conn != nil {
+			conn.Close()
+		}
+		g.pool <- nil
+	}
+	return nil
+}
+
+func (g *GraphiteClient) write(lines [][]byte) {
+	bufs := net.Buffers(lines)
+	size := 0
+	for _, l := range lines {
+		size += len(l)
+	}
+
+	conn := <-g.pool
+	defer func() { g.pool <- conn }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout*time.Duration(g.maxRetries+1))
+	defer cancel()
+	err := batcher.Retry(ctx, g.maxRetries, 500*time.Millisecond, 5*time.Second, func() (bool, error) {
+		if conn == nil {
+			c, err := net.DialTimeout("tcp", g.address, g.timeout)
+			if err != nil {
+				return true, err
+			}
+			graphiteConnects.WithLabelValues(g.name).Inc()
+			conn = c
+		}
+		// A partial write can't be resumed on a new connection, so the
+		// whole batch is resent; carbon keeps the last value per point.
+		pending := append(net.Buffers(nil), bufs...)
+		conn.SetWriteDeadline(time.Now().Add(g.timeout))
+		if _, err := pending.WriteTo(conn); err != nil {
+			conn.Close()
+			conn = nil
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		graphiteLinesFailed.WithLabelValues(g.name).Add(float64(len(lines)))
+		defaultTelemetry.Logger.Error("graphite write error", "name", g.name, "lines", len(lines), "bytes", size, "err", err)
+		return
+	}
+	graphiteLinesWritten.WithLabelValues(g.name).Add(float64(len(lines)))
+}
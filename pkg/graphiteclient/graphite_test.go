@@ -0,0 +1,163 @@
+package graphiteclient_test
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"stream-metrics-route/pkg/graphiteclient"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/sinktest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// newCarbonStandIn accepts plaintext connections and forwards every line.
+func newCarbonStandIn(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	lines := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), lines
+}
+
+func readLines(t *testing.T, lines chan string, n int) []string {
+	var got []string
+	for len(got) < n {
+		select {
+		case l := <-lines:
+			got = append(got, l)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out after %d of %d lines", len(got), n)
+		}
+	}
+	return got
+}
+
+func testSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{sinktest.Series("node_load1", []string{"instance", "web-1.example.com:9100", "job", "node exporter", "zone", "eu;west"},
+		prompb.Sample{Value: 0.25, Timestamp: 1700000000123},
+		prompb.Sample{Value: math.Inf(1), Timestamp: 1700000015000},
+		prompb.Sample{Value: 3, Timestamp: 1700000030000},
+	)}
+}
+
+func TestGraphite(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  setting.GraphiteConfig
+		want []string
+	}{
+		{
+			name: "path",
+			cfg:  setting.GraphiteConfig{Prefix: "prom", Labels: []string{"job", "missing", "instance"}},
+			want: []string{
+				"prom.node_load1.node_exporter.web-1_example_com:9100 0.25 1700000000",
+				"prom.node_load1.node_exporter.web-1_example_com:9100 3 1700000030",
+			},
+		},
+		{
+			name: "tagged",
+			cfg:  setting.GraphiteConfig{Format: "tagged", Labels: []string{"zone", "job"}},
+			want: []string{
+				"node_load1;job=node_exporter;zone=eu_west 0.25 1700000000",
+				"node_load1;job=node_exporter;zone=eu_west 3 1700000030",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, lines := newCarbonStandIn(t)
+			tc.cfg.Address = addr
+			tc.cfg.BatchSize = 2
+			tc.cfg.FlushInterval = model.Duration(time.Minute)
+			g, err := graphiteclient.NewGraphite("test", tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := g.Store(context.Background(), testSeries()); err != nil {
+				t.Fatal(err)
+			}
+			got := readLines(t, lines, len(tc.want))
+			for i := range tc.want {
+				if got[i] != tc.want[i] {
+					t.Fatalf("line %d = %q, want %q", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGraphiteClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The client closing its connection ends the scan.
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	g, err := graphiteclient.NewGraphite("test", setting.GraphiteConfig{Address: l.Addr().String(), PoolSize: 1, FlushInterval: model.Duration(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case lines := <-received:
+		if len(lines) != 2 {
+			t.Fatalf("expected the buffered lines written on close, got %q", lines)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the connection closed")
+	}
+}
+
+func TestGraphiteConfigErrors(t *testing.T) {
+	negative := -1
+	sinktest.ConfigErrors(t, func(cfg setting.GraphiteConfig) error {
+		_, err := graphiteclient.NewGraphite("test", cfg)
+		return err
+	},
+		setting.GraphiteConfig{},
+		setting.GraphiteConfig{Address: "localhost"},
+		setting.GraphiteConfig{Address: "localhost:2003", Format: "pickle"},
+		setting.GraphiteConfig{Address: "localhost:2003", MaxRetries: &negative},
+	)
+}
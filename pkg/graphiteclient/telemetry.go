@@ -0,0 +1,53 @@
+package graphiteclient
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_graphite"
+
+var (
+	graphiteTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of handle timeseries total",
+		}, []string{"route_name"})
+	graphiteDroppedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dropped_samples_total",
+			Help:      "Count of NaN or Inf samples that carbon can't store",
+		}, []string{"route_name"})
+	graphiteLinesWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "lines_written_total",
+			Help:      "Count of all lines written to carbon",
+		}, []string{"route_name"})
+	graphiteLinesFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "lines_failed_total",
+			Help:      "Count of all line write failures to carbon",
+		}, []string{"route_name"})
+	graphiteConnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "connects_total",
+			Help:      "Count of all connections dialed to carbon",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(graphiteTimeseries)
+	defaultTelemetry.Register(graphiteDroppedSamples)
+	defaultTelemetry.Register(graphiteLinesWritten)
+	defaultTelemetry.Register(graphiteLinesFailed)
+	defaultTelemetry.Register(graphiteConnects)
+}
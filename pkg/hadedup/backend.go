@@ -0,0 +1,70 @@
+package hadedup
+
+import (
+	"context"
+	"fmt"
+	"stream-metrics-route/pkg/setting"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Backend shares elections between processes. Elect returns the replica
+// elected for cluster once replica reported at now: replica itself if
+// nobody else is elected or the elected replica has been silent for longer
+// than failover, otherwise the elected one. cluster is qualified by the
+// tenant, so tenants sharing a cluster name are elected independently.
+type Backend interface {
+	Elect(ctx context.Context, cluster, replica string, now time.Time, failover time.Duration) (string, error)
+}
+
+// electScript performs the election atomically. Keys expire after a few
+// failover timeouts so clusters that went away don't accumulate.
+var electScript = redis.NewScript(`
+local cur = redis.call('HMGET', KEYS[1], 'replica', 'seen')
+local now = tonumber(ARGV[2])
+if not cur[1] or cur[1] == ARGV[1] or now - tonumber(cur[2]) > tonumber(ARGV[3]) then
+	redis.call('HSET', KEYS[1], 'replica', ARGV[1], 'seen', ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], 10 * tonumber(ARGV[3]))
+	return ARGV[1]
+end
+return cur[1]
+`)
+
+type redisBackend struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+func newRedisBackend(cfg *setting.HADedupRedis) (*redisBackend, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:      cfg.Addrs,
+		MasterName: cfg.MasterName,
+		Username:   cfg.Username,
+		Password:   cfg.Password,
+		DB:         cfg.DB,
+		ClientName: "stream-metrics-route",
+	}
+	if cfg.TLSConfig != (config.TLSConfig{}) {
+		var err error
+		opts.TLSConfig, err = config.NewTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the tls config %v", err)
+		}
+	}
+	b := &redisBackend{keyPrefix: cfg.KeyPrefix}
+	if b.keyPrefix == "" {
+		b.keyPrefix = "stream-metrics-route:ha:"
+	}
+	if cfg.Cluster {
+		b.client = redis.NewClusterClient(opts.Cluster())
+	} else {
+		b.client = redis.NewUniversalClient(opts)
+	}
+	return b, nil
+}
+
+func (b *redisBackend) Elect(ctx context.Context, cluster, replica string, now time.Time, failover time.Duration) (string, error) {
+	return electScript.Run(ctx, b.client, []string{b.keyPrefix + cluster}, replica, now.UnixMilli(), failover.Milliseconds()).Text()
+}
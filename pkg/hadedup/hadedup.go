@@ -0,0 +1,208 @@
+package hadedup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultClusterLabel    = "cluster"
+	defaultReplicaLabel    = "__replica__"
+	defaultFailoverTimeout = 30 * time.Second
+	defaultUpdateTimeout   = 15 * time.Second
+)
+
+// Deduper keeps the samples of one elected replica per HA cluster of a
+// tenant and drops those of the others, like the Cortex HA tracker. A
+// replica keeps its election while it sends samples at least every failover
+// timeout.
+//
+// Without a backend elections are local to the process. With one, each
+// process asks the backend at most every update timeout per cluster and
+// decides from its cached answer in between.
+type Deduper struct {
+	clusterLabel string
+	replicaLabel string
+	failover     time.Duration
+	update       time.Duration
+	backend      Backend
+
+	lock      sync.Mutex
+	elections map[string]*election
+}
+
+type election struct {
+	replica string
+	// lastSeen is when the elected replica last sent samples.
+	lastSeen time.Time
+	// synced is when the backend was last asked about the cluster, and
+	// refreshed when the elected replica itself last renewed its lease.
+	synced    time.Time
+	refreshed time.Time
+}
+
+// New returns nil when deduplication is disabled; a nil Deduper passes
+// every series through.
+func New(cfg setting.HADedupConf) (*Deduper, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var backend Backend
+	if cfg.Redis != nil {
+		var err error
+		backend, err = newRedisBackend(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewWithBackend(cfg, backend)
+}
+
+// NewWithBackend is New with the shared election state in backend; a nil
+// backend keeps it in memory.
+func NewWithBackend(cfg setting.HADedupConf, backend Backend) (*Deduper, error) {
+	d := &Deduper{
+		clusterLabel: cfg.ClusterLabel,
+		replicaLabel: cfg.ReplicaLabel,
+		failover:     time.Duration(cfg.FailoverTimeout),
+		update:       time.Duration(cfg.UpdateTimeout),
+		backend:      backend,
+		elections:    make(map[string]*election),
+	}
+	if d.clusterLabel == "" {
+		d.clusterLabel = defaultClusterLabel
+	}
+	if d.replicaLabel == "" {
+		d.replicaLabel = defaultReplicaLabel
+	}
+	if d.failover <= 0 {
+		d.failover = defaultFailoverTimeout
+	}
+	if d.update <= 0 {
+		d.update = defaultUpdateTimeout
+	}
+	if d.update >= d.failover {
+		return nil, fmt.Errorf("ha_dedup update_timeout %s must be lower than failover_timeout %s", d.update, d.failover)
+	}
+	defaultTelemetry.Logger.Debug("create ha deduper", "cluster_label", d.clusterLabel, "replica_label", d.replicaLabel, "failover", d.failover, "shared", backend != nil)
+	return d, nil
+}
+
+// Filter drops the series of tenant sent by replicas that aren't elected
+// and strips the replica label from the others. Series lacking the cluster
+// or replica label are passed through unchanged. req is filtered in place.
+func (d *Deduper) Filter(tenant string, req []prompb.TimeSeries) []prompb.TimeSeries {
+	if d == nil {
+		return req
+	}
+	now := time.Now()
+	decisions := make(map[[2]string]bool)
+	out := req[:0]
+	for _, ts := range req {
+		var cluster, replica string
+		replicaIdx := -1
+		for i, l := range ts.Labels {
+			switch l.Name {
+			case d.clusterLabel:
+				cluster = l.Value
+			case d.replicaLabel:
+				replica, replicaIdx = l.Value, i
+			}
+		}
+		if cluster == "" || replica == "" {
+			out = append(out, ts)
+			continue
+		}
+		key := [2]string{cluster, replica}
+		accepted, ok := decisions[key]
+		if !ok {
+			accepted = d.accept(electionKey(tenant, cluster), replica, now)
+			decisions[key] = accepted
+		}
+		if !accepted {
+			dedupedSamples.WithLabelValues(cluster).Add(float64(len(ts.Samples)))
+			continue
+		}
+		ts.Labels = append(ts.Labels[:replicaIdx:replicaIdx], ts.Labels[replicaIdx+1:]...)
+		out = append(out, ts)
+	}
+	return out
+}
+
+// electionKey identifies the cluster of a tenant. The tenant is escaped so
+// it can't hold the separator.
+func electionKey(tenant, cluster string) string {
+	return url.QueryEscape(tenant) + ":" + cluster
+}
+
+// accept elects replica for cluster, an election key.
+func (d *Deduper) accept(cluster, replica string, now time.Time) bool {
+	d.lock.Lock()
+	e := d.elections[cluster]
+	if d.backend == nil {
+		defer d.lock.Unlock()
+		return d.acceptLocal(cluster, replica, now)
+	}
+	if e != nil {
+		if e.replica == replica && now.Sub(e.refreshed) < d.update {
+			e.lastSeen = now
+			d.lock.Unlock()
+			return true
+		}
+		if e.replica != replica && now.Sub(e.synced) < d.update {
+			d.lock.Unlock()
+			return false
+		}
+	}
+	d.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.update)
+	defer cancel()
+	elected, err := d.backend.Elect(ctx, cluster, replica, now, d.failover)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		// Keep deduplicating on local knowledge while the backend is down.
+		backendErrors.Inc()
+		defaultTelemetry.Logger.Error("ha dedup backend error", "cluster", cluster, "err", err)
+		return d.acceptLocal(cluster, replica, now)
+	}
+	prev := d.elections[cluster]
+	e = &election{replica: elected, synced: now}
+	if prev != nil && prev.replica == elected {
+		e.lastSeen, e.refreshed = prev.lastSeen, prev.refreshed
+	} else if prev != nil {
+		electedReplicaChanges.WithLabelValues(cluster).Inc()
+	}
+	if elected == replica {
+		e.lastSeen, e.refreshed = now, now
+	}
+	d.elections[cluster] = e
+	return elected == replica
+}
+
+// acceptLocal elects from the state of this process. d.lock must be held.
+func (d *Deduper) acceptLocal(cluster, replica string, now time.Time) bool {
+	e := d.elections[cluster]
+	switch {
+	case e == nil:
+		e = &election{replica: replica}
+		d.elections[cluster] = e
+	case e.replica == replica:
+	case now.Sub(e.lastSeen) > d.failover:
+		defaultTelemetry.Logger.Debug("ha dedup failover", "cluster", cluster, "from", e.replica, "to", replica)
+		electedReplicaChanges.WithLabelValues(cluster).Inc()
+		e.replica = replica
+	default:
+		return false
+	}
+	e.lastSeen = now
+	return true
+}
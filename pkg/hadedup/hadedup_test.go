@@ -0,0 +1,184 @@
+package hadedup_test
+
+import (
+	"context"
+	"errors"
+	"stream-metrics-route/pkg/hadedup"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func request(cluster, replica string) []prompb.TimeSeries {
+	lbs := []prompb.Label{{Name: "__name__", Value: "up"}}
+	if cluster != "" {
+		lbs = append(lbs, prompb.Label{Name: "cluster", Value: cluster})
+	}
+	if replica != "" {
+		lbs = append(lbs, prompb.Label{Name: "__replica__", Value: replica})
+	}
+	lbs = append(lbs, prompb.Label{Name: "job", Value: "node"})
+	return []prompb.TimeSeries{{Labels: lbs, Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}}}
+}
+
+func hasLabel(ts prompb.TimeSeries, name string) bool {
+	for _, l := range ts.Labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var testConf = setting.HADedupConf{
+	Enabled:         true,
+	FailoverTimeout: model.Duration(200 * time.Millisecond),
+	UpdateTimeout:   model.Duration(50 * time.Millisecond),
+}
+
+func TestDeduperInMemory(t *testing.T) {
+	d, err := hadedup.New(testConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := d.Filter("", request("prod", "a"))
+	if len(out) != 1 || hasLabel(out[0], "__replica__") || !hasLabel(out[0], "job") {
+		t.Fatalf("expected replica a to be elected with its replica label stripped, got %v", out)
+	}
+	if out := d.Filter("", request("prod", "b")); len(out) != 0 {
+		t.Fatal("expected replica b to be deduplicated")
+	}
+	if out := d.Filter("", request("staging", "b")); len(out) != 1 {
+		t.Fatal("expected clusters to be elected independently")
+	}
+	if out := d.Filter("", request("", "b")); len(out) != 1 || !hasLabel(out[0], "__replica__") {
+		t.Fatal("expected series without cluster label to pass unchanged")
+	}
+
+	// a keeps its election while it keeps sending.
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		d.Filter("", request("prod", "a"))
+		if out := d.Filter("", request("prod", "b")); len(out) != 0 {
+			t.Fatal("expected replica b to stay deduplicated while a is alive")
+		}
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if out := d.Filter("", request("prod", "b")); len(out) != 1 {
+		t.Fatal("expected failover to replica b")
+	}
+	if out := d.Filter("", request("prod", "a")); len(out) != 0 {
+		t.Fatal("expected replica a to be deduplicated after failover")
+	}
+}
+
+// sharedBackend is an in-memory Backend standing in for Redis.
+type sharedBackend struct {
+	lock    sync.Mutex
+	replica map[string]string
+	seen    map[string]time.Time
+	calls   int
+	fail    bool
+}
+
+func (b *sharedBackend) Elect(ctx context.Context, cluster, replica string, now time.Time, failover time.Duration) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls++
+	if b.fail {
+		return "", errors.New("backend down")
+	}
+	cur, ok := b.replica[cluster]
+	if !ok || cur == replica || now.Sub(b.seen[cluster]) > failover {
+		b.replica[cluster], b.seen[cluster] = replica, now
+		return replica, nil
+	}
+	return cur, nil
+}
+
+func TestDeduperSharedBackend(t *testing.T) {
+	backend := &sharedBackend{replica: map[string]string{}, seen: map[string]time.Time{}}
+	first, err := hadedup.NewWithBackend(testConf, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := hadedup.NewWithBackend(testConf, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := first.Filter("", request("prod", "a")); len(out) != 1 {
+		t.Fatal("expected replica a to be elected")
+	}
+	// The other process learns the election from the backend.
+	if out := second.Filter("", request("prod", "b")); len(out) != 0 {
+		t.Fatal("expected replica b to be deduplicated by the second process")
+	}
+	calls := backend.calls
+	first.Filter("", request("prod", "a"))
+	second.Filter("", request("prod", "b"))
+	if backend.calls != calls {
+		t.Fatalf("expected cached decisions within update_timeout, got %d extra backend calls", backend.calls-calls)
+	}
+
+	// a keeps renewing through the first process while b polls the second.
+	for i := 0; i < 6; i++ {
+		time.Sleep(60 * time.Millisecond)
+		second.Filter("", request("prod", "b"))
+		first.Filter("", request("prod", "a"))
+		if out := second.Filter("", request("prod", "b")); len(out) != 0 {
+			t.Fatal("expected replica b to stay deduplicated while a renews")
+		}
+	}
+
+	// a stops; after the failover timeout the second process elects b.
+	deadline := time.Now().Add(2 * time.Second)
+	for len(second.Filter("", request("prod", "b"))) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected failover to replica b")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// While the backend is down processes fall back to local elections.
+	backend.fail = true
+	time.Sleep(60 * time.Millisecond)
+	if out := second.Filter("", request("prod", "b")); len(out) != 1 {
+		t.Fatal("expected the locally elected replica b to be accepted while the backend is down")
+	}
+}
+
+func TestDeduperDisabledAndInvalid(t *testing.T) {
+	d, err := hadedup.New(setting.HADedupConf{})
+	if err != nil || d != nil {
+		t.Fatalf("expected a nil deduper when disabled, got %v, %v", d, err)
+	}
+	if out := d.Filter("", request("prod", "a")); len(out) != 1 || !hasLabel(out[0], "__replica__") {
+		t.Fatal("expected a nil deduper to pass series through")
+	}
+	if _, err := hadedup.New(setting.HADedupConf{Enabled: true, FailoverTimeout: model.Duration(time.Second), UpdateTimeout: model.Duration(time.Second)}); err == nil {
+		t.Fatal("expected an error when update_timeout isn't lower than failover_timeout")
+	}
+}
+
+func TestDeduperTenants(t *testing.T) {
+	d, err := hadedup.New(testConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := d.Filter("team-a", request("prod", "a")); len(out) != 1 {
+		t.Fatal("expected replica a to be elected for team-a")
+	}
+	if out := d.Filter("team-b", request("prod", "b")); len(out) != 1 {
+		t.Fatal("expected tenants sharing a cluster name to be elected independently")
+	}
+	if out := d.Filter("team-a", request("prod", "b")); len(out) != 0 {
+		t.Fatal("expected replica b to be deduplicated for team-a")
+	}
+}
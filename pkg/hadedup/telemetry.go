@@ -0,0 +1,39 @@
+package hadedup
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_ha_dedup"
+
+var (
+	dedupedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "deduped_samples_total",
+			Help:      "Count of samples dropped because their replica isn't elected",
+		}, []string{"cluster"})
+	electedReplicaChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "elected_replica_changes_total",
+			Help:      "Count of failovers to another replica",
+		}, []string{"cluster"})
+	backendErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "backend_errors_total",
+			Help:      "Count of failed elections in the shared backend",
+		})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(dedupedSamples)
+	defaultTelemetry.Register(electedReplicaChanges)
+	defaultTelemetry.Register(backendErrors)
+}
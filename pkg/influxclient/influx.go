@@ -0,0 +1,240 @@
+package influxclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"stream-metrics-route/pkg/batcher"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultBatchSize     = 5000
+	defaultFlushInterval = 5 * time.Second
+	defaultConcurrency   = 2
+	defaultTimeout       = 10 * time.Second
+	defaultMaxRetries    = 3
+)
+
+const maxErrMsgLen = 1024
+
+// InfluxClient writes routed series as line protocol to the InfluxDB v1
+// `/write` or v2 `/api/v2/write` endpoint.
+type InfluxClient struct {
+	name       string
+	writeURL   string
+	username   string
+	password   string
+	token      string
+	gzip       bool
+	timeout    time.Duration
+	maxRetries int
+	builder    *lineBuilder
+	client     *http.Client
+	batcher    *batcher.Batcher[[]byte]
+}
+
+func NewInflux(name string, cfg setting.InfluxConfig) (*InfluxClient, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil || cfg.URL == "" {
+		return nil, fmt.Errorf("couldn't parse the influx url %q", cfg.URL)
+	}
+	if cfg.Precision == "" {
+		cfg.Precision = "ms"
+	}
+	builder := &lineBuilder{
+		measurement:     cfg.Measurement,
+		measurementName: cfg.MeasurementName,
+		fieldKey:        cfg.FieldKey,
+		fieldLabels:     make(map[string]bool),
+		dropLabels:      make(map[string]bool),
+		precisionDiv:    1,
+		precisionMul:    1,
+	}
+	switch cfg.Measurement {
+	case "", "name", "split", "fixed":
+	default:
+		return nil, fmt.Errorf("unknown influx measurement mode %q", cfg.Measurement)
+	}
+	if builder.fieldKey == "" {
+		builder.fieldKey = "value"
+	}
+	if builder.measurementName == "" {
+		builder.measurementName = "prometheus"
+	}
+	for _, l := range cfg.FieldLabels {
+		builder.fieldLabels[l] = true
+	}
+	for _, l := range cfg.DropLabels {
+		builder.dropLabels[l] = true
+	}
+
+	query := url.Values{}
+	var precision string
+	switch cfg.Precision {
+	case "ns":
+		builder.precisionMul, precision = 1000000, "n"
+	case "us":
+		builder.precisionMul, precision = 1000, "u"
+	case "ms":
+		precision = "ms"
+	case "s":
+		builder.precisionDiv, precision = 1000, "s"
+	default:
+		return nil, fmt.Errorf("unknown influx precision %q", cfg.Precision)
+	}
+	switch cfg.APIVersion {
+	case "", "v1":
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/write"
+		query.Set("db", cfg.Database)
+		if cfg.RetentionPolicy != "" {
+			query.Set("rp", cfg.RetentionPolicy)
+		}
+		query.Set("precision", precision)
+	case "v2":
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v2/write"
+		query.Set("org", cfg.Org)
+		query.Set("bucket", cfg.Bucket)
+		query.Set("precision", cfg.Precision)
+	default:
+		return nil, fmt.Errorf("unknown influx api version %q", cfg.APIVersion)
+	}
+	base.RawQuery = query.Encode()
+
+	httpConfig := config.DefaultHTTPClientConfig
+	httpConfig.TLSConfig = cfg.TLSConfig
+	httpClient, err := config.NewClientFromConfig(httpConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &InfluxClient{
+		name:       name,
+		writeURL:   base.String(),
+		username:   cfg.Username,
+		password:   cfg.Password,
+		token:      cfg.Token,
+		gzip:       cfg.Gzip,
+		timeout:    time.Duration(cfg.Timeout),
+		maxRetries: defaultMaxRetries,
+		builder:    builder,
+		client:     httpClient,
+	}
+	if i.timeout <= 0 {
+		i.timeout = defaultTimeout
+	}
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("influx max_retries must not be negative")
+		}
+		i.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	i.batcher = batcher.New(cfg.BatchSize, flushInterval, cfg.Concurrency, i.write)
+	defaultTelemetry.Logger.Debug("create influx client", "name", name, "url", i.writeURL)
+	return i, nil
+}
+
+func (i *InfluxClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	influxTimeseries.WithLabelValues(i.name).Add(float64(len(req)))
+	var lines [][]byte
+	dropped := 0
+	for _, ts := range req {
+		var n int
+		lines, n = i.builder.appendLines(lines, ts)
+		dropped += n
+	}
+	if dropped > 0 {
+		influxDroppedSamples.WithLabelValues(i.name).Add(float64(dropped))
+	}
+	i.batcher.Add(lines...)
+	return 0, nil
+}
+
+// Close writes the buffered lines and waits for the writes in flight.
+func (i *InfluxClient) Close() error {
+	i.batcher.Close()
+	return nil
+}
+
+func (i *InfluxClient) write(lines [][]byte) {
+	body := bytes.Join(lines, []byte("\n"))
+	if i.gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.timeout*time.Duration(i.maxRetries+1))
+	defer cancel()
+	err := batcher.Retry(ctx, i.maxRetries, 500*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return i.post(ctx, body)
+	})
+	if err != nil {
+		influxLinesFailed.WithLabelValues(i.name).Add(float64(len(lines)))
+		defaultTelemetry.Logger.Error("influx write error", "name", i.name, "lines", len(lines), "err", err)
+		return
+	}
+	influxLinesWritten.WithLabelValues(i.name).Add(float64(len(lines)))
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (i *InfluxClient) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "stream-metrics-route")
+	if i.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	} else if i.username != "" {
+		req.SetBasicAuth(i.username, i.password)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+	line := ""
+	if scanner.Scan() {
+		line = scanner.Text()
+	}
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,184 @@
+package influxclient_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"stream-metrics-route/pkg/influxclient"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type request struct {
+	path   string
+	query  url.Values
+	header http.Header
+	body   string
+}
+
+// startInflux answers writes with the given statuses in turn, then with 204,
+// and records the requests.
+func startInflux(t *testing.T, statuses ...int) (string, func() []request) {
+	var lock sync.Mutex
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, request{path: r.URL.Path, query: r.URL.Query(), header: r.Header, body: string(body)})
+		status := http.StatusNoContent
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, func() []request {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func store(t *testing.T, cfg setting.InfluxConfig, series ...prompb.TimeSeries) {
+	i, err := influxclient.NewInflux("influx", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Store(context.Background(), series); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func up(labels ...prompb.Label) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  append([]prompb.Label{{Name: "__name__", Value: "up"}}, labels...),
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000123}},
+	}
+}
+
+func TestInfluxLineProtocol(t *testing.T) {
+	url, requests := startInflux(t)
+	store(t, setting.InfluxConfig{URL: url, Database: "metrics", FieldLabels: []string{"msg"}, DropLabels: []string{"replica"}},
+		prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "http requests,total"},
+				{Name: "path", Value: "/a b,c=d"},
+				{Name: "host name", Value: "node"},
+				{Name: "msg", Value: `say "hi" \`},
+				{Name: "replica", Value: "a"},
+				{Name: "empty", Value: ""},
+			},
+			Samples: []prompb.Sample{{Value: 1.5, Timestamp: 1697600000123}, {Value: 0, Timestamp: 1697600015000}},
+		},
+		up(prompb.Label{Name: "job", Value: "node"}),
+	)
+
+	r := requests()
+	if len(r) != 1 {
+		t.Fatalf("expected one write on close, got %d", len(r))
+	}
+	expected := strings.Join([]string{
+		`http\ requests\,total,host\ name=node,path=/a\ b\,c\=d value=1.5,msg="say \"hi\" \\" 1697600000123`,
+		`http\ requests\,total,host\ name=node,path=/a\ b\,c\=d value=0,msg="say \"hi\" \\" 1697600015000`,
+		`up,job=node value=1 1697600000123`,
+	}, "\n")
+	if r[0].body != expected {
+		t.Fatalf("unexpected lines\n%s\nexpected\n%s", r[0].body, expected)
+	}
+}
+
+func TestInfluxMeasurement(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  setting.InfluxConfig
+		line string
+	}{
+		{setting.InfluxConfig{Measurement: "split"}, "node,job=node cpu_seconds=1 1697600000123"},
+		{setting.InfluxConfig{Measurement: "fixed", MeasurementName: "prom"}, "prom,job=node node_cpu_seconds=1 1697600000123"},
+		{setting.InfluxConfig{FieldKey: "v"}, "node_cpu_seconds,job=node v=1 1697600000123"},
+	} {
+		url, requests := startInflux(t)
+		tc.cfg.URL = url
+		store(t, tc.cfg, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "node_cpu_seconds"}, {Name: "job", Value: "node"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000123}},
+		})
+		if r := requests(); len(r) != 1 || r[0].body != tc.line {
+			t.Fatalf("%+v: expected %q, got %+v", tc.cfg, tc.line, r)
+		}
+	}
+}
+
+func TestInfluxAPIVersions(t *testing.T) {
+	for _, tc := range []struct {
+		cfg       setting.InfluxConfig
+		path      string
+		query     string
+		auth      string
+		timestamp string
+	}{
+		{setting.InfluxConfig{Database: "db", RetentionPolicy: "week", Precision: "s", Username: "u", Password: "p"}, "/write", "db=db&precision=s&rp=week", "Basic dTpw", "1697600000"},
+		{setting.InfluxConfig{Database: "db", Precision: "ns"}, "/write", "db=db&precision=n", "", "1697600000123000000"},
+		{setting.InfluxConfig{APIVersion: "v2", Org: "org", Bucket: "bucket", Precision: "us", Token: "secret"}, "/api/v2/write", "bucket=bucket&org=org&precision=us", "Token secret", "1697600000123000"},
+		{setting.InfluxConfig{APIVersion: "v2", Org: "org", Bucket: "bucket"}, "/api/v2/write", "bucket=bucket&org=org&precision=ms", "", "1697600000123"},
+	} {
+		url, requests := startInflux(t)
+		tc.cfg.URL = url + "/"
+		store(t, tc.cfg, up())
+		r := requests()
+		if len(r) != 1 {
+			t.Fatalf("%+v: expected one write, got %d", tc.cfg, len(r))
+		}
+		if r[0].path != tc.path || r[0].query.Encode() != tc.query || r[0].header.Get("Authorization") != tc.auth {
+			t.Fatalf("%+v: unexpected request %s?%s with authorization %q", tc.cfg, r[0].path, r[0].query.Encode(), r[0].header.Get("Authorization"))
+		}
+		if !strings.HasSuffix(r[0].body, " "+tc.timestamp) {
+			t.Fatalf("%+v: expected timestamp %s, got %q", tc.cfg, tc.timestamp, r[0].body)
+		}
+	}
+}
+
+func TestInfluxRetries(t *testing.T) {
+	zero, one := 0, 1
+	for _, tc := range []struct {
+		name       string
+		maxRetries *int
+		statuses   []int
+		writes     int
+	}{
+		{"retried server error", &one, []int{http.StatusServiceUnavailable}, 2},
+		{"retried throttling", &one, []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, 2},
+		{"client error", &one, []int{http.StatusBadRequest}, 1},
+		{"retries disabled", &zero, []int{http.StatusServiceUnavailable}, 1},
+	} {
+		url, requests := startInflux(t, tc.statuses...)
+		store(t, setting.InfluxConfig{URL: url, MaxRetries: tc.maxRetries}, up())
+		if r := requests(); len(r) != tc.writes {
+			t.Fatalf("%s: expected %d writes, got %d", tc.name, tc.writes, len(r))
+		}
+	}
+}
+
+func TestInfluxConfigErrors(t *testing.T) {
+	negative := -1
+	for _, cfg := range []setting.InfluxConfig{
+		{},
+		{URL: "http://localhost:8086", Precision: "m"},
+		{URL: "http://localhost:8086", APIVersion: "v3"},
+		{URL: "http://localhost:8086", Measurement: "label"},
+		{URL: "http://localhost:8086", MaxRetries: &negative},
+	} {
+		if _, err := influxclient.NewInflux("influx", cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package influxclient
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// lineBuilder converts prompb series to InfluxDB line protocol.
+type lineBuilder struct {
+	measurement     string
+	measurementName string
+	fieldKey        string
+	fieldLabels     map[string]bool
+	dropLabels      map[string]bool
+	// precisionDiv and precisionMul convert millisecond timestamps.
+	precisionDiv int64
+	precisionMul int64
+}
+
+// names derives the measurement and the field key from a metric name.
+func (b *lineBuilder) names(metric string) (string, string) {
+	switch b.measurement {
+	case "split":
+		if i := strings.IndexByte(metric, '_'); i > 0 && i < len(metric)-1 {
+			return metric[:i], metric[i+1:]
+		}
+		return metric, b.fieldKey
+	case "fixed":
+		return b.measurementName, metric
+	default:
+		return metric, b.fieldKey
+	}
+}
+
+// appendLines returns one line per sample of ts, and how many samples were
+// dropped because line protocol can't represent them.
+func (b *lineBuilder) appendLines(dst [][]byte, ts prompb.TimeSeries) ([][]byte, int) {
+	var metric string
+	tags := make([]prompb.Label, 0, len(ts.Labels))
+	fields := make([]prompb.Label, 0)
+	for _, l := range ts.Labels {
+		switch {
+		case l.Name == "__name__":
+			metric = l.Value
+		case b.dropLabels[l.Name] || l.Value == "":
+		case b.fieldLabels[l.Name]:
+			fields = append(fields, l)
+		default:
+			tags = append(tags, l)
+		}
+	}
+	if metric == "" {
+		return dst, len(ts.Samples)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	measurement, field := b.names(metric)
+
+	var prefix strings.Builder
+	prefix.WriteString(measurementEscaper.Replace(measurement))
+	for _, t := range tags {
+		prefix.WriteByte(',')
+		prefix.WriteString(keyEscaper.Replace(t.Name))
+		prefix.WriteByte('=')
+		prefix.WriteString(keyEscaper.Replace(t.Value))
+	}
+	prefix.WriteByte(' ')
+	prefix.WriteString(keyEscaper.Replace(field))
+	prefix.WriteByte('=')
+
+	var suffix strings.Builder
+	for _, f := range fields {
+		suffix.WriteByte(',')
+		suffix.WriteString(keyEscaper.Replace(f.Name))
+		suffix.WriteString(`="`)
+		suffix.WriteString(stringEscaper.Replace(f.Value))
+		suffix.WriteByte('"')
+	}
+	suffix.WriteByte(' ')
+
+	dropped := 0
+	for _, s := range ts.Samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			dropped++
+			continue
+		}
+		line := make([]byte, 0, prefix.Len()+suffix.Len()+40)
+		line = append(line, prefix.String()...)
+		line = strconv.AppendFloat(line, s.Value, 'g', -1, 64)
+		line = append(line, suffix.String()...)
+		line = strconv.AppendInt(line, s.Timestamp*b.precisionMul/b.precisionDiv, 10)
+		dst = append(dst, line)
+	}
+	return dst, dropped
+}
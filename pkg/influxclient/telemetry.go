@@ -0,0 +1,46 @@
+package influxclient
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_influx"
+
+var (
+	influxTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of handle timeseries total",
+		}, []string{"route_name"})
+	influxDroppedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dropped_samples_total",
+			Help:      "Count of samples that can't be represented in line protocol",
+		}, []string{"route_name"})
+	influxLinesWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "lines_written_total",
+			Help:      "Count of all lines written to InfluxDB",
+		}, []string{"route_name"})
+	influxLinesFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "lines_failed_total",
+			Help:      "Count of all line write failures to InfluxDB",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(influxTimeseries)
+	defaultTelemetry.Register(influxDroppedSamples)
+	defaultTelemetry.Register(influxLinesWritten)
+	defaultTelemetry.Register(influxLinesFailed)
+}
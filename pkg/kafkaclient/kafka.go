@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"stream-metrics-route/pkg/serialize"
 	"stream-metrics-route/pkg/setting"
 	"strings"
 	"text/template"
@@ -32,12 +33,12 @@ type KafkaClient struct {
 
 func NewKafka(name string, cfg setting.KafkaConfig) (*KafkaClient, error) {
 
-	topicTemplate, err := parseTopicTemplate(cfg.KafkaTopic)
+	topicTemplate, err := serialize.ParseTopicTemplate(cfg.KafkaTopic)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse the topic template %v", err)
 	}
 
-	matchList, err := parseMatchList(cfg.Match)
+	matchList, err := serialize.ParseMatchList(cfg.Match)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse the match rules %v", err)
 	}
@@ -2,6 +2,7 @@ package kafkaclient
 
 import (
 	"os"
+	"stream-metrics-route/pkg/serialize"
 	"stream-metrics-route/pkg/telemetry"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,30 +13,7 @@ var defaultTelemetry telemetry.Telemetry
 var metricNamespace string = "stream_kafka"
 
 var (
-	promBatches = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: metricNamespace,
-			Name:      "incoming_prometheus_batches_total",
-			Help:      "Count of incoming prometheus batches (to be broken into individual metrics)",
-		}, []string{"route_name"})
-	serializeTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: metricNamespace,
-			Name:      "serialized_total",
-			Help:      "Count of all serialization requests",
-		}, []string{"route_name"})
-	serializeFailed = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: metricNamespace,
-			Name:      "serialized_failed_total",
-			Help:      "Count of all serialization failures",
-		}, []string{"route_name"})
-	objectsFiltered = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: metricNamespace,
-			Name:      "objects_filtered_total",
-			Help:      "Count of all filter attempts",
-		}, []string{"route_name"})
+	kafkaCounters  = serialize.NewCounters(metricNamespace)
 	objectsWritten = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: metricNamespace,
@@ -52,14 +30,11 @@ var (
 
 func init() {
 	defaultTelemetry = telemetry.NewTelemetry()
-	defaultTelemetry.Register(promBatches)
-	defaultTelemetry.Register(serializeTotal)
-	defaultTelemetry.Register(serializeFailed)
-	defaultTelemetry.Register(objectsFiltered)
+	kafkaCounters.Register(defaultTelemetry)
 	defaultTelemetry.Register(objectsFailed)
 	defaultTelemetry.Register(objectsWritten)
 	var err error
-	serializer, err = parseSerializationFormat(os.Getenv("SERIALIZATION_FORMAT"))
+	serializer, err = serialize.ParseSerializationFormat(os.Getenv("SERIALIZATION_FORMAT"))
 	if err != nil {
 		defaultTelemetry.Logger.Error("couldn't create a metrics serializer")
 	}
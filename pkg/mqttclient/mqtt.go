@@ -0,0 +1,498 @@
+package mqttclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"text/template"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultKeepAlive      = 30 * time.Second
+	defaultConnectTimeout = 10 * time.Second
+	defaultBufferSize     = 10000
+	defaultMaxInflight    = 64
+	maxReconnectBackoff   = 30 * time.Second
+	closeTimeout          = 10 * time.Second
+)
+
+// errClosed ends the session once Close drained it.
+var errClosed = errors.New("mqtt client closed")
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// MqttClient publishes serialized samples to MQTT 3.1.1 or 5 brokers. Store
+// only enqueues messages; a background session publishes them and keeps
+// reconnecting, so publishes are buffered while the broker is unreachable.
+type MqttClient struct {
+	name           string
+	brokers        []string
+	version        byte
+	clientID       string
+	username       string
+	password       string
+	qos            byte
+	retained       bool
+	keepAlive      time.Duration
+	connectTimeout time.Duration
+	maxInflight    int
+	tlsConfig      *tls.Config
+
+	match         map[string]*dto.MetricFamily
+	serializer    serialize.Serializer
+	TopicTemplate template.Template
+
+	queue chan message
+	lock  sync.Mutex
+	// retry holds messages that were in flight when a session ended.
+	retry []message
+
+	// sending keeps Close from racing Store. Once closing, sessions drain
+	// what is buffered and in flight; stop ends them when that takes too
+	// long.
+	sending sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewMqtt(name string, cfg setting.MqttConfig) (*MqttClient, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("at least one mqtt broker is required")
+	}
+	topicTemplate, err := serialize.ParseTopicTemplate(cfg.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the topic template %v", err)
+	}
+	matchList, err := serialize.ParseMatchList(cfg.Match)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the match rules %v", err)
+	}
+	format := cfg.SerializationFormat
+	if format == "" {
+		format = os.Getenv("SERIALIZATION_FORMAT")
+	}
+	serializer, err := serialize.ParseSerializationFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create a metrics serializer %v", err)
+	}
+
+	var version byte
+	switch cfg.ProtocolVersion {
+	case "", "3.1.1", "4":
+		version = protocolV311
+	case "5", "5.0":
+		version = protocolV5
+	default:
+		return nil, fmt.Errorf("unsupported mqtt protocol version %q", cfg.ProtocolVersion)
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", cfg.QoS)
+	}
+
+	m := &MqttClient{
+		name:           name,
+		brokers:        cfg.Brokers,
+		version:        version,
+		clientID:       cfg.ClientID,
+		username:       cfg.Username,
+		password:       cfg.Password,
+		qos:            cfg.QoS,
+		retained:       cfg.Retained,
+		keepAlive:      time.Duration(cfg.KeepAlive),
+		connectTimeout: time.Duration(cfg.ConnectTimeout),
+		maxInflight:    cfg.MaxInflight,
+		match:          matchList,
+		serializer:     serializer,
+		TopicTemplate:  *topicTemplate,
+		closing:        make(chan struct{}),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	if m.clientID == "" {
+		hostname, _ := os.Hostname()
+		m.clientID = fmt.Sprintf("stream-metrics-route-%s-%s", hostname, name)
+	}
+	if m.keepAlive <= 0 {
+		m.keepAlive = defaultKeepAlive
+	}
+	if m.connectTimeout <= 0 {
+		m.connectTimeout = defaultConnectTimeout
+	}
+	if m.maxInflight <= 0 {
+		m.maxInflight = defaultMaxInflight
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultBufferSize
+	}
+	if cfg.TLSConfig != (config.TLSConfig{}) {
+		m.tlsConfig, err = config.NewTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the tls config %v", err)
+		}
+	}
+	m.queue = make(chan message, cfg.BufferSize)
+
+	go m.run()
+	defaultTelemetry.Logger.Debug("create mqtt client", "name", name, "brokers", cfg.Brokers, "version", version)
+	return m, nil
+}
+
+func (m *MqttClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	m.sending.RLock()
+	defer m.sending.RUnlock()
+	if m.closed {
+		return http.StatusServiceUnavailable, fmt.Errorf("mqtt client %s is closed", m.name)
+	}
+	metricsPerTopic, err := serialize.Serialize(m.name, m.TopicTemplate, m.match, m.serializer, mqttCounters, req)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("couldn't process write request %v", err)
+	}
+
+	dropped := 0
+	for topic, metrics := range metricsPerTopic {
+		for _, metric := range metrics {
+			select {
+			case m.queue <- message{topic: topic, payload: metric}:
+			default:
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		objectsFailed.WithLabelValues(m.name).Add(float64(dropped))
+		return http.StatusServiceUnavailable, fmt.Errorf("mqtt publish buffer is full, dropped %d messages", dropped)
+	}
+	return 0, nil
+}
+
+// Close stops accepting series and waits up to closeTimeout for the
+// buffered messages to be published and acknowledged, then disconnects.
+// Messages left unpublished are counted as failed.
+func (m *MqttClient) Close() error {
+	m.sending.Lock()
+	if m.closed {
+		m.sending.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.closing)
+	m.sending.Unlock()
+
+	select {
+	case <-m.stopped:
+		return nil
+	case <-time.After(closeTimeout):
+	}
+	close(m.stop)
+	<-m.stopped
+	m.lock.Lock()
+	lost := len(m.queue) + len(m.retry)
+	m.lock.Unlock()
+	objectsFailed.WithLabelValues(m.name).Add(float64(lost))
+	return fmt.Errorf("mqtt client %s closed with %d messages unpublished", m.name, lost)
+}
+
+func (m *MqttClient) run() {
+	defer close(m.stopped)
+	backoff := time.Second
+	for i := 0; !m.done(); i++ {
+		broker := m.brokers[i%len(m.brokers)]
+		conn, inflight, err := m.connect(broker)
+		if err != nil {
+			defaultTelemetry.Logger.Error("mqtt connect error", "name", m.name, "broker", broker, "err", err)
+			select {
+			case <-time.After(backoff):
+			case <-m.stop:
+			}
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		mqttConnected.WithLabelValues(m.name).Set(1)
+		defaultTelemetry.Logger.Info("mqtt connected", "name", m.name, "broker", broker)
+
+		err = m.session(conn, inflight)
+		mqttConnected.WithLabelValues(m.name).Set(0)
+		if err == errClosed {
+			defaultTelemetry.Logger.Info("mqtt disconnected", "name", m.name, "broker", broker)
+			continue
+		}
+		mqttReconnects.WithLabelValues(m.name).Inc()
+		defaultTelemetry.Logger.Warn("mqtt session closed", "name", m.name, "broker", broker, "err", err)
+	}
+}
+
+// done reports whether run should stop: when Close gave up, or when it's
+// closing with nothing left to publish.
+func (m *MqttClient) done() bool {
+	select {
+	case <-m.stop:
+		return true
+	case <-m.closing:
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		return len(m.queue) == 0 && len(m.retry) == 0
+	default:
+		return false
+	}
+}
+
+// connect opens a session on broker and returns the number of QoS 1 and 2
+// publishes allowed in flight, max_inflight within the broker's Receive
+// Maximum.
+func (m *MqttClient) connect(broker string) (net.Conn, int, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, 0, err
+	}
+	dialer := &net.Dialer{Timeout: m.connectTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		tlsConfig := m.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u.Host, "8883"), tlsConfig)
+	default:
+		return nil, 0, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn.SetDeadline(time.Now().Add(m.connectTimeout))
+	keepAlive := uint16(m.keepAlive / time.Second)
+	if _, err := conn.Write(connectPacket(m.version, m.clientID, m.username, m.password, keepAlive)); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	p, err := readPacket(bufio.NewReader(conn))
+	if err == nil {
+		err = connackError(p)
+	}
+	inflight := m.maxInflight
+	if err == nil && m.version == protocolV5 {
+		var receiveMax int
+		receiveMax, err = receiveMaximum(p)
+		if receiveMax > 0 && receiveMax < inflight {
+			inflight = receiveMax
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, inflight, nil
+}
+
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// session publishes queued messages over conn until the connection fails,
+// or until the client is closing and every message was acknowledged. QoS 1
+// and 2 publishes are windowed by inflight; whatever is still
+// unacknowledged when the session ends is published again on the next one.
+func (m *MqttClient) session(conn net.Conn, inflight int) error {
+	var (
+		writeLock sync.Mutex
+		stateLock sync.Mutex
+		pending   = make(map[uint16]message)
+		nextID    uint16
+		slots     = make(chan struct{}, inflight)
+		done      = make(chan error, 1)
+	)
+	write := func(b []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(m.connectTimeout))
+		_, err := conn.Write(b)
+		return err
+	}
+	complete := func(id uint16, reason byte) {
+		stateLock.Lock()
+		_, ok := pending[id]
+		delete(pending, id)
+		stateLock.Unlock()
+		if !ok {
+			return
+		}
+		<-slots
+		if reason >= 0x80 {
+			objectsFailed.WithLabelValues(m.name).Inc()
+			defaultTelemetry.Logger.Error("mqtt publish rejected", "name", m.name, "reason", reason)
+			return
+		}
+		objectsWritten.WithLabelValues(m.name).Inc()
+	}
+
+	defer func() {
+		write(disconnectPacket())
+		conn.Close()
+		stateLock.Lock()
+		m.lock.Lock()
+		for _, msg := range pending {
+			m.retry = append(m.retry, msg)
+		}
+		m.lock.Unlock()
+		stateLock.Unlock()
+	}()
+
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			conn.SetReadDeadline(time.Now().Add(m.keepAlive * 3 / 2))
+			p, err := readPacket(r)
+			if err != nil {
+				done <- err
+				return
+			}
+			switch p.kind() {
+			case packetPuback, packetPubcomp:
+				if id, reason, err := ackInfo(p); err == nil {
+					complete(id, reason)
+				}
+			case packetPubrec:
+				id, reason, err := ackInfo(p)
+				if err != nil {
+					continue
+				}
+				if reason >= 0x80 {
+					complete(id, reason)
+					continue
+				}
+				if err := write(pubrelPacket(id)); err != nil {
+					done <- err
+					return
+				}
+			case packetDisconnect:
+				done <- errors.New("disconnected by broker")
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(m.keepAlive)
+	defer ping.Stop()
+	for {
+		msg, ok := m.popRetry()
+		if !ok {
+			select {
+			case err := <-done:
+				return err
+			case <-ping.C:
+				if err := write(pingreqPacket()); err != nil {
+					return err
+				}
+				continue
+			case msg = <-m.queue:
+			case <-m.closing:
+				// Store no longer enqueues, so once the queue is empty
+				// only the acks of the messages in flight are awaited.
+				select {
+				case msg = <-m.queue:
+				default:
+					return m.awaitAcks(write, slots, done, ping.C)
+				}
+			}
+		}
+
+		if m.qos == 0 {
+			if err := write(publishPacket(m.version, msg.topic, msg.payload, 0, m.retained, false, 0)); err != nil {
+				m.pushRetry(msg)
+				return err
+			}
+			objectsWritten.WithLabelValues(m.name).Inc()
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case err := <-done:
+			m.pushRetry(msg)
+			return err
+		case <-m.stop:
+			m.pushRetry(msg)
+			return errClosed
+		}
+		stateLock.Lock()
+		for {
+			nextID++
+			if _, used := pending[nextID]; nextID != 0 && !used {
+				break
+			}
+		}
+		id := nextID
+		pending[id] = msg
+		stateLock.Unlock()
+		if err := write(publishPacket(m.version, msg.topic, msg.payload, m.qos, m.retained, false, id)); err != nil {
+			return err
+		}
+	}
+}
+
+// awaitAcks waits for every slot of the in-flight window to be released,
+// keeping the session alive meanwhile.
+func (m *MqttClient) awaitAcks(write func([]byte) error, slots chan struct{}, done <-chan error, ping <-chan time.Time) error {
+	for n := 0; n < cap(slots); {
+		select {
+		case slots <- struct{}{}:
+			n++
+		case err := <-done:
+			return err
+		case <-ping:
+			if err := write(pingreqPacket()); err != nil {
+				return err
+			}
+		case <-m.stop:
+			return errClosed
+		}
+	}
+	return errClosed
+}
+
+func (m *MqttClient) popRetry() (message, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.retry) == 0 {
+		return message{}, false
+	}
+	msg := m.retry[0]
+	m.retry = m.retry[1:]
+	return msg, true
+}
+
+func (m *MqttClient) pushRetry(msg message) {
+	m.lock.Lock()
+	m.retry = append(m.retry, msg)
+	m.lock.Unlock()
+}
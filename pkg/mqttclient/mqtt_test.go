@@ -0,0 +1,221 @@
+package mqttclient_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"stream-metrics-route/pkg/mqttclient"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type published struct {
+	topic  string
+	retain bool
+	// inflight counts the unacknowledged publishes, this one included.
+	inflight int32
+}
+
+// startBroker accepts a single MQTT session, acknowledges every publish for
+// the negotiated QoS and reports the published topics until the client
+// disconnects. A non-zero receiveMax is announced to MQTT 5 clients, and
+// acks are then delayed to observe the in-flight window.
+func startBroker(t *testing.T, receiveMax byte) (string, <-chan published) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	out := make(chan published, 16)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var (
+			writeLock sync.Mutex
+			inflight  int32
+		)
+		write := func(b []byte) {
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			conn.Write(b)
+		}
+		ack := func(b []byte) {
+			if receiveMax == 0 {
+				atomic.AddInt32(&inflight, -1)
+				write(b)
+				return
+			}
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&inflight, -1)
+				write(b)
+			}()
+		}
+		r := bufio.NewReader(conn)
+		var version byte
+		for {
+			header, body, err := readPacket(r)
+			if err != nil {
+				return
+			}
+			switch header & 0xF0 {
+			case 0x10: // CONNECT
+				version = body[6]
+				switch {
+				case version == 5 && receiveMax > 0:
+					write([]byte{0x20, 6, 0, 0, 3, 0x21, 0, receiveMax})
+				case version == 5:
+					write([]byte{0x20, 3, 0, 0, 0})
+				default:
+					write([]byte{0x20, 2, 0, 0})
+				}
+			case 0x30: // PUBLISH
+				qos := (header >> 1) & 0x03
+				topicLen := int(binary.BigEndian.Uint16(body))
+				topic := string(body[2 : 2+topicLen])
+				if qos == 0 {
+					out <- published{topic: topic, retain: header&0x01 == 1}
+					continue
+				}
+				out <- published{topic: topic, retain: header&0x01 == 1, inflight: atomic.AddInt32(&inflight, 1)}
+				id := body[2+topicLen : 4+topicLen]
+				if qos == 1 {
+					ack([]byte{0x40, 2, id[0], id[1]})
+				} else {
+					write([]byte{0x50, 2, id[0], id[1]})
+				}
+			case 0x60: // PUBREL
+				ack([]byte{0x70, 2, body[0], body[1]})
+			case 0xC0: // PINGREQ
+				write([]byte{0xD0, 0})
+			case 0xE0: // DISCONNECT
+				close(out)
+				return
+			}
+		}
+	}()
+	return "tcp://" + l.Addr().String(), out
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func TestMqttPublish(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		qos     byte
+	}{
+		{version: "3.1.1", qos: 2},
+		{version: "5", qos: 1},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			broker, out := startBroker(t, 0)
+			client, err := mqttclient.NewMqtt("mqtt-"+tc.version, setting.MqttConfig{
+				Brokers:         []string{broker},
+				ProtocolVersion: tc.version,
+				Topic:           `factory/{{ index . "line" | replace "-" "/" }}/{{ index . "__name__" }}`,
+				QoS:             tc.qos,
+				Retained:        true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "temperature"}, {Name: "line", Value: "hall-a"}},
+				Samples: []prompb.Sample{{Value: 21.5, Timestamp: 1697600000000}},
+			}}
+			if _, err := client.Store(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case p := <-out:
+				if p.topic != "factory/hall/a/temperature" || !p.retain {
+					t.Fatalf("unexpected publish %+v", p)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for publish")
+			}
+		})
+	}
+}
+
+func TestMqttClose(t *testing.T) {
+	for _, tc := range []struct {
+		version    string
+		receiveMax byte
+	}{
+		{version: "3.1.1"},
+		{version: "5", receiveMax: 1},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			broker, out := startBroker(t, tc.receiveMax)
+			client, err := mqttclient.NewMqtt("mqtt-close-"+tc.version, setting.MqttConfig{
+				Brokers:         []string{broker},
+				ProtocolVersion: tc.version,
+				Topic:           `metrics/{{ index . "instance" }}`,
+				QoS:             1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var req []prompb.TimeSeries
+			for i := 0; i < 5; i++ {
+				req = append(req, prompb.TimeSeries{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: strconv.Itoa(i)}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+				})
+			}
+			if _, err := client.Store(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+			// Close publishes the queue and waits for the acks before
+			// disconnecting, which ends the broker reports.
+			if err := client.Close(); err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for p := range out {
+				n++
+				if tc.receiveMax > 0 && p.inflight > int32(tc.receiveMax) {
+					t.Fatalf("%d publishes in flight, more than the receive maximum", p.inflight)
+				}
+			}
+			if n != 5 {
+				t.Fatalf("expected 5 publishes before the disconnect, got %d", n)
+			}
+			if _, err := client.Store(context.Background(), req); err == nil {
+				t.Fatal("expected stores to fail once closed")
+			}
+		})
+	}
+}
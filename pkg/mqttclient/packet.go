@@ -0,0 +1,237 @@
+package mqttclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	protocolV311 byte = 4
+	protocolV5   byte = 5
+)
+
+// MQTT control packet types, already shifted into the high nibble.
+const (
+	packetConnect    byte = 0x10
+	packetConnack    byte = 0x20
+	packetPublish    byte = 0x30
+	packetPuback     byte = 0x40
+	packetPubrec     byte = 0x50
+	packetPubrel     byte = 0x60
+	packetPubcomp    byte = 0x70
+	packetPingreq    byte = 0xC0
+	packetPingresp   byte = 0xD0
+	packetDisconnect byte = 0xE0
+)
+
+type packet struct {
+	header byte
+	body   []byte
+}
+
+func (p packet) kind() byte { return p.header & 0xF0 }
+
+func appendVarInt(dst []byte, n int) []byte {
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		dst = append(dst, b)
+		if n == 0 {
+			return dst
+		}
+	}
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+func encodePacket(header byte, body []byte) []byte {
+	dst := make([]byte, 0, len(body)+5)
+	dst = append(dst, header)
+	dst = appendVarInt(dst, len(body))
+	return append(dst, body...)
+}
+
+func readVarInt(r *bufio.Reader) (int, error) {
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, errors.New("malformed variable byte integer")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			return n, nil
+		}
+		multiplier *= 128
+	}
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	length, err := readVarInt(r)
+	if err != nil {
+		return packet{}, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{header: header, body: body}, nil
+}
+
+func connectPacket(version byte, clientID, username, password string, keepAlive uint16) []byte {
+	var flags byte = 0x02 // clean session / clean start
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, version, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	if version == protocolV5 {
+		body = appendVarInt(body, 0)
+	}
+	body = appendString(body, clientID)
+	if username != "" {
+		body = appendString(body, username)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+	return encodePacket(packetConnect, body)
+}
+
+func publishPacket(version byte, topic string, payload []byte, qos byte, retain, dup bool, id uint16) []byte {
+	header := packetPublish | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	if dup {
+		header |= 0x08
+	}
+	body := make([]byte, 0, len(topic)+len(payload)+5)
+	body = appendString(body, topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	if version == protocolV5 {
+		body = appendVarInt(body, 0)
+	}
+	body = append(body, payload...)
+	return encodePacket(header, body)
+}
+
+func pubrelPacket(id uint16) []byte {
+	return encodePacket(packetPubrel|0x02, binary.BigEndian.AppendUint16(nil, id))
+}
+
+func pingreqPacket() []byte {
+	return []byte{packetPingreq, 0}
+}
+
+func disconnectPacket() []byte {
+	return []byte{packetDisconnect, 0}
+}
+
+// connackError returns the error carried by a CONNACK, if any.
+func connackError(p packet) error {
+	if p.kind() != packetConnack || len(p.body) < 2 {
+		return fmt.Errorf("expected CONNACK, got packet type 0x%x", p.kind())
+	}
+	if code := p.body[1]; code != 0 {
+		return fmt.Errorf("connection refused with reason code 0x%x", code)
+	}
+	return nil
+}
+
+// ackInfo extracts the packet id and the MQTT 5 reason code of a PUBACK,
+// PUBREC or PUBCOMP. The reason code is omitted by the broker on success.
+func ackInfo(p packet) (uint16, byte, error) {
+	if len(p.body) < 2 {
+		return 0, 0, errors.New("malformed acknowledgement")
+	}
+	id := binary.BigEndian.Uint16(p.body)
+	var reason byte
+	if len(p.body) > 2 {
+		reason = p.body[2]
+	}
+	return id, reason, nil
+}
+
+// receiveMaximum returns the Receive Maximum of an MQTT 5 CONNACK, the
+// number of QoS 1 and 2 publishes the broker accepts in flight, or 0 when
+// it sets none.
+func receiveMaximum(p packet) (int, error) {
+	if len(p.body) <= 2 {
+		return 0, nil
+	}
+	r := bufio.NewReader(bytes.NewReader(p.body[2:]))
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	props := make([]byte, length)
+	if _, err := io.ReadFull(r, props); err != nil {
+		return 0, err
+	}
+	for len(props) > 0 {
+		id := props[0]
+		props = props[1:]
+		var size int
+		switch id {
+		case 0x01, 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2A:
+			size = 1
+		case 0x13, 0x21, 0x22, 0x23:
+			size = 2
+		case 0x02, 0x11, 0x18, 0x27:
+			size = 4
+		case 0x0B:
+			for size < len(props) && props[size]&0x80 != 0 {
+				size++
+			}
+			size++
+		case 0x03, 0x08, 0x09, 0x12, 0x15, 0x16, 0x1A, 0x1C, 0x1F:
+			if len(props) < 2 {
+				return 0, errors.New("malformed CONNACK properties")
+			}
+			size = 2 + int(binary.BigEndian.Uint16(props))
+		case 0x26:
+			if len(props) < 2 {
+				return 0, errors.New("malformed CONNACK properties")
+			}
+			size = 2 + int(binary.BigEndian.Uint16(props))
+			if len(props) < size+2 {
+				return 0, errors.New("malformed CONNACK properties")
+			}
+			size += 2 + int(binary.BigEndian.Uint16(props[size:]))
+		default:
+			return 0, fmt.Errorf("unknown CONNACK property 0x%x", id)
+		}
+		if len(props) < size {
+			return 0, errors.New("malformed CONNACK properties")
+		}
+		if id == 0x21 {
+			return int(binary.BigEndian.Uint16(props)), nil
+		}
+		props = props[size:]
+	}
+	return 0, nil
+}
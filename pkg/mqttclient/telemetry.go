@@ -0,0 +1,49 @@
+package mqttclient
+
+import (
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_mqtt"
+
+var (
+	mqttCounters   = serialize.NewCounters(metricNamespace)
+	objectsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_written_total",
+			Help:      "Count of all objects published to MQTT",
+		}, []string{"route_name"})
+	objectsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_failed_total",
+			Help:      "Count of all objects dropped or rejected by MQTT",
+		}, []string{"route_name"})
+	mqttConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "connected",
+			Help:      "Whether the route has an established MQTT session",
+		}, []string{"route_name"})
+	mqttReconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "reconnects_total",
+			Help:      "Count of MQTT sessions that were lost",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	mqttCounters.Register(defaultTelemetry)
+	defaultTelemetry.Register(objectsFailed)
+	defaultTelemetry.Register(objectsWritten)
+	defaultTelemetry.Register(mqttConnected)
+	defaultTelemetry.Register(mqttReconnects)
+}
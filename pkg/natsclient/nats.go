@@ -0,0 +1,232 @@
+package natsclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultMaxPending = 4000
+	defaultAckTimeout = 5 * time.Second
+	ackWaiters        = 8
+)
+
+// NatsClient publishes serialized samples to NATS subjects rendered from the
+// series labels, optionally through JetStream with publish acks.
+type NatsClient struct {
+	name            string
+	match           map[string]*dto.MetricFamily
+	serializer      serialize.Serializer
+	SubjectTemplate template.Template
+	Conn            *nats.Conn
+	js              nats.JetStreamContext
+	async           bool
+	acks            chan nats.PubAckFuture
+	ackWaiters      sync.WaitGroup
+	ackTimeout      time.Duration
+
+	// lock keeps Close from racing publishes.
+	lock    sync.RWMutex
+	closed  bool
+	drained chan struct{}
+}
+
+func NewNats(name string, cfg setting.NatsConfig) (*NatsClient, error) {
+	subjectTemplate, err := serialize.ParseTopicTemplate(cfg.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the subject template %v", err)
+	}
+	matchList, err := serialize.ParseMatchList(cfg.Match)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the match rules %v", err)
+	}
+	format := cfg.SerializationFormat
+	if format == "" {
+		format = os.Getenv("SERIALIZATION_FORMAT")
+	}
+	serializer, err := serialize.ParseSerializationFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create a metrics serializer %v", err)
+	}
+
+	drained := make(chan struct{})
+	opts := []nats.Option{
+		nats.Name("stream-metrics-route/" + name),
+		nats.ClosedHandler(func(*nats.Conn) {
+			close(drained)
+		}),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				defaultTelemetry.Logger.Warn("nats disconnected", "name", name, "err", err)
+			}
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			defaultTelemetry.Logger.Error("nats async error", "name", name, "err", err)
+		}),
+	}
+	if cfg.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+	if cfg.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	if cfg.TLSConfig != (config.TLSConfig{}) {
+		tlsConfig, err := config.NewTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the tls config %v", err)
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	conn, err := nats.Connect(cfg.Servers, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client := &NatsClient{
+		name:            name,
+		match:           matchList,
+		serializer:      serializer,
+		SubjectTemplate: *subjectTemplate,
+		Conn:            conn,
+		async:           cfg.Async,
+		ackTimeout:      time.Duration(cfg.AckTimeout),
+		drained:         drained,
+	}
+	if client.ackTimeout <= 0 {
+		client.ackTimeout = defaultAckTimeout
+	}
+	if cfg.JetStream {
+		if cfg.MaxPending <= 0 {
+			cfg.MaxPending = defaultMaxPending
+		}
+		client.js, err = conn.JetStream(nats.PublishAsyncMaxPending(cfg.MaxPending))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if cfg.Async {
+			// a fixed set of waiters counts the acks of async publishes
+			client.acks = make(chan nats.PubAckFuture, cfg.MaxPending)
+			for i := 0; i < ackWaiters; i++ {
+				client.ackWaiters.Add(1)
+				go client.waitAcks()
+			}
+		}
+	}
+	defaultTelemetry.Logger.Debug("create nats client", "name", name, "servers", cfg.Servers, "jetstream", cfg.JetStream)
+	return client, nil
+}
+
+func (n *NatsClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if n.closed {
+		return http.StatusServiceUnavailable, fmt.Errorf("nats client %s is closed", n.name)
+	}
+	metricsPerSubject, err := serialize.Serialize(n.name, n.SubjectTemplate, n.match, n.serializer, natsCounters, req)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("couldn't process write request %v", err)
+	}
+
+	var failed int
+	var lastErr error
+	for subject, metrics := range metricsPerSubject {
+		defaultTelemetry.Logger.Debug("write request", "name", n.name, "subject", subject)
+		for _, metric := range metrics {
+			if err := n.publish(subject, metric); err != nil {
+				failed++
+				lastErr = err
+			}
+		}
+	}
+	if failed > 0 {
+		objectsFailed.WithLabelValues(n.name).Add(float64(failed))
+		defaultTelemetry.Logger.Error("nats publish error", "name", n.name, "failed", failed, "err", lastErr)
+		return http.StatusInternalServerError, lastErr
+	}
+	return 0, nil
+}
+
+func (n *NatsClient) publish(subject string, data []byte) error {
+	switch {
+	case n.js == nil:
+		if err := n.Conn.Publish(subject, data); err != nil {
+			return err
+		}
+	case n.async:
+		// PublishAsync blocks once max_pending acks are outstanding
+		future, err := n.js.PublishAsync(subject, data)
+		if err != nil {
+			return err
+		}
+		n.acks <- future
+		return nil
+	default:
+		if _, err := n.js.Publish(subject, data, nats.AckWait(n.ackTimeout)); err != nil {
+			return err
+		}
+	}
+	objectsWritten.WithLabelValues(n.name).Inc()
+	return nil
+}
+
+func (n *NatsClient) waitAcks() {
+	defer n.ackWaiters.Done()
+	for future := range n.acks {
+		n.waitAck(future)
+	}
+}
+
+func (n *NatsClient) waitAck(future nats.PubAckFuture) {
+	select {
+	case <-future.Ok():
+		objectsWritten.WithLabelValues(n.name).Inc()
+	case err := <-future.Err():
+		objectsFailed.WithLabelValues(n.name).Inc()
+		defaultTelemetry.Logger.Error("nats publish ack error", "name", n.name, "err", err)
+	case <-time.After(n.ackTimeout):
+		objectsFailed.WithLabelValues(n.name).Inc()
+		defaultTelemetry.Logger.Error("nats publish ack timeout", "name", n.name)
+	}
+}
+
+// Close waits for the acks of pending async publishes, counting them, then
+// drains the connection, flushing what it buffers.
+func (n *NatsClient) Close() error {
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		return nil
+	}
+	n.closed = true
+	if n.acks != nil {
+		close(n.acks)
+	}
+	n.lock.Unlock()
+
+	n.ackWaiters.Wait()
+	if err := n.Conn.Drain(); err != nil {
+		n.Conn.Close()
+		return err
+	}
+	<-n.drained
+	return nil
+}
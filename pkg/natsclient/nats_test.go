@@ -0,0 +1,161 @@
+package natsclient_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"stream-metrics-route/pkg/natsclient"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type published struct {
+	subject string
+	payload []byte
+}
+
+// startServer accepts NATS connections, answers publishes with a reply
+// subject like JetStream with a publish ack and reports the published
+// messages.
+func startServer(t *testing.T) (string, <-chan published) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	out := make(chan published, 64)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, out)
+		}
+	}()
+	return "nats://" + l.Addr().String(), out
+}
+
+func serve(conn net.Conn, out chan<- published) {
+	defer conn.Close()
+	io.WriteString(conn, `INFO {"server_id":"stand-in","version":"2.9.0","proto":1,"headers":true,"max_payload":1048576}`+"\r\n")
+	r := bufio.NewReader(conn)
+	// subscriptions maps subject prefixes of wildcard reply inboxes to sids
+	subscriptions := make(map[string]string)
+	seq := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			subscriptions[strings.TrimSuffix(fields[1], "*")] = fields[len(fields)-1]
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			out <- published{subject: fields[1], payload: payload[:size]}
+			if len(fields) != 4 {
+				continue
+			}
+			for prefix, sid := range subscriptions {
+				if strings.HasPrefix(fields[2], prefix) {
+					seq++
+					ack := fmt.Sprintf(`{"stream":"metrics","seq":%d}`, seq)
+					fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[2], sid, len(ack), ack)
+				}
+			}
+		}
+	}
+}
+
+func series() []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api.v1"}},
+			Samples: []prompb.Sample{{Value: 0, Timestamp: 1697600000000}, {Value: 1, Timestamp: 1697600015000}},
+		},
+	}
+}
+
+func receive(t *testing.T, out <-chan published, n int) map[string][]map[string]interface{} {
+	messages := make(map[string][]map[string]interface{})
+	for i := 0; i < n; i++ {
+		select {
+		case p := <-out:
+			var m map[string]interface{}
+			if err := json.Unmarshal(p.payload, &m); err != nil {
+				t.Fatalf("invalid payload %q: %v", p.payload, err)
+			}
+			messages[p.subject] = append(messages[p.subject], m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d messages, got %d", n, i)
+		}
+	}
+	return messages
+}
+
+func TestNatsStore(t *testing.T) {
+	for _, cfg := range []setting.NatsConfig{
+		{},
+		{JetStream: true},
+		{JetStream: true, Async: true, MaxPending: 4},
+	} {
+		url, out := startServer(t)
+		cfg.Servers = url
+		cfg.Subject = `metrics.{{ replace "." "_" .job }}`
+		cfg.SerializationFormat = "json"
+		n, err := natsclient.NewNats("nats", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n.Store(context.Background(), series()); err != nil {
+			t.Fatalf("%+v: %v", cfg, err)
+		}
+		messages := receive(t, out, 3)
+		if len(messages["metrics.node"]) != 1 || len(messages["metrics.api_v1"]) != 2 {
+			t.Fatalf("%+v: unexpected subjects %v", cfg, messages)
+		}
+		m := messages["metrics.node"][0]
+		if m["name"] != "up" || m["value"] != "1" || m["timestamp"] != "2023-10-18T03:33:20Z" || m["labels"].(map[string]interface{})["job"] != "node" {
+			t.Fatalf("%+v: unexpected payload %v", cfg, m)
+		}
+		// Close waits for the pending acks and drains the connection.
+		if err := n.Close(); err != nil {
+			t.Fatalf("%+v: %v", cfg, err)
+		}
+		if !n.Conn.IsClosed() {
+			t.Fatalf("%+v: expected the connection to be closed", cfg)
+		}
+		if _, err := n.Store(context.Background(), series()); err == nil {
+			t.Fatalf("%+v: expected publishes to fail once closed", cfg)
+		}
+	}
+}
+
+func TestNatsConfigErrors(t *testing.T) {
+	if _, err := natsclient.NewNats("nats", setting.NatsConfig{Servers: "nats://127.0.0.1:4222", Subject: "{{ .job"}); err == nil {
+		t.Fatal("expected an error for an invalid subject template")
+	}
+}
@@ -0,0 +1,35 @@
+package natsclient
+
+import (
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_nats"
+
+var (
+	natsCounters   = serialize.NewCounters(metricNamespace)
+	objectsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_written_total",
+			Help:      "Count of all objects written to NATS",
+		}, []string{"route_name"})
+	objectsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_failed_total",
+			Help:      "Count of all objects write failures to NATS",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	natsCounters.Register(defaultTelemetry)
+	defaultTelemetry.Register(objectsFailed)
+	defaultTelemetry.Register(objectsWritten)
+}
@@ -0,0 +1,144 @@
+package receive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	defaultMaxCompressedBytes   int64 = 32 << 20
+	defaultMaxDecompressedBytes int64 = 128 << 20
+
+	defaultDecoder, _ = NewDecoder(nil)
+)
+
+// requestError is an invalid request body, answered with status.
+type requestError struct {
+	status int
+	reason string
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// Decoder reads and decompresses remote write request bodies within size
+// limits.
+type Decoder struct {
+	maxCompressed   int64
+	maxDecompressed int64
+	zstd            *zstd.Decoder
+}
+
+// NewDecoder returns a Decoder with the default limits when cfg is nil.
+func NewDecoder(cfg *setting.RequestLimitsConf) (*Decoder, error) {
+	d := &Decoder{maxCompressed: defaultMaxCompressedBytes, maxDecompressed: defaultMaxDecompressedBytes}
+	if cfg != nil {
+		if cfg.MaxCompressedBytes < 0 || cfg.MaxDecompressedBytes < 0 {
+			return nil, errors.New("request limits must not be negative")
+		}
+		if cfg.MaxCompressedBytes > 0 {
+			d.maxCompressed = cfg.MaxCompressedBytes
+		}
+		if cfg.MaxDecompressedBytes > 0 {
+			d.maxDecompressed = cfg.MaxDecompressedBytes
+		}
+	}
+	var err error
+	d.zstd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(d.maxDecompressed)))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Decode returns the uncompressed body of a remote write request, sent as
+// protobuf and compressed with snappy, the default, gzip or zstd.
+func (d *Decoder) Decode(req *http.Request) ([]byte, error) {
+	if d == nil {
+		d = defaultDecoder
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/x-protobuf" || (params["proto"] != "" && params["proto"] != "prometheus.WriteRequest") {
+			return nil, &requestError{http.StatusUnsupportedMediaType, "unsupported_content_type", fmt.Errorf("unsupported content type %q", contentType)}
+		}
+	}
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding != "" && encoding != "snappy" && encoding != "gzip" && encoding != "zstd" {
+		return nil, &requestError{http.StatusUnsupportedMediaType, "unsupported_encoding", fmt.Errorf("unsupported content encoding %q", encoding)}
+	}
+	if req.ContentLength > d.maxCompressed {
+		return nil, d.bodyTooLarge()
+	}
+	compressed, err := io.ReadAll(io.LimitReader(req.Body, d.maxCompressed+1))
+	if err != nil {
+		return nil, &requestError{http.StatusInternalServerError, "read_error", err}
+	}
+	if int64(len(compressed)) > d.maxCompressed {
+		return nil, d.bodyTooLarge()
+	}
+
+	switch encoding {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, invalidBody(err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(io.LimitReader(r, d.maxDecompressed+1))
+		if err != nil {
+			return nil, invalidBody(err)
+		}
+		if int64(len(b)) > d.maxDecompressed {
+			return nil, d.decompressedTooLarge()
+		}
+		return b, nil
+	case "zstd":
+		b, err := d.zstd.DecodeAll(compressed, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, d.decompressedTooLarge()
+		}
+		if err != nil {
+			return nil, invalidBody(err)
+		}
+		return b, nil
+	}
+	// The decoded length of snappy blocks is in their header, so oversized
+	// ones are refused before allocating.
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, invalidBody(err)
+	}
+	if int64(n) > d.maxDecompressed {
+		return nil, d.decompressedTooLarge()
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, invalidBody(err)
+	}
+	return b, nil
+}
+
+func (d *Decoder) bodyTooLarge() *requestError {
+	return &requestError{http.StatusRequestEntityTooLarge, "body_too_large", fmt.Errorf("request body larger than %d bytes", d.maxCompressed)}
+}
+
+func (d *Decoder) decompressedTooLarge() *requestError {
+	return &requestError{http.StatusRequestEntityTooLarge, "decompressed_too_large", fmt.Errorf("decompressed request body larger than %d bytes", d.maxDecompressed)}
+}
+
+func invalidBody(err error) *requestError {
+	return &requestError{http.StatusBadRequest, "invalid_body", err}
+}
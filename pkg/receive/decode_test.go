@@ -0,0 +1,84 @@
+package receive_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"stream-metrics-route/pkg/receive"
+	"stream-metrics-route/pkg/setting"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstded(b []byte) []byte {
+	w, _ := zstd.NewWriter(nil)
+	defer w.Close()
+	return w.EncodeAll(b, nil)
+}
+
+func TestDecoder(t *testing.T) {
+	d, err := receive.NewDecoder(&setting.RequestLimitsConf{MaxCompressedBytes: 1024, MaxDecompressedBytes: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := marshal(t, 50)
+	bomb := make([]byte, 1<<20)
+	random := make([]byte, 2000)
+	rand.Read(random)
+	r := receive.Receive{Decoder: d}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/api/v1/write", r.Handler())
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		status      int
+	}{
+		{"snappy", "application/x-protobuf", "snappy", snappy.Encode(nil, small), 0},
+		{"default encoding", "", "", snappy.Encode(nil, small), 0},
+		{"gzip", "application/x-protobuf", "gzip", gzipped(small), 0},
+		{"zstd", "application/x-protobuf", "zstd", zstded(small), 0},
+		{"compressed too large", "", "snappy", snappy.Encode(nil, random), http.StatusRequestEntityTooLarge},
+		{"snappy bomb", "", "snappy", snappy.Encode(nil, bomb), http.StatusRequestEntityTooLarge},
+		{"gzip bomb", "", "gzip", gzipped(bomb), http.StatusRequestEntityTooLarge},
+		{"zstd bomb", "", "zstd", zstded(bomb), http.StatusRequestEntityTooLarge},
+		{"invalid snappy", "", "snappy", []byte("not snappy"), http.StatusBadRequest},
+		{"invalid gzip", "", "gzip", []byte("not gzip"), http.StatusBadRequest},
+		{"unsupported encoding", "", "br", []byte("up"), http.StatusUnsupportedMediaType},
+		{"unsupported content type", "application/json", "", snappy.Encode(nil, small), http.StatusUnsupportedMediaType},
+		{"remote write 2.0", "application/x-protobuf;proto=io.prometheus.write.v2.Request", "", snappy.Encode(nil, small), http.StatusUnsupportedMediaType},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		req.Header.Set("Content-Encoding", tc.encoding)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+
+		if tc.status == 0 {
+			tc.status = http.StatusOK
+		}
+		if rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d %q", tc.name, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	if _, err := receive.NewDecoder(&setting.RequestLimitsConf{MaxCompressedBytes: -1}); err == nil {
+		t.Fatal("expected an error for a negative limit")
+	}
+}
@@ -1,15 +1,19 @@
 package receive
 
 import (
-	"io"
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
+	"stream-metrics-route/pkg/auth"
 	"stream-metrics-route/pkg/remote"
 	"stream-metrics-route/pkg/router"
+	"stream-metrics-route/pkg/tenant"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gogo/protobuf/proto"
-	"github.com/golang/snappy"
 	"github.com/prometheus/prometheus/prompb"
 )
 
@@ -19,8 +23,13 @@ type ReceiveRoute interface {
 }
 
 type Receive struct {
-	Upstream map[int]*remote.RemoteWriterUrl
-	uplen    int
+	Upstream  map[int]*remote.RemoteWriterUrl
+	Validator *Validator
+	Tenants   *tenant.Resolver
+	Auth      *auth.Authenticator
+	Decoder   *Decoder
+	Limits    *tenant.Limits
+	uplen     int
 }
 
 // var sendSamplesChan map[int]*[]prompb.TimeSeries
@@ -44,19 +53,20 @@ func (r *Receive) BuildRoute(rwu ...*remote.RemoteWriterUrl) {
 func (r *Receive) Handler() func(c *gin.Context) {
 	return func(c *gin.Context) {
 
-		compressed, err := io.ReadAll(c.Request.Body)
+		reqBuf, err := r.Decoder.Decode(c.Request)
 		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		reqBuf, err := snappy.Decode(nil, compressed)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
+			status, reason := http.StatusInternalServerError, "read_error"
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				status, reason = reqErr.status, reqErr.reason
+			}
+			streamReceiveRejectedRequests.WithLabelValues(c.FullPath(), reason).Inc()
+			defaultTelemetry.Logger.Debug("invalid request body", "reason", reason, "err", err)
+			c.String(status, err.Error())
 			return
 		}
 		// metrics
-		streamReceiveData.WithLabelValues(c.Request.RequestURI).Add(float64(len(reqBuf)))
+		streamReceiveData.WithLabelValues(c.FullPath()).Add(float64(len(reqBuf)))
 		var req prompb.WriteRequest
 		if err := proto.Unmarshal(reqBuf, &req); err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
@@ -64,13 +74,40 @@ func (r *Receive) Handler() func(c *gin.Context) {
 		}
 		//
 
-		streamReceiveSeriesData.WithLabelValues(c.Request.RequestURI).Add(float64(len(req.Timeseries)))
+		streamReceiveSeriesData.WithLabelValues(c.FullPath()).Add(float64(len(req.Timeseries)))
 		defaultTelemetry.Logger.Debug("Receive data", "size", len(reqBuf), "len", len(req.Timeseries))
 		if len(req.Timeseries) == 0 {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
+		req.Timeseries, err = r.Validator.Validate(c.FullPath(), req.Timeseries)
+		if err != nil {
+			defaultTelemetry.Logger.Debug("invalid samples", "err", err)
+			if r.Validator.Reject() {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if len(req.Timeseries) == 0 {
+			return
+		}
+		req.Timeseries = r.Auth.Label(c.Request.Context(), req.Timeseries)
+		// A tenant set by authentication can't be overridden by the client.
+		id := tenant.FromContext(c.Request.Context())
+		if id == "" {
+			id = r.Tenants.FromRequest(c.Request, c.Param("tenant"))
+		}
+		groups := r.Tenants.Split(id, req.Timeseries)
+		if ok, wait := r.Limits.Allow(groups); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+			c.String(http.StatusTooManyRequests, "tenant ingestion rate limit exceeded")
+			return
+		}
 		routers := router.GetRouters()
-		go routers.Store(c.Request.Context(), req.Timeseries)
+		for id, tss := range groups {
+			// The request context ends with the handler, so the tenant is
+			// carried by a fresh one.
+			go routers.Store(tenant.WithTenant(context.Background(), id), tss)
+		}
 	}
 }
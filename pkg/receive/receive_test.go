@@ -0,0 +1,92 @@
+package receive_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"stream-metrics-route/pkg/receive"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/telemetry"
+	"stream-metrics-route/pkg/tenant"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func writeRequest(t *testing.T, samples int) []byte {
+	return snappy.Encode(nil, marshal(t, samples))
+}
+
+func marshal(t *testing.T, samples int) []byte {
+	ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}}
+	for i := 0; i < samples; i++ {
+		ts.Samples = append(ts.Samples, prompb.Sample{Value: 1, Timestamp: int64(i)})
+	}
+	b, err := proto.Marshal(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{ts}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHandlerTenantLimits(t *testing.T) {
+	cfg := &setting.TenantConf{Header: "X-Scope-OrgID", Limits: setting.TenantLimitsConf{SamplesPerSecond: 1, SamplesBurst: 10}}
+	limits, err := tenant.NewLimits(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := receive.Receive{Tenants: tenant.New(cfg), Limits: limits}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/api/v1/write", r.Handler())
+	engine.POST("/api/v1/write/:tenant", r.Handler())
+
+	send := func(path, org string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(writeRequest(t, 10)))
+		req.Header.Set("X-Scope-OrgID", org)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := send("/api/v1/write", "team-a"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", rec.Code)
+	}
+	rec := send("/api/v1/write", "team-a")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Fatalf("expected 429 with Retry-After 10, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Tenants have their own buckets, and the path tenant wins.
+	if rec := send("/api/v1/write/team-b", "team-a"); rec.Code != http.StatusOK {
+		t.Fatalf("expected another tenant to pass, got %d", rec.Code)
+	}
+}
+
+func TestHandlerMetricsByRoute(t *testing.T) {
+	r := receive.Receive{Tenants: tenant.New(&setting.TenantConf{})}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/api/v1/write/:tenant", r.Handler())
+	for _, path := range []string{"/api/v1/write/team-a", "/api/v1/write/team-b?x=1"} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(writeRequest(t, 1)))
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	metrics := telemetry.NewTelemetry()
+	families, err := metrics.Metrics.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "src_service" && strings.Contains(l.GetValue(), "team-") {
+					t.Fatalf("%s labelled by %q instead of the route", f.GetName(), l.GetValue())
+				}
+			}
+		}
+	}
+}
@@ -73,13 +73,21 @@ var (
 			Help:      "",
 		}, []string{"remote", "code"},
 	)
+	// rejected requests
+	streamReceiveRejectedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "receive_rejected_requests_total",
+			Help:      "Count of received requests refused before decoding their series, by reason",
+		}, []string{"src_service", "reason"},
+	)
 	// drop samples
 	streamReceiveDropSamplesData = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: metricNamespace,
 			Name:      "receive_drop_samples_totol",
-			Help:      "",
-		}, []string{"src_service"},
+			Help:      "Count of received samples dropped by validation, by reason",
+		}, []string{"src_service", "reason"},
 	)
 )
 
@@ -93,6 +101,7 @@ func init() {
 	defaultTelemetry.Register(streamReceiveRemoteWriteSeriesData)
 	defaultTelemetry.Register(streamReceiveRemoteWriteSamplesData)
 	defaultTelemetry.Register(streamReceiveDropSamplesData)
+	defaultTelemetry.Register(streamReceiveRejectedRequests)
 }
 
 type response struct {
@@ -0,0 +1,188 @@
+package receive
+
+import (
+	"fmt"
+	"math"
+	"stream-metrics-route/pkg/setting"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Reasons samples are dropped for, as counted in
+// stream_receive_drop_samples_totol.
+const (
+	reasonTooOld             = "too_old"
+	reasonTooNew             = "too_new"
+	reasonTooManyLabels      = "too_many_labels"
+	reasonLabelNameTooLong   = "label_name_too_long"
+	reasonLabelValueTooLong  = "label_value_too_long"
+	reasonInvalidMetricName  = "invalid_metric_name"
+	reasonInvalidLabelName   = "invalid_label_name"
+	reasonDuplicateLabelName = "duplicate_label_name"
+	reasonStaleMarker        = "stale_marker"
+	reasonNaN                = "nan"
+)
+
+// Validator applies the validation config to received series.
+type Validator struct {
+	reject        bool
+	maxAge        time.Duration
+	maxFutureSkew time.Duration
+	maxLabels     int
+	maxNameLen    int
+	maxValueLen   int
+	validateNames bool
+	rejectDups    bool
+	dropStale     bool
+	dropNaN       bool
+}
+
+// NewValidator returns nil, validating nothing, when cfg is nil.
+func NewValidator(cfg *setting.ValidationConf) (*Validator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	v := &Validator{
+		maxAge:        time.Duration(cfg.MaxSampleAge),
+		maxFutureSkew: time.Duration(cfg.MaxFutureSkew),
+		maxLabels:     cfg.MaxLabelNamesPerSeries,
+		maxNameLen:    cfg.MaxLabelNameLength,
+		maxValueLen:   cfg.MaxLabelValueLength,
+		validateNames: cfg.ValidateNames,
+		rejectDups:    cfg.RejectDuplicateLabels,
+		dropStale:     cfg.DropStaleMarkers,
+		dropNaN:       cfg.DropNaN,
+	}
+	switch cfg.Action {
+	case "", "drop":
+	case "reject":
+		v.reject = true
+	default:
+		return nil, fmt.Errorf("unknown validation action %q", cfg.Action)
+	}
+	return v, nil
+}
+
+// Validate filters req in place, counting every dropped sample by reason
+// under src, the route pattern that received them, and returns the valid
+// series with the first violation found.
+// In reject mode callers are expected to refuse the request on error.
+func (v *Validator) Validate(src string, req []prompb.TimeSeries) ([]prompb.TimeSeries, error) {
+	if v == nil {
+		return req, nil
+	}
+	now := time.Now()
+	minTs, maxTs := int64(0), int64(0)
+	if v.maxAge > 0 {
+		minTs = now.Add(-v.maxAge).UnixMilli()
+	}
+	if v.maxFutureSkew > 0 {
+		maxTs = now.Add(v.maxFutureSkew).UnixMilli()
+	}
+
+	var firstErr error
+	drop := func(reason string, n int, err error) {
+		streamReceiveDropSamplesData.WithLabelValues(src, reason).Add(float64(n))
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	out := req[:0]
+	for _, ts := range req {
+		if reason, err := v.validateLabels(ts.Labels); err != nil {
+			drop(reason, len(ts.Samples)+len(ts.Histograms), err)
+			continue
+		}
+		samples := ts.Samples[:0]
+		for _, s := range ts.Samples {
+			switch {
+			case minTs != 0 && s.Timestamp < minTs:
+				drop(reasonTooOld, 1, fmt.Errorf("sample timestamp %d too old for series %s", s.Timestamp, seriesName(ts.Labels)))
+			case maxTs != 0 && s.Timestamp > maxTs:
+				drop(reasonTooNew, 1, fmt.Errorf("sample timestamp %d too far in the future for series %s", s.Timestamp, seriesName(ts.Labels)))
+			case v.dropStale && value.IsStaleNaN(s.Value):
+				// Stale markers and NaN values are expected, not an error
+				// worth rejecting for.
+				streamReceiveDropSamplesData.WithLabelValues(src, reasonStaleMarker).Inc()
+			case v.dropNaN && math.IsNaN(s.Value) && !value.IsStaleNaN(s.Value):
+				streamReceiveDropSamplesData.WithLabelValues(src, reasonNaN).Inc()
+			default:
+				samples = append(samples, s)
+			}
+		}
+		histograms := ts.Histograms[:0]
+		for _, h := range ts.Histograms {
+			switch {
+			case minTs != 0 && h.Timestamp < minTs:
+				drop(reasonTooOld, 1, fmt.Errorf("histogram timestamp %d too old for series %s", h.Timestamp, seriesName(ts.Labels)))
+			case maxTs != 0 && h.Timestamp > maxTs:
+				drop(reasonTooNew, 1, fmt.Errorf("histogram timestamp %d too far in the future for series %s", h.Timestamp, seriesName(ts.Labels)))
+			default:
+				histograms = append(histograms, h)
+			}
+		}
+		if len(samples) == 0 && len(histograms) == 0 {
+			continue
+		}
+		ts.Samples = samples
+		ts.Histograms = histograms
+		out = append(out, ts)
+	}
+	return out, firstErr
+}
+
+// Reject reports whether invalid requests should be refused as a whole.
+func (v *Validator) Reject() bool {
+	return v != nil && v.reject
+}
+
+func (v *Validator) validateLabels(labels []prompb.Label) (string, error) {
+	if v.maxLabels > 0 && len(labels) > v.maxLabels {
+		return reasonTooManyLabels, fmt.Errorf("series %s has %d labels, more than the limit of %d", seriesName(labels), len(labels), v.maxLabels)
+	}
+	var seen map[string]struct{}
+	if v.rejectDups {
+		seen = make(map[string]struct{}, len(labels))
+	}
+	hasName := false
+	for _, l := range labels {
+		if v.maxNameLen > 0 && len(l.Name) > v.maxNameLen {
+			return reasonLabelNameTooLong, fmt.Errorf("label name %.64q of series %s is longer than %d", l.Name, seriesName(labels), v.maxNameLen)
+		}
+		if v.maxValueLen > 0 && len(l.Value) > v.maxValueLen {
+			return reasonLabelValueTooLong, fmt.Errorf("value of label %q of series %s is longer than %d", l.Name, seriesName(labels), v.maxValueLen)
+		}
+		if v.validateNames {
+			if l.Name == model.MetricNameLabel {
+				hasName = true
+				if !model.IsValidMetricName(model.LabelValue(l.Value)) {
+					return reasonInvalidMetricName, fmt.Errorf("invalid metric name %.64q", l.Value)
+				}
+			} else if !model.LabelName(l.Name).IsValid() {
+				return reasonInvalidLabelName, fmt.Errorf("invalid label name %.64q of series %s", l.Name, seriesName(labels))
+			}
+		}
+		if seen != nil {
+			if _, ok := seen[l.Name]; ok {
+				return reasonDuplicateLabelName, fmt.Errorf("duplicate label name %q in series %s", l.Name, seriesName(labels))
+			}
+			seen[l.Name] = struct{}{}
+		}
+	}
+	if v.validateNames && !hasName {
+		return reasonInvalidMetricName, fmt.Errorf("series without metric name")
+	}
+	return "", nil
+}
+
+func seriesName(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == model.MetricNameLabel {
+			return fmt.Sprintf("%.64q", l.Value)
+		}
+	}
+	return `""`
+}
@@ -0,0 +1,130 @@
+package receive_test
+
+import (
+	"math"
+	"stream-metrics-route/pkg/receive"
+	"stream-metrics-route/pkg/setting"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func ts(samples []prompb.Sample, kv ...string) prompb.TimeSeries {
+	var lbs []prompb.Label
+	for i := 0; i < len(kv); i += 2 {
+		lbs = append(lbs, prompb.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return prompb.TimeSeries{Labels: lbs, Samples: samples}
+}
+
+func TestValidatorDrop(t *testing.T) {
+	v, err := receive.NewValidator(&setting.ValidationConf{
+		MaxSampleAge:           model.Duration(time.Hour),
+		MaxFutureSkew:          model.Duration(time.Minute),
+		MaxLabelNamesPerSeries: 3,
+		MaxLabelNameLength:     10,
+		MaxLabelValueLength:    10,
+		ValidateNames:          true,
+		RejectDuplicateLabels:  true,
+		DropStaleMarkers:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixMilli()
+	ok := []prompb.Sample{{Value: 1, Timestamp: now}}
+	req := []prompb.TimeSeries{
+		ts([]prompb.Sample{
+			{Value: 1, Timestamp: now},
+			{Value: 2, Timestamp: now - 2*time.Hour.Milliseconds()},
+			{Value: 3, Timestamp: now + time.Hour.Milliseconds()},
+			{Value: math.Float64frombits(value.StaleNaN), Timestamp: now},
+		}, "__name__", "up", "job", "node"),
+		ts(ok, "__name__", "up", "a", "1", "b", "2", "c", "3"),
+		ts(ok, "__name__", "up", "very_long_label", "1"),
+		ts(ok, "__name__", "up", "job", "very long value"),
+		ts(ok, "__name__", "1up"),
+		ts(ok, "__name__", "up", "a-b", "1"),
+		ts(ok, "__name__", "up", "job", "a", "job", "b"),
+		ts(ok, "job", "node"),
+		ts([]prompb.Sample{{Value: 1, Timestamp: now - 2*time.Hour.Milliseconds()}}, "__name__", "old"),
+		ts(ok, "__name__", "ok:metric", "job", "node"),
+	}
+	out, err := v.Validate("/api/v1/write", req)
+	if err == nil {
+		t.Fatal("expected the first violation to be reported")
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 valid series, got %d: %v", len(out), out)
+	}
+	if len(out[0].Samples) != 1 || out[0].Samples[0].Value != 1 {
+		t.Fatalf("expected only the in-window sample to remain, got %v", out[0].Samples)
+	}
+	if out[1].Labels[0].Value != "ok:metric" {
+		t.Fatalf("unexpected series %v", out[1].Labels)
+	}
+	if v.Reject() {
+		t.Fatal("drop is the default action")
+	}
+}
+
+func TestValidatorReject(t *testing.T) {
+	v, err := receive.NewValidator(&setting.ValidationConf{Action: "reject", ValidateNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Reject() {
+		t.Fatal("expected reject action")
+	}
+	// Stale markers aren't dropped unless configured.
+	stale := []prompb.Sample{{Value: math.Float64frombits(value.StaleNaN), Timestamp: 1}}
+	if out, err := v.Validate("/", []prompb.TimeSeries{ts(stale, "__name__", "up")}); err != nil || len(out) != 1 {
+		t.Fatalf("expected a valid series, got %v, %v", out, err)
+	}
+
+	var nilValidator *receive.Validator
+	req := []prompb.TimeSeries{ts(stale, "__name__", "1up")}
+	if out, err := nilValidator.Validate("/", req); err != nil || len(out) != 1 || nilValidator.Reject() {
+		t.Fatal("expected a nil validator to accept everything")
+	}
+
+	if _, err := receive.NewValidator(&setting.ValidationConf{Action: "ignore"}); err == nil {
+		t.Fatal("expected an error for an unknown action")
+	}
+}
+
+func TestValidatorHistogramsAndNaN(t *testing.T) {
+	v, err := receive.NewValidator(&setting.ValidationConf{
+		Action:        "reject",
+		MaxSampleAge:  model.Duration(time.Hour),
+		MaxFutureSkew: model.Duration(time.Minute),
+		DropNaN:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixMilli()
+	histograms := ts(nil, "__name__", "latency")
+	histograms.Histograms = []prompb.Histogram{{Timestamp: now}, {Timestamp: now + time.Hour.Milliseconds()}}
+	stale := math.Float64frombits(value.StaleNaN)
+	nan := ts([]prompb.Sample{{Value: math.NaN(), Timestamp: now}, {Value: stale, Timestamp: now}}, "__name__", "up")
+
+	out, err := v.Validate("/", []prompb.TimeSeries{nan})
+	if err != nil {
+		t.Fatalf("dropping NaN values isn't a violation, got %v", err)
+	}
+	if len(out) != 1 || len(out[0].Samples) != 1 || !value.IsStaleNaN(out[0].Samples[0].Value) {
+		t.Fatalf("expected only the stale marker to remain, got %v", out)
+	}
+
+	out, err = v.Validate("/", []prompb.TimeSeries{histograms})
+	if err == nil {
+		t.Fatal("expected the future histogram to be reported")
+	}
+	if len(out) != 1 || len(out[0].Histograms) != 1 || out[0].Histograms[0].Timestamp != now {
+		t.Fatalf("expected the histogram-only series to keep its in-window histogram, got %v", out)
+	}
+}
@@ -0,0 +1,179 @@
+package redisclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/setting"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	defaultPipelineSize = 500
+	defaultTimeout      = 10 * time.Second
+)
+
+// RedisClient appends serialized samples to Redis streams named from a label
+// template. Entries carry a single `data` field holding either the JSON
+// document of one sample or a marshalled prompb.TimeSeries.
+type RedisClient struct {
+	name           string
+	client         redis.UniversalClient
+	serializer     serialize.Serializer
+	StreamTemplate template.Template
+	maxLen         int64
+	approx         bool
+	pipelineSize   int
+}
+
+type entry struct {
+	stream string
+	data   []byte
+}
+
+func NewRedis(name string, cfg setting.RedisConfig) (*RedisClient, error) {
+	streamTemplate, err := serialize.ParseTopicTemplate(cfg.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the stream template %v", err)
+	}
+	var serializer serialize.Serializer
+	if cfg.SerializationFormat != "prompb" {
+		serializer, err = serialize.ParseSerializationFormat(cfg.SerializationFormat)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create a metrics serializer %v", err)
+		}
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		PoolSize:         cfg.PoolSize,
+		ClientName:       "stream-metrics-route",
+	}
+	if cfg.TLSConfig != (config.TLSConfig{}) {
+		opts.TLSConfig, err = config.NewTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the tls config %v", err)
+		}
+	}
+	var client redis.UniversalClient
+	if cfg.Cluster {
+		client = redis.NewClusterClient(opts.Cluster())
+	} else {
+		client = redis.NewUniversalClient(opts)
+	}
+
+	if cfg.PipelineSize <= 0 {
+		cfg.PipelineSize = defaultPipelineSize
+	}
+	defaultTelemetry.Logger.Debug("create redis client", "name", name, "addrs", cfg.Addrs, "master", cfg.MasterName, "cluster", cfg.Cluster)
+	return &RedisClient{
+		name:           name,
+		client:         client,
+		serializer:     serializer,
+		StreamTemplate: *streamTemplate,
+		maxLen:         cfg.MaxLen,
+		approx:         cfg.ApproxMaxLen,
+		pipelineSize:   cfg.PipelineSize,
+	}, nil
+}
+
+func (r *RedisClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	entries, err := r.serialize(req)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("couldn't process write request %v", err)
+	}
+
+	var failed int
+	var lastErr error
+	for start := 0; start < len(entries); start += r.pipelineSize {
+		end := start + r.pipelineSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		n, err := r.xadd(entries[start:end])
+		if err != nil {
+			failed += n
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		objectsFailed.WithLabelValues(r.name).Add(float64(failed))
+		defaultTelemetry.Logger.Error("redis xadd error", "name", r.name, "failed", failed, "err", lastErr)
+		return http.StatusInternalServerError, lastErr
+	}
+	return 0, nil
+}
+
+// xadd sends one pipeline and returns how many entries failed.
+func (r *RedisClient) xadd(entries []entry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, len(entries))
+	for _, e := range entries {
+		cmds = append(cmds, pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: e.stream,
+			MaxLen: r.maxLen,
+			Approx: r.approx,
+			Values: []interface{}{"data", e.data},
+		}))
+	}
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		objectsWritten.WithLabelValues(r.name).Add(float64(len(entries)))
+		return 0, nil
+	}
+	failed := 0
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed++
+		}
+	}
+	objectsWritten.WithLabelValues(r.name).Add(float64(len(entries) - failed))
+	return failed, err
+}
+
+func (r *RedisClient) serialize(req []prompb.TimeSeries) ([]entry, error) {
+	var entries []entry
+	if r.serializer != nil {
+		metricsPerStream, err := serialize.Serialize(r.name, r.StreamTemplate, nil, r.serializer, redisCounters, req)
+		if err != nil {
+			return nil, err
+		}
+		for stream, metrics := range metricsPerStream {
+			for _, m := range metrics {
+				entries = append(entries, entry{stream: stream, data: m})
+			}
+		}
+		return entries, nil
+	}
+
+	redisCounters.Batches.WithLabelValues(r.name).Inc()
+	for _, ts := range req {
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			labels[l.Name] = l.Value
+		}
+		data, err := ts.Marshal()
+		if err != nil {
+			redisCounters.SerializeFailed.WithLabelValues(r.name).Inc()
+			defaultTelemetry.Logger.Error("couldn't marshal timeseries", "err", err)
+			continue
+		}
+		redisCounters.Serialized.WithLabelValues(r.name).Inc()
+		entries = append(entries, entry{stream: serialize.Topic(r.StreamTemplate, labels), data: data})
+	}
+	return entries, nil
+}
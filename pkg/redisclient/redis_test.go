@@ -0,0 +1,159 @@
+package redisclient_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"stream-metrics-route/pkg/redisclient"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// redisStandIn speaks just enough of the RESP protocol to accept pipelined
+// XADD commands and records every command it receives.
+type redisStandIn struct {
+	listener net.Listener
+	lock     sync.Mutex
+	commands [][]string
+}
+
+func newRedisStandIn(t *testing.T) *redisStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisStandIn{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *redisStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.commands = append(s.commands, cmd)
+		s.lock.Unlock()
+
+		switch strings.ToLower(cmd[0]) {
+		case "hello":
+			io.WriteString(conn, "-ERR unknown command 'HELLO'\r\n")
+		case "ping":
+			io.WriteString(conn, "+PONG\r\n")
+		case "xadd":
+			io.WriteString(conn, "$3\r\n1-0\r\n")
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	cmd := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		cmd = append(cmd, string(buf[:size]))
+	}
+	return cmd, nil
+}
+
+func (s *redisStandIn) xadds() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var xadds [][]string
+	for _, c := range s.commands {
+		if strings.ToLower(c[0]) == "xadd" {
+			xadds = append(xadds, c)
+		}
+	}
+	return xadds
+}
+
+func TestRedisStore(t *testing.T) {
+	standIn := newRedisStandIn(t)
+	client, err := redisclient.NewRedis("redis-test", setting.RedisConfig{
+		Addrs:               []string{standIn.listener.Addr().String()},
+		Stream:              `metrics:{{ index . "job" }}`,
+		SerializationFormat: "prompb",
+		MaxLen:              1000,
+		ApproxMaxLen:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "mysql"}},
+			Samples: []prompb.Sample{{Value: 0, Timestamp: 1697600000000}},
+		},
+	}
+	if _, err := client.Store(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	xadds := standIn.xadds()
+	if len(xadds) != 2 {
+		t.Fatalf("expected 2 XADD commands, got %v", xadds)
+	}
+	streams := map[string]bool{}
+	for _, c := range xadds {
+		streams[c[1]] = true
+		if strings.Join(c[2:6], " ") != "maxlen ~ 1000 *" {
+			t.Errorf("unexpected XADD trimming arguments %v", c[2:6])
+		}
+		var ts prompb.TimeSeries
+		if err := ts.Unmarshal([]byte(c[7])); err != nil {
+			t.Errorf("couldn't unmarshal entry data %v", err)
+		}
+	}
+	if !streams["metrics:node"] || !streams["metrics:mysql"] {
+		t.Fatalf("unexpected streams %v", streams)
+	}
+}
@@ -0,0 +1,35 @@
+package redisclient
+
+import (
+	"stream-metrics-route/pkg/serialize"
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_redis"
+
+var (
+	redisCounters  = serialize.NewCounters(metricNamespace)
+	objectsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_written_total",
+			Help:      "Count of all entries added to Redis streams",
+		}, []string{"route_name"})
+	objectsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_failed_total",
+			Help:      "Count of all entry write failures to Redis streams",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	redisCounters.Register(defaultTelemetry)
+	defaultTelemetry.Register(objectsFailed)
+	defaultTelemetry.Register(objectsWritten)
+}
@@ -0,0 +1,269 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+)
+
+var (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultFailureThreshold    = 3
+
+	registryLock sync.Mutex
+	registry     = make(map[string]*RemoteCluster)
+)
+
+// UpstreamStatus is the health of one upstream of a cluster.
+type UpstreamStatus struct {
+	URL                 string `json:"url"`
+	Up                  bool   `json:"up"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+}
+
+// ClusterStatus is the health of the upstreams of a health checked route.
+type ClusterStatus struct {
+	Name      string           `json:"name"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+// Healthy reports whether the cluster has an upstream up.
+func (s ClusterStatus) Healthy() bool {
+	for _, u := range s.Upstreams {
+		if u.Up {
+			return true
+		}
+	}
+	return len(s.Upstreams) == 0
+}
+
+// HealthStatus returns the upstream health of every health checked route.
+func HealthStatus() []ClusterStatus {
+	registryLock.Lock()
+	clusters := make([]*RemoteCluster, 0, len(registry))
+	for _, r := range registry {
+		clusters = append(clusters, r)
+	}
+	registryLock.Unlock()
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	status := make([]ClusterStatus, 0, len(clusters))
+	for _, r := range clusters {
+		cs := ClusterStatus{Name: r.Name, Upstreams: make([]UpstreamStatus, 0, len(r.health))}
+		for _, h := range r.health {
+			cs.Upstreams = append(cs.Upstreams, h.status())
+		}
+		status = append(status, cs)
+	}
+	return status
+}
+
+// upstreamHealth tracks consecutive failures of an upstream.
+type upstreamHealth struct {
+	route     string
+	addr      string
+	threshold int
+	// retry is how long a down upstream waits before getting traffic
+	// again; zero when probes decide of its recovery.
+	retry time.Duration
+
+	lock      sync.Mutex
+	up        bool
+	failures  int
+	downSince time.Time
+	lastErr   error
+}
+
+func newUpstreamHealth(route, addr string, threshold int, retry time.Duration) *upstreamHealth {
+	remoteWriteUpstreamUp.WithLabelValues(route, addr).Set(1)
+	return &upstreamHealth{route: route, addr: addr, threshold: threshold, retry: retry, up: true}
+}
+
+func (h *upstreamHealth) report(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err == nil {
+		h.failures = 0
+		if !h.up {
+			h.up = true
+			remoteWriteUpstreamUp.WithLabelValues(h.route, h.addr).Set(1)
+			defaultTelemetry.Logger.Info("upstream up", "route", h.route, "addr", h.addr)
+		}
+		return
+	}
+	h.failures++
+	h.lastErr = err
+	if h.up && h.failures >= h.threshold {
+		h.up = false
+		h.downSince = time.Now()
+		remoteWriteUpstreamUp.WithLabelValues(h.route, h.addr).Set(0)
+		defaultTelemetry.Logger.Error("upstream down", "route", h.route, "addr", h.addr, "failures", h.failures, "err", err)
+	}
+}
+
+func (h *upstreamHealth) isUp(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.up && h.retry > 0 && now.Sub(h.downSince) >= h.retry {
+		// Half open: traffic is back, and a single failure takes the
+		// upstream down again.
+		h.up = true
+		h.failures = h.threshold - 1
+		remoteWriteUpstreamUp.WithLabelValues(h.route, h.addr).Set(1)
+	}
+	return h.up
+}
+
+func (h *upstreamHealth) status() UpstreamStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := UpstreamStatus{URL: h.addr, Up: h.up, ConsecutiveFailures: h.failures}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+// startHealthCheck tracks the health of the cluster upstreams, probing
+// them when a path is configured.
+func (r *RemoteCluster) startHealthCheck(cfg *setting.HealthCheckConf) error {
+	interval := time.Duration(cfg.Interval)
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	threshold := cfg.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	retry := interval
+	if cfg.Path != "" {
+		retry = 0
+	}
+
+	probes := make([]string, r.uplen)
+	r.health = make([]*upstreamHealth, r.uplen)
+	for i := 0; i < r.uplen; i++ {
+		addr := r.Writers[i].Addr
+		if cfg.Path != "" {
+			u, err := url.Parse(addr)
+			if err != nil {
+				return fmt.Errorf("invalid upstream url %s: %v", addr, err)
+			}
+			u.Path, u.RawQuery = cfg.Path, ""
+			probes[i] = u.String()
+		}
+		r.health[i] = newUpstreamHealth(r.Name, addr, threshold, retry)
+	}
+	registryLock.Lock()
+	registry[r.Name] = r
+	registryLock.Unlock()
+
+	if cfg.Path != "" {
+		r.stopProbes = make(chan struct{})
+		r.probing.Add(1)
+		go r.probe(probes, interval, timeout)
+	}
+	return nil
+}
+
+// Close stops the health checks of the cluster and forgets its status.
+func (r *RemoteCluster) Close() error {
+	if r.health == nil {
+		return nil
+	}
+	registryLock.Lock()
+	if registry[r.Name] == r {
+		delete(registry, r.Name)
+	}
+	registryLock.Unlock()
+	if r.stopProbes != nil {
+		r.closeOnce.Do(func() { close(r.stopProbes) })
+		r.probing.Wait()
+	}
+	return nil
+}
+
+// probe checks the upstreams with their own HTTP client, so probes carry
+// the same credentials as writes.
+func (r *RemoteCluster) probe(probes []string, interval, timeout time.Duration) {
+	defer r.probing.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopProbes:
+			return
+		}
+		var wg sync.WaitGroup
+		for i, probe := range probes {
+			wg.Add(1)
+			go func(h *upstreamHealth, client *http.Client, probe string) {
+				defer wg.Done()
+				h.report(probeOnce(client, probe, timeout))
+			}(r.health[i], r.Writers[i].Client, probe)
+		}
+		wg.Wait()
+	}
+}
+
+func probeOnce(client *http.Client, probe string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("health check returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// upstreamsUp snapshots which upstreams are up, or returns nil when the
+// cluster isn't health checked.
+func (r *RemoteCluster) upstreamsUp() []bool {
+	if r.health == nil {
+		return nil
+	}
+	now := time.Now()
+	up := make([]bool, len(r.health))
+	for i, h := range r.health {
+		up[i] = h.isUp(now)
+	}
+	return up
+}
+
+// report records the outcome of a write to an upstream. Only failures
+// worth retrying, network errors and 5xx, count against its health.
+func (r *RemoteCluster) report(index int, err error) {
+	if r.health == nil {
+		return
+	}
+	var recoverable RecoverableError
+	if err != nil && !errors.As(err, &recoverable) {
+		return
+	}
+	r.health[index].report(err)
+}
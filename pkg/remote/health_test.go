@@ -0,0 +1,159 @@
+package remote_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"stream-metrics-route/pkg/remote"
+	"stream-metrics-route/pkg/setting"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func healthRequest(n int) []prompb.TimeSeries {
+	tss := make([]prompb.TimeSeries, 0, n)
+	for i := 0; i < n; i++ {
+		tss = append(tss, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "id", Value: strconv.Itoa(i)}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	return tss
+}
+
+// waitStatus waits until the health of url in the route matches up.
+func waitStatus(t *testing.T, route, url string, up bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, c := range remote.HealthStatus() {
+			if c.Name != route {
+				continue
+			}
+			for _, u := range c.Upstreams {
+				if u.URL == url && u.Up == up {
+					return
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s of %s to be up=%v: %+v", url, route, up, remote.HealthStatus())
+}
+
+func TestRemoteClusterPassiveHealthCheck(t *testing.T) {
+	var healthyWrites, failingWrites atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyWrites.Add(1)
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingWrites.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	cfg := endpoints([]string{healthy.URL, failing.URL})
+	cfg.HealthCheck = &setting.HealthCheckConf{Interval: model.Duration(300 * time.Millisecond), FailureThreshold: 1}
+	c, err := remote.NewRemoteCluster("passive", setting.HashLabels{Algorithm: "consistent"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Store(context.Background(), healthRequest(100))
+	waitStatus(t, "passive", failing.URL, false)
+
+	// The series of the down upstream move to its healthy peer.
+	failingWrites.Store(0)
+	healthyWrites.Store(0)
+	c.Store(context.Background(), healthRequest(100))
+	deadline := time.Now().Add(5 * time.Second)
+	for healthyWrites.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if failingWrites.Load() != 0 || healthyWrites.Load() == 0 {
+		t.Fatalf("expected writes only to the healthy upstream, got %d and %d", healthyWrites.Load(), failingWrites.Load())
+	}
+
+	// Once the interval elapsed the down upstream gets traffic again.
+	time.Sleep(300 * time.Millisecond)
+	c.Store(context.Background(), healthRequest(100))
+	deadline = time.Now().Add(5 * time.Second)
+	for failingWrites.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if failingWrites.Load() == 0 {
+		t.Fatal("expected the down upstream to be retried")
+	}
+}
+
+func TestRemoteClusterActiveHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	url := srv.URL + "/api/v1/write"
+	cfg := endpoints([]string{url, other.URL + "/api/v1/write"})
+	cfg.HealthCheck = &setting.HealthCheckConf{
+		Path:             "/health",
+		Interval:         model.Duration(50 * time.Millisecond),
+		FailureThreshold: 2,
+	}
+	if _, err := remote.NewRemoteCluster("active", setting.HashLabels{}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, "active", url, false)
+	down.Store(false)
+	waitStatus(t, "active", url, true)
+}
+
+func TestRemoteClusterClose(t *testing.T) {
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/api/v1/write"
+	cfg := endpoints([]string{url})
+	cfg.HealthCheck = &setting.HealthCheckConf{
+		Path:             "/health",
+		Interval:         model.Duration(20 * time.Millisecond),
+		FailureThreshold: 1,
+	}
+	c, err := remote.NewRemoteCluster("closed", setting.HashLabels{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, "closed", url, false)
+	for _, cs := range remote.HealthStatus() {
+		if cs.Name == "closed" && cs.Healthy() {
+			t.Fatal("expected a cluster without upstream up to be unhealthy")
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cs := range remote.HealthStatus() {
+		if cs.Name == "closed" {
+			t.Fatal("expected a closed cluster to be forgotten")
+		}
+	}
+	n := probes.Load()
+	time.Sleep(100 * time.Millisecond)
+	if probes.Load() != n {
+		t.Fatal("expected the probes to stop once closed")
+	}
+}
@@ -2,9 +2,12 @@ package remote
 
 import (
 	"context"
-	"hash/fnv"
-	"sort"
+	"fmt"
+	"net/http"
 	"strconv"
+	"stream-metrics-route/pkg/common"
+	"stream-metrics-route/pkg/setting"
+	"sync"
 
 	"github.com/prometheus/prometheus/prompb"
 )
@@ -13,22 +16,124 @@ type RemoteCluster struct {
 	uplen        int
 	dimension    int
 	filterLabels []string
+	ring         *hashRing
+	replication  int
+	quorum       int
+	health       []*upstreamHealth
+	stopProbes   chan struct{}
+	probing      sync.WaitGroup
+	closeOnce    sync.Once
+	shardTarget  string
+	shardName    string
 	Writers      map[int]*RemoteWriterUrl
 	Name         string
 }
 
-func NewRemoteCluster(name string, dimension int, filterLabels []string, Urls []string) *RemoteCluster {
-	writers := make(map[int]*RemoteWriterUrl, len(Urls))
-	for k, v := range Urls {
-		writers[k] = NewRemoteWriterUrl(v)
+func NewRemoteCluster(name string, hashLabels setting.HashLabels, cfg setting.UpStreamsConf) (*RemoteCluster, error) {
+	endpoints := cfg.Endpoints()
+	writers := make(map[int]*RemoteWriterUrl, len(endpoints))
+	seen := make(map[string]bool, len(endpoints))
+	for k, e := range endpoints {
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for upstream %s", e.URL)
+		}
+		if seen[e.URL] {
+			return nil, fmt.Errorf("duplicate upstream %s", e.URL)
+		}
+		seen[e.URL] = true
+		w, err := NewRemoteWriterUrl(e.URL, e.HTTPClientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %v", e.URL, err)
+		}
+		w.TenantHeader = cfg.TenantHeader
+		writers[k] = w
 	}
-	return &RemoteCluster{
+	r := &RemoteCluster{
 		Name:         name,
-		uplen:        len(Urls),
-		dimension:    dimension,
-		filterLabels: filterLabels,
+		uplen:        len(endpoints),
+		dimension:    hashLabels.Mode,
+		filterLabels: hashLabels.Labels,
+		shardTarget:  hashLabels.ShardTarget,
+		shardName:    hashLabels.ShardName,
+		replication:  cfg.ReplicationFactor,
+		quorum:       cfg.WriteQuorum,
 		Writers:      writers,
 	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no upstream urls")
+	}
+	switch r.shardTarget {
+	case "":
+		r.shardTarget = shardTargetLabel
+	case shardTargetLabel, shardTargetHeader, shardTargetParam:
+	default:
+		return nil, fmt.Errorf("unknown shard target %q", r.shardTarget)
+	}
+	if r.shardName == "" {
+		r.shardName = defaultShardName
+	}
+	if r.replication <= 0 {
+		r.replication = 1
+	}
+	if r.replication > len(endpoints) {
+		return nil, fmt.Errorf("replication_factor %d exceeds the %d upstreams", r.replication, len(endpoints))
+	}
+	if r.quorum == 0 {
+		r.quorum = r.replication/2 + 1
+	}
+	if r.quorum < 0 || r.quorum > r.replication {
+		return nil, fmt.Errorf("write_quorum must be between 1 and the replication_factor %d", r.replication)
+	}
+	switch hashLabels.Algorithm {
+	case "", "modulo":
+	case "consistent":
+		r.ring = newHashRing(endpoints, hashLabels.VirtualNodes)
+		for k, share := range r.ring.ownership(len(endpoints)) {
+			remoteWriteClusterRingOwnership.WithLabelValues(name, endpoints[k].URL).Set(share)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q", hashLabels.Algorithm)
+	}
+	if cfg.HealthCheck != nil {
+		if err := r.startHealthCheck(cfg.HealthCheck); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// batchKey identifies the series sent in one request: those of an
+// upstream, and of a shard when it's sent as header or URL parameter.
+type batchKey struct {
+	index int
+	shard string
+}
+
+func (r *RemoteCluster) shard(key batchKey) *shard {
+	if key.shard == "" {
+		return nil
+	}
+	return &shard{target: r.shardTarget, name: r.shardName, value: key.shard}
+}
+
+// pick returns the indexes of the distinct upstreams replicating a series
+// hash, the primary first. Upstreams down in up are skipped, moving their
+// share to the next ones, unless none is up.
+func (r *RemoteCluster) pick(hash uint32, up []bool) []int {
+	if r.ring != nil {
+		return r.ring.replicas(hash, r.replication, up)
+	}
+	first := hashMod(r.uplen, hash)
+	replicas := make([]int, 0, r.replication)
+	for i := 0; i < r.uplen && len(replicas) < r.replication; i++ {
+		if node := (first + i) % r.uplen; up == nil || up[node] {
+			replicas = append(replicas, node)
+		}
+	}
+	for i := 0; len(replicas) == 0 && i < r.replication; i++ {
+		replicas = append(replicas, (first+i)%r.uplen)
+	}
+	return replicas
 }
 
 // Store stores the given time series data using the remote cluster.
@@ -36,21 +141,38 @@ func NewRemoteCluster(name string, dimension int, filterLabels []string, Urls []
 // ctx: the context for the request.
 // req: the time series data to be stored.
 // Returns the number of time series stored and any possible errors.
+//
+// Without replication the batches are sent in the background. Replicated
+// writes wait for every replica to judge the write quorum, each bounded by
+// the timeout of its endpoint client, 5s unless configured.
 func (r *RemoteCluster) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
-	var sendSamplesChan = make(map[int][]prompb.TimeSeries, r.uplen)
+	var sendSamplesChan = make(map[batchKey][]prompb.TimeSeries, r.uplen)
+	// sendSeriesIDs maps every batch to the position in req of the series
+	// sent in it, for counting the replicas written per series.
+	var sendSeriesIDs = make(map[batchKey][]int, r.uplen)
 	remoteWriteClusterTimeseries.WithLabelValues(r.Name).Add(float64(len(req)))
-	for _, ts := range req {
+	routed := 0
+	up := r.upstreamsUp()
+	for id, ts := range req {
 		if len(ts.Labels) == 0 {
 			remoteWriteClusterFalseTimeseries.WithLabelValues(r.Name).Inc()
 			continue
 		}
+		routed++
 		if r.uplen > 1 {
-			hash := sortLabelsHashKey(ts.Labels)
-			dime := hashMod(r.dimension, hash)
-			ts.Labels = append(ts.Labels, prompb.Label{
-				Name:  "stream_task_id",
-				Value: strconv.Itoa(dime),
-			})
+			hash := common.SortLabelsHashKey(ts.Labels)
+			var shard string
+			if r.dimension > 0 {
+				shard = strconv.Itoa(hashMod(r.dimension, hash))
+				if r.shardTarget == shardTargetLabel {
+					// Copy the labels, the series are shared by every route.
+					ts.Labels = append(ts.Labels[:len(ts.Labels):len(ts.Labels)], prompb.Label{
+						Name:  r.shardName,
+						Value: shard,
+					})
+					shard = ""
+				}
+			}
 			sendSeries := prompb.TimeSeries{
 				Labels:  ts.Labels,
 				Samples: ts.GetSamples(),
@@ -65,42 +187,77 @@ func (r *RemoteCluster) Store(ctx context.Context, req []prompb.TimeSeries) (int
 							}
 						}
 					}
-					return sortLabelsHashKey(tmpLabels)
+					return common.SortLabelsHashKey(tmpLabels)
 				}
 				return hash
 			}(r, hash)
-			tmpch := hashMod(r.uplen, hashnode)
-			defaultTelemetry.Logger.Debug("hash Result", "uplen", r.uplen, "hashnode", hashnode, "chanlenum", tmpch)
-			if _, ok := r.Writers[tmpch]; ok {
-				sendSamplesChan[tmpch] = append(sendSamplesChan[tmpch], sendSeries)
+			replicas := r.pick(hashnode, up)
+			defaultTelemetry.Logger.Debug("hash Result", "uplen", r.uplen, "hashnode", hashnode, "replicas", replicas)
+			for _, tmpch := range replicas {
+				if _, ok := r.Writers[tmpch]; ok {
+					key := batchKey{index: tmpch, shard: shard}
+					sendSamplesChan[key] = append(sendSamplesChan[key], sendSeries)
+					sendSeriesIDs[key] = append(sendSeriesIDs[key], id)
+				}
 			}
 		} else {
 			sendSeries := prompb.TimeSeries{
 				Labels:  ts.Labels,
 				Samples: ts.GetSamples(),
 			}
-			sendSamplesChan[0] = append(sendSamplesChan[0], sendSeries)
+			sendSamplesChan[batchKey{}] = append(sendSamplesChan[batchKey{}], sendSeries)
+			sendSeriesIDs[batchKey{}] = append(sendSeriesIDs[batchKey{}], id)
 		}
 
 	}
-	for index, tsdata := range sendSamplesChan {
-		defaultTelemetry.Logger.Debug("send samples", "index", index, "len", len(tsdata))
-		go r.Writers[index].Store(ctx, tsdata)
+	if r.replication <= 1 {
+		for key, tsdata := range sendSamplesChan {
+			defaultTelemetry.Logger.Debug("send samples", "index", key.index, "shard", key.shard, "len", len(tsdata))
+			remoteWriteClusterNodeTimeseries.WithLabelValues(r.Name, r.Writers[key.index].Addr).Add(float64(len(tsdata)))
+			go func(key batchKey, tsdata []prompb.TimeSeries) {
+				_, err := r.Writers[key.index].storeShard(ctx, tsdata, r.shard(key))
+				r.report(key.index, err)
+			}(key, tsdata)
+		}
+		return 0, nil
 	}
-	return 0, nil
-}
 
-func sortLabelsHashKey(labels []prompb.Label) uint32 {
-	newLabel := make([]string, 0, len(labels)*2)
-	for _, lal := range labels {
-		newLabel = append(newLabel, lal.Name)
-		newLabel = append(newLabel, lal.Value)
+	// Replicated writes wait for every upstream to judge the write quorum.
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	written := make(map[int]int, routed)
+	for key, tsdata := range sendSamplesChan {
+		defaultTelemetry.Logger.Debug("send samples", "index", key.index, "shard", key.shard, "len", len(tsdata))
+		w := r.Writers[key.index]
+		remoteWriteClusterNodeTimeseries.WithLabelValues(r.Name, w.Addr).Add(float64(len(tsdata)))
+		wg.Add(1)
+		go func(key batchKey, tsdata []prompb.TimeSeries) {
+			defer wg.Done()
+			_, err := w.storeShard(ctx, tsdata, r.shard(key))
+			r.report(key.index, err)
+			if err != nil {
+				remoteWriteClusterReplicaFailures.WithLabelValues(r.Name, w.Addr).Add(float64(len(tsdata)))
+				defaultTelemetry.Logger.Error("replica write error", "route", r.Name, "addr", w.Addr, "err", err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, id := range sendSeriesIDs[key] {
+				written[id]++
+			}
+		}(key, tsdata)
 	}
+	wg.Wait()
 
-	sort.Strings(newLabel)
-	h := fnv.New32a()
-	for _, v := range newLabel {
-		h.Write([]byte(v))
+	failed := routed
+	for _, n := range written {
+		if n >= r.quorum {
+			failed--
+		}
 	}
-	return h.Sum32()
+	if failed > 0 {
+		remoteWriteClusterQuorumFailures.WithLabelValues(r.Name).Add(float64(failed))
+		return http.StatusInternalServerError, fmt.Errorf("%d of %d series written to fewer than %d replicas", failed, routed, r.quorum)
+	}
+	return 0, nil
 }
@@ -0,0 +1,346 @@
+package remote_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"stream-metrics-route/pkg/remote"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// upstream records which series ids every remote write server received.
+type upstream struct {
+	lock   sync.Mutex
+	total  int
+	owners map[string]string
+}
+
+func newUpstream(t *testing.T, n int) (*upstream, []string) {
+	u := &upstream{owners: make(map[string]string)}
+	urls := make([]string, n)
+	for i := range urls {
+		var url string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			compressed, _ := io.ReadAll(r.Body)
+			data, err := snappy.Decode(nil, compressed)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var req prompb.WriteRequest
+			if err := proto.Unmarshal(data, &req); err != nil {
+				t.Error(err)
+				return
+			}
+			u.lock.Lock()
+			defer u.lock.Unlock()
+			for _, ts := range req.Timeseries {
+				for _, l := range ts.Labels {
+					if l.Name == "id" {
+						u.owners[l.Value] = url
+					}
+				}
+				u.total++
+			}
+		}))
+		t.Cleanup(srv.Close)
+		url = srv.URL
+		urls[i] = url
+	}
+	return u, urls
+}
+
+// store sends n series through a cluster and returns their owners.
+func (u *upstream) store(t *testing.T, hashLabels setting.HashLabels, cfg setting.UpStreamsConf, n int) map[string]string {
+	u.lock.Lock()
+	u.total = 0
+	u.owners = make(map[string]string)
+	u.lock.Unlock()
+
+	c, err := remote.NewRemoteCluster("test", hashLabels, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := make([]prompb.TimeSeries, 0, n)
+	for i := 0; i < n; i++ {
+		req = append(req, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "id", Value: strconv.Itoa(i)}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	if _, err := c.Store(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		u.lock.Lock()
+		total, owners := u.total, u.owners
+		u.lock.Unlock()
+		if total == n {
+			return owners
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d of %d series", total, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func endpoints(urls []string, weights ...int) setting.UpStreamsConf {
+	eps := make([]setting.UpstreamEndpoint, len(urls))
+	for i, url := range urls {
+		eps[i] = setting.UpstreamEndpoint{URL: url, Weight: 1}
+		if i < len(weights) {
+			eps[i].Weight = weights[i]
+		}
+	}
+	return setting.UpStreamsConf{UpStreamsType: setting.RemoteWriter, UpstreamEndpoints: eps}
+}
+
+func TestRemoteClusterConsistentHashing(t *testing.T) {
+	const n = 4000
+	u, urls := newUpstream(t, 5)
+	ring := setting.HashLabels{Algorithm: "consistent"}
+
+	before := u.store(t, ring, endpoints(urls[:4]), n)
+	after := u.store(t, ring, endpoints(urls), n)
+	moved := 0
+	for id, owner := range after {
+		if before[id] != owner {
+			if owner != urls[4] {
+				t.Fatalf("series %s moved between existing upstreams", id)
+			}
+			moved++
+		}
+	}
+	// Adding a fifth upstream should move about a fifth of the series.
+	if moved < n/10 || moved > n*3/10 {
+		t.Fatalf("expected about %d series to move, got %d", n/5, moved)
+	}
+
+	modulo := u.store(t, setting.HashLabels{}, endpoints(urls[:4]), n)
+	moduloAfter := u.store(t, setting.HashLabels{}, endpoints(urls), n)
+	movedModulo := 0
+	for id, owner := range moduloAfter {
+		if modulo[id] != owner {
+			movedModulo++
+		}
+	}
+	if movedModulo <= moved {
+		t.Fatalf("expected modulo hashing to move more series than the ring, got %d and %d", movedModulo, moved)
+	}
+
+	weighted := u.store(t, ring, endpoints(urls[:2], 3, 1), n)
+	counts := make(map[string]int)
+	for _, owner := range weighted {
+		counts[owner]++
+	}
+	if counts[urls[0]] < 2*counts[urls[1]] {
+		t.Fatalf("expected the weight 3 upstream to get about 3 times more series, got %v", counts)
+	}
+}
+
+func TestRemoteClusterConfig(t *testing.T) {
+	for _, tc := range []struct {
+		hashLabels setting.HashLabels
+		upstreams  setting.UpStreamsConf
+	}{
+		{setting.HashLabels{Algorithm: "rendezvous"}, endpoints([]string{"http://a"})},
+		{setting.HashLabels{}, endpoints([]string{"http://a", "http://a"})},
+		{setting.HashLabels{}, endpoints([]string{"http://a"}, -1)},
+	} {
+		if _, err := remote.NewRemoteCluster("test", tc.hashLabels, tc.upstreams); err == nil {
+			t.Fatalf("expected an error for %+v", tc)
+		}
+	}
+}
+
+func TestRemoteClusterReplication(t *testing.T) {
+	const n = 300
+	var lock sync.Mutex
+	copies := make(map[string]map[string]bool)
+	var urls []string
+	for i := 0; i < 3; i++ {
+		failing := i == 2
+		var url string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				http.Error(w, "down", http.StatusServiceUnavailable)
+				return
+			}
+			compressed, _ := io.ReadAll(r.Body)
+			data, _ := snappy.Decode(nil, compressed)
+			var req prompb.WriteRequest
+			if err := proto.Unmarshal(data, &req); err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, ts := range req.Timeseries {
+				id := ts.Labels[1].Value
+				if copies[id] == nil {
+					copies[id] = make(map[string]bool)
+				}
+				copies[id][url] = true
+			}
+		}))
+		t.Cleanup(srv.Close)
+		url = srv.URL
+		urls = append(urls, url)
+	}
+	req := func() []prompb.TimeSeries {
+		tss := make([]prompb.TimeSeries, 0, n)
+		for i := 0; i < n; i++ {
+			tss = append(tss, prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "id", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			})
+		}
+		return tss
+	}
+
+	for _, algorithm := range []string{"modulo", "consistent"} {
+		cfg := endpoints(urls)
+		cfg.ReplicationFactor = 2
+		c, err := remote.NewRemoteCluster("test", setting.HashLabels{Algorithm: algorithm}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// With one upstream down, series replicated on it miss the
+		// default quorum of 2.
+		if _, err := c.Store(context.Background(), req()); err == nil {
+			t.Fatalf("%s: expected a quorum failure", algorithm)
+		}
+		lock.Lock()
+		for id, owners := range copies {
+			if owners[urls[2]] {
+				t.Fatalf("%s: series %s recorded by the failing upstream", algorithm, id)
+			}
+		}
+		healthy := 0
+		for _, owners := range copies {
+			if len(owners) == 2 {
+				healthy++
+			}
+		}
+		if len(copies) != n || healthy == 0 || healthy == n {
+			t.Fatalf("%s: expected every series on a healthy upstream and some on both, got %d of %d, %d on both", algorithm, len(copies), n, healthy)
+		}
+		copies = make(map[string]map[string]bool)
+		lock.Unlock()
+
+		cfg.WriteQuorum = 1
+		c, err = remote.NewRemoteCluster("test", setting.HashLabels{Algorithm: algorithm}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Store(context.Background(), req()); err != nil {
+			t.Fatalf("%s: expected a quorum of 1 to tolerate one upstream down: %v", algorithm, err)
+		}
+	}
+
+	for _, cfg := range []setting.UpStreamsConf{
+		{UpstreamUrls: urls, ReplicationFactor: 4},
+		{UpstreamUrls: urls, ReplicationFactor: 2, WriteQuorum: 3},
+	} {
+		if _, err := remote.NewRemoteCluster("test", setting.HashLabels{}, cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestRemoteClusterShard(t *testing.T) {
+	type received struct {
+		header, param string
+		labels        []string
+	}
+	var lock sync.Mutex
+	var got []received
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := io.ReadAll(r.Body)
+		data, _ := snappy.Decode(nil, compressed)
+		var req prompb.WriteRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			t.Error(err)
+			return
+		}
+		rec := received{header: r.Header.Get("X-Shard"), param: r.URL.Query().Get("shard")}
+		for _, ts := range req.Timeseries {
+			for _, l := range ts.Labels {
+				if l.Name != "__name__" && l.Name != "id" {
+					rec.labels = append(rec.labels, l.Name+"="+l.Value)
+				}
+			}
+		}
+		lock.Lock()
+		got = append(got, rec)
+		lock.Unlock()
+	})
+	a := httptest.NewServer(handler)
+	defer a.Close()
+	b := httptest.NewServer(handler)
+	defer b.Close()
+	cfg := setting.UpStreamsConf{UpstreamUrls: []string{a.URL, b.URL}, ReplicationFactor: 2, WriteQuorum: 2}
+
+	store := func(hashLabels setting.HashLabels) []received {
+		lock.Lock()
+		got = nil
+		lock.Unlock()
+		c, err := remote.NewRemoteCluster("test", hashLabels, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Store(context.Background(), healthRequest(200)); err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		return got
+	}
+
+	for _, rec := range store(setting.HashLabels{}) {
+		if len(rec.labels) != 0 || rec.header != "" || rec.param != "" {
+			t.Fatalf("expected no shard without mode, got %+v", rec)
+		}
+	}
+	for _, rec := range store(setting.HashLabels{Mode: 3, ShardName: "task"}) {
+		for _, l := range rec.labels {
+			if l != "task=0" && l != "task=1" && l != "task=2" {
+				t.Fatalf("unexpected shard label %s", l)
+			}
+		}
+		if len(rec.labels) == 0 {
+			t.Fatal("expected shard labels")
+		}
+	}
+	// Batches are split per shard when it's sent with the request.
+	shards := make(map[string]bool)
+	for _, rec := range store(setting.HashLabels{Mode: 3, ShardTarget: "header", ShardName: "X-Shard"}) {
+		if len(rec.labels) != 0 || rec.header == "" {
+			t.Fatalf("expected the shard as header only, got %+v", rec)
+		}
+		shards[rec.header] = true
+	}
+	if len(shards) != 3 {
+		t.Fatalf("expected 3 shards, got %v", shards)
+	}
+	for _, rec := range store(setting.HashLabels{Mode: 3, ShardTarget: "param", ShardName: "shard"}) {
+		if len(rec.labels) != 0 || rec.param == "" {
+			t.Fatalf("expected the shard as URL parameter only, got %+v", rec)
+		}
+	}
+
+	if _, err := remote.NewRemoteCluster("test", setting.HashLabels{Mode: 3, ShardTarget: "body"}, cfg); err == nil {
+		t.Fatal("expected an error for an unknown shard target")
+	}
+}
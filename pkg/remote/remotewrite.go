@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -17,6 +19,8 @@ import (
 
 type RemoteWriterUrl struct {
 	Addr string
+	// TenantHeader, when set, carries the tenant of the written series.
+	TenantHeader string
 	//Header http.Handler
 	Client *http.Client
 	//Body    []byte
@@ -24,34 +28,78 @@ type RemoteWriterUrl struct {
 }
 
 const defaultBackoff = 0
+const defaultTimeout = 5 * time.Second
 const maxErrMsgLen = 1024
 
+// Where the shard of a batch is sent.
+const (
+	shardTargetLabel  = "label"
+	shardTargetHeader = "header"
+	shardTargetParam  = "param"
+)
+
+var defaultShardName = "stream_task_id"
+
+// shard is sent with a request as header or URL parameter.
+type shard struct {
+	target string
+	name   string
+	value  string
+}
+
 type RecoverableError struct {
 	error
 	retryAfter model.Duration
 }
 
-func NewRemoteWriterUrl(addr string) *RemoteWriterUrl {
-	httpClient, err := config.NewClientFromConfig(config.DefaultHTTPClientConfig, addr)
-	if err != nil {
-		defaultTelemetry.Logger.Error("http client err", err)
-		return nil
+// NewRemoteWriterUrl builds the writer of addr with httpConfig, the default
+// client when nil.
+func NewRemoteWriterUrl(addr string, httpConfig *setting.HTTPClientConf) (*RemoteWriterUrl, error) {
+	if err := httpConfig.Validate(); err != nil {
+		return nil, err
 	}
-
-	rt, err := config.NewRoundTripperFromConfig(config.DefaultHTTPClientConfig, addr)
+	httpClient, err := config.NewClientFromConfig(httpConfig.ClientConfig(), addr)
 	if err != nil {
-		defaultTelemetry.Logger.Error("http client err", err)
-		return nil
+		return nil, err
+	}
+	timeout := defaultTimeout
+	if httpConfig != nil {
+		if len(httpConfig.Headers) > 0 {
+			httpClient.Transport = &headersRoundTripper{headers: httpConfig.Headers, next: httpClient.Transport}
+		}
+		if httpConfig.Timeout > 0 {
+			timeout = time.Duration(httpConfig.Timeout)
+		}
 	}
-	httpClient.Transport = rt
 	return &RemoteWriterUrl{
 		Addr:    addr,
 		Client:  httpClient,
-		timeout: 5 * time.Second,
+		timeout: timeout,
+	}, nil
+}
+
+// headersRoundTripper sets static headers on every request, unless the
+// request already has them, like a tenant header.
+type headersRoundTripper struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range rt.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
 	}
+	return rt.next.RoundTrip(req)
 }
 
 func (r *RemoteWriterUrl) Store(ctx context.Context, tsdata []prompb.TimeSeries) (int, error) {
+	return r.storeShard(ctx, tsdata, nil)
+}
+
+func (r *RemoteWriterUrl) storeShard(ctx context.Context, tsdata []prompb.TimeSeries, s *shard) (int, error) {
 	pBuf := proto.NewBuffer(nil)
 	remoteWriteTimeseries.WithLabelValues(r.Addr).Add(float64(len(tsdata)))
 	req, err := buildWriteRequest(tsdata, nil, pBuf, nil)
@@ -65,7 +113,7 @@ func (r *RemoteWriterUrl) Store(ctx context.Context, tsdata []prompb.TimeSeries)
 		defaultTelemetry.Logger.Error("buildWriteRequest nil")
 		return 404, err
 	}
-	return r.store(ctx, req)
+	return r.store(ctx, req, s)
 
 }
 
@@ -75,16 +123,29 @@ func (r *RemoteWriterUrl) Store(ctx context.Context, tsdata []prompb.TimeSeries)
 // req: []byte containing the request payload.
 // int: HTTP status code of the response.
 // error: any errors encountered during the request.
-func (r *RemoteWriterUrl) store(c context.Context, req []byte) (int, error) {
+func (r *RemoteWriterUrl) store(c context.Context, req []byte, s *shard) (int, error) {
 	httpReq, err := http.NewRequest("POST", r.Addr, bytes.NewReader(req))
 	if err != nil {
 		return 404, err
 	}
+	if s != nil {
+		switch s.target {
+		case shardTargetHeader:
+			httpReq.Header.Set(s.name, s.value)
+		case shardTargetParam:
+			q := httpReq.URL.Query()
+			q.Set(s.name, s.value)
+			httpReq.URL.RawQuery = q.Encode()
+		}
+	}
 
 	httpReq.Header.Set("Content-Encoding", "snappy")
 	httpReq.Header.Set("Content-Type", "application/x-protobuf")
 	httpReq.Header.Set("User-Agent", "stream-metrics-route")
 	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if id := tenant.FromContext(c); id != "" && r.TenantHeader != "" {
+		httpReq.Header.Set(r.TenantHeader, id)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 	httpResp, err := r.Client.Do(httpReq.WithContext(ctx))
@@ -0,0 +1,106 @@
+package remote_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"stream-metrics-route/pkg/remote"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestRemoteWriterHTTPClientConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/api/v1/write", http.StatusTemporaryRedirect)
+			return
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+		if r.Header.Get("X-Scope-OrgID") != "tenant-a" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			http.Error(w, "bad encoding", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	noRedirects := false
+	httpConfig := &setting.HTTPClientConf{
+		Authorization:   &config.Authorization{Type: "Bearer", Credentials: "secret"},
+		Headers:         map[string]string{"X-Scope-OrgID": "tenant-a"},
+		Timeout:         model.Duration(100 * time.Millisecond),
+		FollowRedirects: &noRedirects,
+	}
+	tss := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}
+	for path, ok := range map[string]bool{"/api/v1/write": true, "/slow": false, "/redirect": false} {
+		w, err := remote.NewRemoteWriterUrl(srv.URL+path, httpConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Store(context.Background(), tss); (err == nil) != ok {
+			t.Fatalf("%s: unexpected result %v", path, err)
+		}
+	}
+	w, err := remote.NewRemoteWriterUrl(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := w.Store(context.Background(), tss); err == nil || code != http.StatusUnauthorized {
+		t.Fatalf("expected the default client to be unauthorized, got %d %v", code, err)
+	}
+
+	for _, invalid := range []*setting.HTTPClientConf{
+		{Headers: map[string]string{"content-type": "text/plain"}},
+		{BearerToken: "a", BasicAuth: &config.BasicAuth{Username: "b"}},
+	} {
+		if _, err := remote.NewRemoteWriterUrl(srv.URL, invalid); err == nil {
+			t.Fatalf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestRemoteWriterTenantHeader(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Scope-OrgID")
+	}))
+	defer srv.Close()
+
+	// The tenant of the series wins over the static header.
+	w, err := remote.NewRemoteWriterUrl(srv.URL, &setting.HTTPClientConf{Headers: map[string]string{"X-Scope-OrgID": "fallback"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.TenantHeader = "X-Scope-OrgID"
+	tss := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}
+	for _, tc := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{tenant.WithTenant(context.Background(), "team-a"), "team-a"},
+		{context.Background(), "fallback"},
+	} {
+		if _, err := w.Store(tc.ctx, tss); err != nil {
+			t.Fatal(err)
+		}
+		if h := <-got; h != tc.want {
+			t.Fatalf("expected tenant header %q, got %q", tc.want, h)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"stream-metrics-route/pkg/setting"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var defaultVirtualNodes = 160
+
+// hashRing maps series hashes to upstreams with consistent hashing. Every
+// upstream owns weight*vnodes points named after its URL, so its points
+// stay in place whatever the other members of the ring are.
+type hashRing struct {
+	hashes []uint64
+	nodes  []int
+}
+
+func newHashRing(endpoints []setting.UpstreamEndpoint, vnodes int) *hashRing {
+	if vnodes <= 0 {
+		vnodes = defaultVirtualNodes
+	}
+	h := &hashRing{}
+	for node, e := range endpoints {
+		for i := 0; i < e.Weight*vnodes; i++ {
+			h.hashes = append(h.hashes, xxhash.Sum64String(e.URL+"#"+strconv.Itoa(i)))
+			h.nodes = append(h.nodes, node)
+		}
+	}
+	sort.Sort(h)
+	return h
+}
+
+func (h *hashRing) Len() int           { return len(h.hashes) }
+func (h *hashRing) Less(i, j int) bool { return h.hashes[i] < h.hashes[j] }
+func (h *hashRing) Swap(i, j int) {
+	h.hashes[i], h.hashes[j] = h.hashes[j], h.hashes[i]
+	h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i]
+}
+
+// replicas returns the n distinct upstreams owning key: the owners of the
+// points met walking clockwise from it, the first one being the primary.
+// Upstreams down in up are skipped unless none is up.
+func (h *hashRing) replicas(key uint32, n int, up []bool) []int {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], key)
+	k := xxhash.Sum64(b[:])
+	i := sort.Search(len(h.hashes), func(i int) bool { return h.hashes[i] >= k })
+	replicas := h.walk(i, n, up)
+	if len(replicas) == 0 && up != nil {
+		replicas = h.walk(i, n, nil)
+	}
+	return replicas
+}
+
+func (h *hashRing) walk(i, n int, up []bool) []int {
+	replicas := make([]int, 0, n)
+	for j := 0; j < len(h.hashes) && len(replicas) < n; j++ {
+		node := h.nodes[(i+j)%len(h.hashes)]
+		if (up == nil || up[node]) && !containsInt(replicas, node) {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ownership returns the share of the hash space owned by every upstream.
+func (h *hashRing) ownership(n int) []float64 {
+	shares := make([]float64, n)
+	switch len(h.hashes) {
+	case 0:
+		return shares
+	case 1:
+		shares[h.nodes[0]] = 1
+		return shares
+	}
+	// The first point also owns the wrapping arc after the last one.
+	prev := h.hashes[len(h.hashes)-1]
+	for i, hash := range h.hashes {
+		shares[h.nodes[i]] += float64(hash-prev) / (1 << 64)
+		prev = hash
+	}
+	return shares
+}
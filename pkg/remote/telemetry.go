@@ -35,6 +35,36 @@ var (
 			Name:      "cluster_timeseries_false_total",
 			Help:      "Count of handle timeseries false total",
 		}, []string{"route_name"})
+	remoteWriteClusterNodeTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "cluster_node_timeseries_total",
+			Help:      "Count of timeseries routed to each upstream of a cluster",
+		}, []string{"route_name", "url"})
+	remoteWriteClusterRingOwnership = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "cluster_ring_ownership_ratio",
+			Help:      "Share of the consistent hash ring owned by each upstream of a cluster",
+		}, []string{"route_name", "url"})
+	remoteWriteClusterReplicaFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "cluster_replica_failures_total",
+			Help:      "Count of timeseries that failed to be written to a replica upstream",
+		}, []string{"route_name", "url"})
+	remoteWriteClusterQuorumFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "cluster_quorum_failures_total",
+			Help:      "Count of timeseries written to fewer replicas than the write quorum",
+		}, []string{"route_name"})
+	remoteWriteUpstreamUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "upstream_up",
+			Help:      "Whether a health checked upstream of a cluster is up",
+		}, []string{"route_name", "url"})
 )
 
 func init() {
@@ -43,4 +73,9 @@ func init() {
 	defaultTelemetry.Register(remoteWriteClusterFalseTimeseries)
 	defaultTelemetry.Register(remoteWriteTimeseries)
 	defaultTelemetry.Register(remoteWriteFalseTimeseries)
+	defaultTelemetry.Register(remoteWriteClusterNodeTimeseries)
+	defaultTelemetry.Register(remoteWriteClusterRingOwnership)
+	defaultTelemetry.Register(remoteWriteClusterReplicaFailures)
+	defaultTelemetry.Register(remoteWriteClusterQuorumFailures)
+	defaultTelemetry.Register(remoteWriteUpstreamUp)
 }
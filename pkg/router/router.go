@@ -2,10 +2,26 @@ package router
 
 import (
 	"context"
+	"io"
+	"stream-metrics-route/pkg/aggregator"
+	"stream-metrics-route/pkg/cardinality"
+	"stream-metrics-route/pkg/clickhouseclient"
+	"stream-metrics-route/pkg/downsample"
+	"stream-metrics-route/pkg/esclient"
+	"stream-metrics-route/pkg/filestore"
+	"stream-metrics-route/pkg/graphiteclient"
+	"stream-metrics-route/pkg/hadedup"
+	"stream-metrics-route/pkg/influxclient"
 	"stream-metrics-route/pkg/kafkaclient"
+	"stream-metrics-route/pkg/mqttclient"
+	"stream-metrics-route/pkg/natsclient"
+	"stream-metrics-route/pkg/redisclient"
 	"stream-metrics-route/pkg/remote"
+	"stream-metrics-route/pkg/s3client"
 	"stream-metrics-route/pkg/setting"
 	"stream-metrics-route/pkg/telemetry"
+	"stream-metrics-route/pkg/tenant"
+	"stream-metrics-route/pkg/webhookclient"
 	"strings"
 	"sync"
 
@@ -26,6 +42,8 @@ func init() {
 
 type Routers struct {
 	Routers map[string]*Router
+	Deduper *hadedup.Deduper
+	Limiter *cardinality.Limiter
 	lock    sync.RWMutex
 }
 
@@ -37,11 +55,18 @@ func GetRouters() *Routers {
 	return DefaultRouters
 }
 
-func BuildRouters(cfg *setting.Config) {
+// BuildRouters builds the routes of cfg. A route whose upstream can't be
+// created is skipped, while an invalid setting shared by every route fails.
+func BuildRouters(cfg *setting.Config) error {
 	DefaultRouters.lock.Lock()
 	defer DefaultRouters.lock.Unlock()
 	NewRouters()
 	var err error
+	DefaultRouters.Deduper, err = hadedup.New(cfg.HADedup)
+	if err != nil {
+		return err
+	}
+	DefaultRouters.Limiter = cardinality.NewGlobal(cfg.CardinalityLimits)
 	for _, r := range cfg.RouterRule {
 		var route RemoteStore
 		switch r.UpStreams.UpStreamsType {
@@ -56,71 +81,269 @@ func BuildRouters(cfg *setting.Config) {
 				continue
 			}
 			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.KafkaConfig.KafkaBrokerList, r.UpStreams.KafkaConfig.KafkaTopic).Set(1)
+		case setting.File:
+			defaultTelemetry.Logger.Debug("file store", "path", r.UpStreams.FileConfig.Path, "format", r.UpStreams.FileConfig.Format)
+			route, err = filestore.NewFileStore(
+				r.RouterName,
+				r.UpStreams.FileConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("file store error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.FileConfig.Path, r.UpStreams.FileConfig.Format).Set(1)
+		case setting.S3:
+			defaultTelemetry.Logger.Debug("s3 connect", "endpoint", r.UpStreams.S3Config.Endpoint, "bucket", r.UpStreams.S3Config.Bucket)
+			route, err = s3client.NewS3Client(
+				r.RouterName,
+				r.UpStreams.S3Config,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("s3 connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.S3Config.Endpoint, r.UpStreams.S3Config.Bucket).Set(1)
+		case setting.Nats:
+			defaultTelemetry.Logger.Debug("nats connect", "servers", r.UpStreams.NatsConfig.Servers, "subject", r.UpStreams.NatsConfig.Subject)
+			route, err = natsclient.NewNats(
+				r.RouterName,
+				r.UpStreams.NatsConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("nats connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.NatsConfig.Servers, r.UpStreams.NatsConfig.Subject).Set(1)
+		case setting.RedisStream:
+			defaultTelemetry.Logger.Debug("redis connect", "addrs", r.UpStreams.RedisConfig.Addrs, "stream", r.UpStreams.RedisConfig.Stream)
+			route, err = redisclient.NewRedis(
+				r.RouterName,
+				r.UpStreams.RedisConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("redis connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(r.UpStreams.RedisConfig.Addrs, ","), r.UpStreams.RedisConfig.Stream).Set(1)
+		case setting.Mqtt:
+			defaultTelemetry.Logger.Debug("mqtt connect", "brokers", r.UpStreams.MqttConfig.Brokers, "topic", r.UpStreams.MqttConfig.Topic)
+			route, err = mqttclient.NewMqtt(
+				r.RouterName,
+				r.UpStreams.MqttConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("mqtt connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(r.UpStreams.MqttConfig.Brokers, ","), r.UpStreams.MqttConfig.Topic).Set(1)
+		case setting.Influx:
+			defaultTelemetry.Logger.Debug("influx connect", "url", r.UpStreams.InfluxConfig.URL, "api_version", r.UpStreams.InfluxConfig.APIVersion)
+			route, err = influxclient.NewInflux(
+				r.RouterName,
+				r.UpStreams.InfluxConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("influx connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.InfluxConfig.URL, r.UpStreams.InfluxConfig.Database+r.UpStreams.InfluxConfig.Bucket).Set(1)
+		case setting.ClickHouse:
+			defaultTelemetry.Logger.Debug("clickhouse connect", "url", r.UpStreams.ClickHouseConfig.URL, "table", r.UpStreams.ClickHouseConfig.Table)
+			route, err = clickhouseclient.NewClickHouse(
+				r.RouterName,
+				r.UpStreams.ClickHouseConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("clickhouse connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.ClickHouseConfig.URL, r.UpStreams.ClickHouseConfig.Table).Set(1)
+		case setting.Elasticsearch:
+			defaultTelemetry.Logger.Debug("elasticsearch connect", "urls", r.UpStreams.ElasticsearchConfig.URLs, "index", r.UpStreams.ElasticsearchConfig.Index)
+			route, err = esclient.NewElasticsearch(
+				r.RouterName,
+				r.UpStreams.ElasticsearchConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("elasticsearch connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(r.UpStreams.ElasticsearchConfig.URLs, ","), r.UpStreams.ElasticsearchConfig.Index).Set(1)
+		case setting.Webhook:
+			defaultTelemetry.Logger.Debug("webhook connect", "url", r.UpStreams.WebhookConfig.URL, "method", r.UpStreams.WebhookConfig.Method)
+			route, err = webhookclient.NewWebhook(
+				r.RouterName,
+				r.UpStreams.WebhookConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("webhook connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.WebhookConfig.URL, r.UpStreams.WebhookConfig.Method).Set(1)
+		case setting.Graphite:
+			defaultTelemetry.Logger.Debug("graphite connect", "address", r.UpStreams.GraphiteConfig.Address, "format", r.UpStreams.GraphiteConfig.Format)
+			route, err = graphiteclient.NewGraphite(
+				r.RouterName,
+				r.UpStreams.GraphiteConfig,
+			)
+			if err != nil {
+				defaultTelemetry.Logger.Error("graphite connect error", "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), r.UpStreams.GraphiteConfig.Address, r.UpStreams.GraphiteConfig.Prefix).Set(1)
 		case setting.RemoteWriter:
-			defaultTelemetry.Logger.Debug("remote connect", "type", r.UpStreams.UpStreamsType, "urls", r.UpStreams.UpstreamUrls)
-			route = remote.NewRemoteCluster(
+			endpoints := r.UpStreams.Endpoints()
+			defaultTelemetry.Logger.Debug("remote connect", "type", r.UpStreams.UpStreamsType, "urls", endpointURLs(endpoints))
+			route, err = remote.NewRemoteCluster(
 				r.RouterName,
-				r.HashLabels.Mode,
-				r.HashLabels.Labels,
-				r.UpStreams.UpstreamUrls,
+				r.HashLabels,
+				r.UpStreams,
 			)
-			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(r.UpStreams.UpstreamUrls, ","), "").Set(1)
+			if err != nil {
+				defaultTelemetry.Logger.Error("remote config error", "name", r.RouterName, "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(endpointURLs(endpoints), ","), r.HashLabels.Algorithm).Set(1)
 		default:
+			endpoints := r.UpStreams.Endpoints()
 			defaultTelemetry.Logger.Debug("default remote connect", "type", r.UpStreams.UpStreamsType)
-			route = remote.NewRemoteCluster(
+			route, err = remote.NewRemoteCluster(
 				r.RouterName,
-				r.HashLabels.Mode,
-				r.HashLabels.Labels,
-				r.UpStreams.UpstreamUrls,
+				r.HashLabels,
+				r.UpStreams,
 			)
-			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(r.UpStreams.UpstreamUrls, ","), "").Set(1)
+			if err != nil {
+				defaultTelemetry.Logger.Error("remote config error", "name", r.RouterName, "err", err)
+				continue
+			}
+			routerInfo.WithLabelValues(r.RouterName, string(r.UpStreams.UpStreamsType), strings.Join(endpointURLs(endpoints), ","), r.HashLabels.Algorithm).Set(1)
 		}
 
-		DefaultRouters.Routers[r.RouterName] = &Router{
+		router := &Router{
 			Name:                 r.RouterName,
 			MetricRelabelConfigs: r.MetricRelabelConfigs,
 			RemoteStore:          route,
+			Limiter:              cardinality.New(r.RouterName, r.CardinalityLimits),
 		}
+		if len(r.Aggregations) > 0 {
+			router.Aggregators, err = aggregator.New(r.RouterName, r.Aggregations, router.storeAggregated)
+			if err != nil {
+				defaultTelemetry.Logger.Error("aggregation config error", "name", r.RouterName, "err", err)
+				continue
+			}
+		}
+		router.Downsampler, err = downsample.New(r.RouterName, r.Downsample, router.storeAggregated)
+		if err != nil {
+			defaultTelemetry.Logger.Error("downsample config error", "name", r.RouterName, "err", err)
+			continue
+		}
+		DefaultRouters.Routers[r.RouterName] = router
 		defaultTelemetry.Logger.Debug("build router", "name", r.RouterName, "info", route)
 	}
+	return nil
 }
 
+// Store routes req, the series of the single tenant carried by ctx as
+// resolved by the receiver.
 func (rs *Routers) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
 	defer ctx.Done()
 	defaultTelemetry.Logger.Debug("store num ,", "len", len(rs.Routers))
 	if len(rs.Routers) == 0 {
 		return 500, nil
 	}
+	id := tenant.FromContext(ctx)
+	req = rs.Limiter.Filter(rs.Deduper.Filter(id, req))
+	if len(req) == 0 {
+		return 0, nil
+	}
 	go routerTimeseries.WithLabelValues("all").Add(float64(len(req)))
+	rs.store(id, req)
+	return 0, nil
+}
+
+// store routes the series of a tenant, passing it on to upstreams
+// through the context. Routes are stored concurrently so an upstream
+// waiting on its replicas doesn't delay the others, and store returns once
+// every route is done.
+func (rs *Routers) store(id string, req []prompb.TimeSeries) {
+	ctx := tenant.WithTenant(context.Background(), id)
+	var wg sync.WaitGroup
+	for _, r := range rs.Routers {
+		wg.Add(1)
+		go func(r *Router) {
+			defer wg.Done()
+			r.store(ctx, id, req)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Close closes the upstreams of every route which implement io.Closer,
+// flushing what they buffer.
+func (rs *Routers) Close() {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
 	for _, r := range rs.Routers {
-		defaultTelemetry.Logger.Debug("store ", "name", r.Name, "len", len(req))
-		filterTs := r.filterLabels(req)
-		if len(filterTs) == 0 {
-			defaultTelemetry.Logger.Debug("filter timeseries null ", "name", r.Name)
+		closer, ok := r.RemoteStore.(io.Closer)
+		if !ok {
 			continue
 		}
-		go routerTimeseries.WithLabelValues(r.Name).Add(float64(len(filterTs)))
-		defaultTelemetry.Logger.Debug("filter timeseries ", "name", r.Name, "timeseries", len(filterTs))
-
-		if _, err := r.RemoteStore.Store(context.Background(), filterTs); err != nil {
-			//TODO: log
-			go routerFalseTimeseries.WithLabelValues(r.Name).Add(float64(len(filterTs)))
-			defaultTelemetry.Logger.Error("remote store error", "err", err)
+		if err := closer.Close(); err != nil {
+			defaultTelemetry.Logger.Error("close upstream error", "name", r.Name, "err", err)
 		}
 	}
-	return 0, nil
 }
 
 type Router struct {
 	Name                 string
 	MetricRelabelConfigs []*relabel.Config
 	RemoteStore          RemoteStore
+	Aggregators          *aggregator.Aggregators
+	Downsampler          *downsample.Downsampler
+	Limiter              *cardinality.Limiter
 }
 
-func (r *Router) filterLabels(ts []prompb.TimeSeries) []prompb.TimeSeries {
+func (r *Router) store(ctx context.Context, id string, req []prompb.TimeSeries) {
+	defaultTelemetry.Logger.Debug("store ", "name", r.Name, "len", len(req), "tenant", id)
+	filterTs := r.Limiter.Filter(r.Downsampler.Push(id, r.Aggregators.Push(id, r.filterLabels(req, id))))
+	if len(filterTs) == 0 {
+		defaultTelemetry.Logger.Debug("filter timeseries null ", "name", r.Name)
+		return
+	}
+	go routerTimeseries.WithLabelValues(r.Name).Add(float64(len(filterTs)))
+	defaultTelemetry.Logger.Debug("filter timeseries ", "name", r.Name, "timeseries", len(filterTs))
+
+	if _, err := r.RemoteStore.Store(ctx, filterTs); err != nil {
+		//TODO: log
+		go routerFalseTimeseries.WithLabelValues(r.Name).Add(float64(len(filterTs)))
+		defaultTelemetry.Logger.Error("remote store error", "err", err)
+	}
+}
+
+// storeAggregated sends the series of tenant id produced by the route's
+// aggregations and by the periodic flush of its downsampler, within the
+// route's cardinality limits.
+func (r *Router) storeAggregated(id string, tss []prompb.TimeSeries) {
+	tss = r.Limiter.Filter(tss)
+	if len(tss) == 0 {
+		return
+	}
+	go routerTimeseries.WithLabelValues(r.Name).Add(float64(len(tss)))
+	if _, err := r.RemoteStore.Store(tenant.WithTenant(context.Background(), id), tss); err != nil {
+		go routerFalseTimeseries.WithLabelValues(r.Name).Add(float64(len(tss)))
+		defaultTelemetry.Logger.Error("remote store error", "err", err)
+	}
+}
+
+// filterLabels keeps the series selected by the route relabeling, which
+// sees the tenant as the __tenant__ pseudo-label.
+func (r *Router) filterLabels(ts []prompb.TimeSeries, id string) []prompb.TimeSeries {
 	fiterTS := make([]prompb.TimeSeries, 0)
 	for _, t := range ts {
 		lbs := formatLabelSet(t.Labels)
+		if id != "" {
+			lbs = labels.NewBuilder(lbs).Set(tenant.Label, id).Labels()
+		}
 		lbls, keep := relabel.Process(lbs, r.MetricRelabelConfigs...)
 		if !keep || lbls.IsEmpty() {
 			continue
@@ -130,6 +353,14 @@ func (r *Router) filterLabels(ts []prompb.TimeSeries) []prompb.TimeSeries {
 	return fiterTS
 }
 
+func endpointURLs(endpoints []setting.UpstreamEndpoint) []string {
+	urls := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		urls = append(urls, e.URL)
+	}
+	return urls
+}
+
 func formatLabelSet(lb []prompb.Label) labels.Labels {
 	var m = make(map[string]string, 0)
 	for _, v := range lb {
@@ -0,0 +1,104 @@
+package router_test
+
+import (
+	"context"
+	"stream-metrics-route/pkg/router"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestBuildRoutersHADedupError(t *testing.T) {
+	cfg := &setting.Config{HADedup: setting.HADedupConf{
+		Enabled:         true,
+		FailoverTimeout: model.Duration(10 * time.Second),
+		UpdateTimeout:   model.Duration(10 * time.Second),
+	}}
+	if err := router.BuildRouters(cfg); err == nil {
+		t.Fatal("expected an error for update_timeout not lower than failover_timeout")
+	}
+	cfg.HADedup.UpdateTimeout = model.Duration(time.Second)
+	if err := router.BuildRouters(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingStore records the series it's given, holding every call until
+// release is closed.
+type blockingStore struct {
+	release chan struct{}
+	stored  chan []prompb.TimeSeries
+}
+
+func (s *blockingStore) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	s.stored <- req
+	<-s.release
+	return 0, nil
+}
+
+func TestRoutersStoreSlowRoute(t *testing.T) {
+	slow := &blockingStore{release: make(chan struct{}), stored: make(chan []prompb.TimeSeries, 1)}
+	fast := &blockingStore{release: make(chan struct{}), stored: make(chan []prompb.TimeSeries, 1)}
+	close(fast.release)
+	rs := &router.Routers{Routers: map[string]*router.Router{
+		"slow": {Name: "slow", RemoteStore: slow},
+		"fast": {Name: "fast", RemoteStore: fast},
+	}}
+	req := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rs.Store(context.Background(), req)
+	}()
+
+	for _, s := range []*blockingStore{slow, fast} {
+		select {
+		case <-s.stored:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a slow route delayed the others")
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("Store returned before every route was done")
+	default:
+	}
+	close(slow.release)
+	<-done
+}
+
+func TestRoutersStoreTenantRelabeling(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{}), stored: make(chan []prompb.TimeSeries, 2)}
+	close(store.release)
+	rs := &router.Routers{Routers: map[string]*router.Router{
+		"team-a": {
+			Name: "team-a",
+			MetricRelabelConfigs: []*relabel.Config{{
+				SourceLabels: model.LabelNames{tenant.Label},
+				Regex:        relabel.MustNewRegexp("team-a"),
+				Action:       relabel.Keep,
+			}},
+			RemoteStore: store,
+		},
+	}}
+	req := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}
+	rs.Store(tenant.WithTenant(context.Background(), "team-b"), req)
+	rs.Store(tenant.WithTenant(context.Background(), "team-a"), req)
+	if n := len(store.stored); n != 1 {
+		t.Fatalf("expected only the series of team-a to be kept, got %d stores", n)
+	}
+	if tss := <-store.stored; len(tss[0].Labels) != 1 {
+		t.Fatalf("the tenant pseudo-label was sent upstream: %v", tss[0].Labels)
+	}
+}
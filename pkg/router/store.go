@@ -6,6 +6,8 @@ import (
 	"github.com/prometheus/prometheus/prompb"
 )
 
+// RemoteStore is the upstream of a route. Upstreams buffering series also
+// implement io.Closer to flush them, and are closed on shutdown.
 type RemoteStore interface {
 	Store(ctx context.Context, req []prompb.TimeSeries) (int, error)
 }
@@ -0,0 +1,139 @@
+package s3client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// columnarMagic starts every object written in the columnar layout.
+const columnarMagic = "SMRCOL1\n"
+
+// batch accumulates routed series until the object is flushed.
+type batch interface {
+	Add(req []prompb.TimeSeries) error
+	// Size is the approximate uncompressed size of the batch in bytes.
+	Size() int
+	Bytes() []byte
+	Ext() string
+}
+
+func newBatch(format string) (batch, error) {
+	switch format {
+	case "", "prompb", "protobuf":
+		return &prompbBatch{}, nil
+	case "columnar":
+		return &columnarBatch{index: make(map[string]int)}, nil
+	default:
+		return nil, fmt.Errorf("unknown s3 format %q", format)
+	}
+}
+
+// prompbBatch stores every Store call as a prompb.WriteRequest prefixed with
+// its length as a big-endian uint32.
+type prompbBatch struct {
+	buf bytes.Buffer
+}
+
+func (b *prompbBatch) Ext() string { return "pb" }
+
+func (b *prompbBatch) Size() int { return b.buf.Len() }
+
+func (b *prompbBatch) Bytes() []byte { return b.buf.Bytes() }
+
+func (b *prompbBatch) Add(req []prompb.TimeSeries) error {
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: req})
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	b.buf.Write(size[:])
+	b.buf.Write(data)
+	return nil
+}
+
+// columnarBatch groups samples by series and writes them column by column:
+//
+//	magic | labels block | sample counts block | timestamps block | values block
+//
+// Every block is prefixed with its length as a uvarint so readers can skip
+// the columns they don't need. Labels are (count, name, value...) with every
+// string length-prefixed, counts are uvarints, timestamps are varint deltas
+// restarting at every series and values are little-endian float64 bits.
+type columnarBatch struct {
+	index  map[string]int
+	labels [][]prompb.Label
+	ts     [][]int64
+	values [][]float64
+	size   int
+}
+
+func (b *columnarBatch) Ext() string { return "col" }
+
+func (b *columnarBatch) Size() int { return b.size }
+
+func (b *columnarBatch) Add(req []prompb.TimeSeries) error {
+	for _, ts := range req {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		lbs := append([]prompb.Label(nil), ts.Labels...)
+		sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+		key := string(marshalLabels(nil, lbs))
+		idx, ok := b.index[key]
+		if !ok {
+			idx = len(b.labels)
+			b.index[key] = idx
+			b.labels = append(b.labels, lbs)
+			b.ts = append(b.ts, nil)
+			b.values = append(b.values, nil)
+			b.size += len(key)
+		}
+		for _, s := range ts.Samples {
+			b.ts[idx] = append(b.ts[idx], s.Timestamp)
+			b.values[idx] = append(b.values[idx], s.Value)
+		}
+		b.size += len(ts.Samples) * 16
+	}
+	return nil
+}
+
+func (b *columnarBatch) Bytes() []byte {
+	var labelsBlock, countsBlock, tsBlock, valuesBlock []byte
+	labelsBlock = binary.AppendUvarint(labelsBlock, uint64(len(b.labels)))
+	for i, lbs := range b.labels {
+		labelsBlock = marshalLabels(labelsBlock, lbs)
+		countsBlock = binary.AppendUvarint(countsBlock, uint64(len(b.ts[i])))
+		var prev int64
+		for j, t := range b.ts[i] {
+			tsBlock = binary.AppendVarint(tsBlock, t-prev)
+			prev = t
+			valuesBlock = binary.LittleEndian.AppendUint64(valuesBlock, math.Float64bits(b.values[i][j]))
+		}
+	}
+
+	dst := make([]byte, 0, len(columnarMagic)+len(labelsBlock)+len(countsBlock)+len(tsBlock)+len(valuesBlock)+4*binary.MaxVarintLen64)
+	dst = append(dst, columnarMagic...)
+	for _, block := range [][]byte{labelsBlock, countsBlock, tsBlock, valuesBlock} {
+		dst = binary.AppendUvarint(dst, uint64(len(block)))
+		dst = append(dst, block...)
+	}
+	return dst
+}
+
+func marshalLabels(dst []byte, lbs []prompb.Label) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(lbs)))
+	for _, l := range lbs {
+		dst = binary.AppendUvarint(dst, uint64(len(l.Name)))
+		dst = append(dst, l.Name...)
+		dst = binary.AppendUvarint(dst, uint64(len(l.Value)))
+		dst = append(dst, l.Value...)
+	}
+	return dst
+}
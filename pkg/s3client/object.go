@@ -0,0 +1,163 @@
+package s3client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxErrMsgLen = 1024
+
+// objectClient implements the handful of S3 REST calls needed to upload an
+// object, either in one PUT or as a multipart upload.
+type objectClient struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    *Signer
+	client    *http.Client
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (o *objectClient) objectURL(key string, query url.Values) *url.URL {
+	u := *o.endpoint
+	if o.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + o.bucket + "/" + key
+	} else {
+		u.Host = o.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (o *objectClient) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "stream-metrics-route")
+	req.ContentLength = int64(len(body))
+	o.signer.Sign(req, hashHex(body), time.Now())
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		return nil, fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	}
+	return resp, nil
+}
+
+func (o *objectClient) putObject(ctx context.Context, key string, body []byte, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	resp, err := o.do(ctx, http.MethodPut, o.objectURL(key, nil), body, header)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// multipartUpload uploads body in parts of partSize bytes. The upload is
+// aborted if any part fails so no orphaned parts are left in the bucket.
+func (o *objectClient) multipartUpload(ctx context.Context, key string, body []byte, contentType string, partSize int) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	resp, err := o.do(ctx, http.MethodPost, o.objectURL(key, url.Values{"uploads": {""}}), nil, header)
+	if err != nil {
+		return err
+	}
+	var initiate initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't decode initiate multipart upload result %v", err)
+	}
+
+	complete := completeMultipartUpload{}
+	for part := 1; len(body) > 0; part++ {
+		n := partSize
+		if n > len(body) {
+			n = len(body)
+		}
+		query := url.Values{
+			"partNumber": {strconv.Itoa(part)},
+			"uploadId":   {initiate.UploadID},
+		}
+		resp, err := o.do(ctx, http.MethodPut, o.objectURL(key, query), body[:n], nil)
+		if err != nil {
+			o.abortMultipartUpload(key, initiate.UploadID)
+			return fmt.Errorf("upload part %d: %v", part, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: part, ETag: resp.Header.Get("ETag")})
+		body = body[n:]
+	}
+
+	payload, err := xml.Marshal(complete)
+	if err != nil {
+		o.abortMultipartUpload(key, initiate.UploadID)
+		return err
+	}
+	resp, err = o.do(ctx, http.MethodPost, o.objectURL(key, url.Values{"uploadId": {initiate.UploadID}}), payload, nil)
+	if err != nil {
+		o.abortMultipartUpload(key, initiate.UploadID)
+		return err
+	}
+	// S3 may report an error in the body of a 200 response to complete.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrMsgLen))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("complete multipart upload: %s", data)
+	}
+	return nil
+}
+
+func (o *objectClient) abortMultipartUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := o.do(ctx, http.MethodDelete, o.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, nil)
+	if err != nil {
+		defaultTelemetry.Logger.Error("abort multipart upload error", "key", key, "err", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,266 @@
+package s3client
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultRegion              = "us-east-1"
+	defaultFlushSize     int64 = 64 * 1024 * 1024
+	defaultFlushInterval       = 5 * time.Minute
+	defaultPartSize      int64 = 16 * 1024 * 1024
+	minPartSize          int64 = 5 * 1024 * 1024
+	defaultTimeout             = time.Minute
+)
+
+// S3Client buffers routed series and archives them as time partitioned,
+// zstd compressed objects in an S3 compatible bucket.
+type S3Client struct {
+	name          string
+	prefix        string
+	format        string
+	flushSize     int
+	flushInterval time.Duration
+	partSize      int
+	timeout       time.Duration
+	objects       *objectClient
+	encoder       *zstd.Encoder
+
+	lock    sync.Mutex
+	current batch
+	started time.Time
+	series  int
+	closed  bool
+
+	stop     chan struct{}
+	flushing sync.WaitGroup
+}
+
+func NewS3Client(name string, cfg setting.S3Config) (*S3Client, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the s3 endpoint %v", err)
+	}
+	if _, err := newBatch(cfg.Format); err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if cfg.SecretAccessKey == "" {
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.SessionToken == "" {
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.FlushSize <= 0 {
+		cfg.FlushSize = defaultFlushSize
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize {
+		cfg.PartSize = minPartSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &S3Client{
+		name:          name,
+		prefix:        cfg.Prefix,
+		format:        cfg.Format,
+		flushSize:     int(cfg.FlushSize),
+		flushInterval: flushInterval,
+		partSize:      int(cfg.PartSize),
+		timeout:       timeout,
+		encoder:       encoder,
+		stop:          make(chan struct{}),
+		objects: &objectClient{
+			endpoint:  endpoint,
+			bucket:    cfg.Bucket,
+			pathStyle: cfg.ForcePathStyle,
+			client:    &http.Client{},
+			signer: &Signer{
+				AccessKeyID:     cfg.AccessKeyID,
+				SecretAccessKey: cfg.SecretAccessKey,
+				SessionToken:    cfg.SessionToken,
+				Region:          cfg.Region,
+				Service:         "s3",
+			},
+		},
+	}
+	go s.flushLoop()
+	defaultTelemetry.Logger.Debug("create s3 client", "name", name, "endpoint", cfg.Endpoint, "bucket", cfg.Bucket)
+	return s, nil
+}
+
+func (s *S3Client) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		s3FalseTimeseries.WithLabelValues(s.name).Add(float64(len(req)))
+		return http.StatusServiceUnavailable, fmt.Errorf("s3 client %s is closed", s.name)
+	}
+	if s.current == nil {
+		s.current, _ = newBatch(s.format)
+		s.started = time.Now()
+		s.series = 0
+	}
+	if err := s.current.Add(req); err != nil {
+		s.lock.Unlock()
+		s3FalseTimeseries.WithLabelValues(s.name).Add(float64(len(req)))
+		return http.StatusInternalServerError, fmt.Errorf("couldn't encode timeseries %v", err)
+	}
+	s.series += len(req)
+	s3Timeseries.WithLabelValues(s.name).Add(float64(len(req)))
+	var full batch
+	var started time.Time
+	var series int
+	if s.current.Size() >= s.flushSize {
+		full, started, series = s.current, s.started, s.series
+		s.current = nil
+		s.flushing.Add(1)
+	}
+	s.lock.Unlock()
+
+	if full != nil {
+		go func() {
+			defer s.flushing.Done()
+			s.flush(full, started, series)
+		}()
+	}
+	return 0, nil
+}
+
+// Close uploads the buffered series and waits for the uploads in flight.
+// Later writes fail.
+func (s *S3Client) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	full, started, series := s.current, s.started, s.series
+	s.current = nil
+	s.lock.Unlock()
+
+	if full != nil {
+		s.flush(full, started, series)
+	}
+	s.flushing.Wait()
+	return nil
+}
+
+func (s *S3Client) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		var full batch
+		var started time.Time
+		var series int
+		// also cut the object when the hour partition changes
+		if s.current != nil && (time.Since(s.started) >= s.flushInterval ||
+			time.Now().UTC().Truncate(time.Hour) != s.started.UTC().Truncate(time.Hour)) {
+			full, started, series = s.current, s.started, s.series
+			s.current = nil
+			s.flushing.Add(1)
+		}
+		s.lock.Unlock()
+		if full != nil {
+			s.flush(full, started, series)
+			s.flushing.Done()
+		}
+	}
+}
+
+func (s *S3Client) flush(b batch, started time.Time, series int) {
+	key, err := s.objectKey(started, b.Ext())
+	if err != nil {
+		s3ObjectsFailed.WithLabelValues(s.name).Inc()
+		s3FalseTimeseries.WithLabelValues(s.name).Add(float64(series))
+		defaultTelemetry.Logger.Error("object key error", "name", s.name, "err", err)
+		return
+	}
+	body := s.encoder.EncodeAll(b.Bytes(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	const retries = 3
+	for i := 0; i < retries; i++ {
+		if len(body) > s.partSize {
+			err = s.objects.multipartUpload(ctx, key, body, "application/zstd", s.partSize)
+		} else {
+			err = s.objects.putObject(ctx, key, body, "application/zstd")
+		}
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Duration(i+1) * 500 * time.Millisecond)
+	}
+	if err != nil {
+		s3ObjectsFailed.WithLabelValues(s.name).Inc()
+		s3FalseTimeseries.WithLabelValues(s.name).Add(float64(series))
+		defaultTelemetry.Logger.Error("upload object error", "name", s.name, "key", key, "err", err)
+		return
+	}
+	s3ObjectsWritten.WithLabelValues(s.name).Inc()
+	s3WrittenBytes.WithLabelValues(s.name).Add(float64(len(body)))
+	defaultTelemetry.Logger.Debug("upload object", "name", s.name, "key", key, "size", len(body))
+}
+
+// objectKey renders prefix/yyyy/mm/dd/hh/<route>-<uuid>.<ext>.zst
+func (s *S3Client) objectKey(t time.Time, ext string) (string, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	t = t.UTC()
+	return path.Join(s.prefix, t.Format("2006/01/02/15"), fmt.Sprintf("%s-%s.%s.zst", s.name, uuid, ext)), nil
+}
+
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,222 @@
+package s3client_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"stream-metrics-route/pkg/s3client"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// bucket is an S3 stand-in serving path style PUT and multipart uploads.
+type bucket struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	parts   map[string][]byte
+	uploads int
+}
+
+func newBucket(t *testing.T) (*bucket, string) {
+	b := &bucket{objects: make(map[string][]byte), parts: make(map[string][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/archive/")
+		query := r.URL.Query()
+		body, _ := io.ReadAll(r.Body)
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		switch {
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			b.uploads++
+			fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>upload-%d</UploadId></InitiateMultipartUploadResult>", b.uploads)
+		case r.Method == http.MethodPut && query.Has("partNumber"):
+			b.parts[query.Get("uploadId")+"/"+query.Get("partNumber")] = body
+			w.Header().Set("ETag", `"`+query.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost && query.Has("uploadId"):
+			var complete struct {
+				Parts []struct {
+					PartNumber int
+					ETag       string
+				} `xml:"Part"`
+			}
+			if err := xml.Unmarshal(body, &complete); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var object []byte
+			for _, p := range complete.Parts {
+				object = append(object, b.parts[fmt.Sprintf("%s/%d", query.Get("uploadId"), p.PartNumber)]...)
+			}
+			b.objects[key] = object
+			io.WriteString(w, "<CompleteMultipartUploadResult/>")
+		case r.Method == http.MethodPut:
+			b.objects[key] = body
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return b, srv.URL
+}
+
+// wait returns the decompressed objects once there are n of them.
+func (b *bucket) wait(t *testing.T, n int) map[string][]byte {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.lock.Lock()
+		count := len(b.objects)
+		b.lock.Unlock()
+		if count >= n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d objects, got %d", n, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	decoder, _ := zstd.NewReader(nil)
+	defer decoder.Close()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	objects := make(map[string][]byte)
+	for key, body := range b.objects {
+		data, err := decoder.DecodeAll(body, nil)
+		if err != nil {
+			t.Fatalf("object %s: %v", key, err)
+		}
+		objects[key] = data
+	}
+	return objects
+}
+
+func config(url string) setting.S3Config {
+	return setting.S3Config{
+		Endpoint:        url,
+		Bucket:          "archive",
+		Prefix:          "metrics",
+		ForcePathStyle:  true,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+}
+
+func series(value string) []prompb.TimeSeries {
+	return []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: value}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1697600000000}},
+	}}
+}
+
+var keyPattern = regexp.MustCompile(`^metrics/\d{4}/\d{2}/\d{2}/\d{2}/archive-[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.pb\.zst$`)
+
+func TestS3ClientFlush(t *testing.T) {
+	b, url := newBucket(t)
+	cfg := config(url)
+	cfg.FlushSize = 100
+	s, err := s3client.NewS3Client("archive", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A batch is flushed once larger than the flush size.
+	if _, err := s.Store(context.Background(), series(strings.Repeat("node", 30))); err != nil {
+		t.Fatal(err)
+	}
+	for key, data := range b.wait(t, 1) {
+		if !keyPattern.MatchString(key) {
+			t.Fatalf("unexpected object key %s", key)
+		}
+		if !strings.Contains(string(data), strings.Repeat("node", 30)) {
+			t.Fatalf("expected the series in the object, got %q", data)
+		}
+	}
+
+	// Smaller batches wait for the flush interval.
+	cfg.FlushInterval = model.Duration(100 * time.Millisecond)
+	cfg.FlushSize = 0
+	s, err = s3client.NewS3Client("archive", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Store(context.Background(), series("interval")); err != nil {
+		t.Fatal(err)
+	}
+	b.wait(t, 2)
+}
+
+func TestS3ClientClose(t *testing.T) {
+	b, url := newBucket(t)
+	s, err := s3client.NewS3Client("archive", config(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []string{"a", "b"} {
+		if _, err := s.Store(context.Background(), series(job)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.lock.Lock()
+	count := len(b.objects)
+	b.lock.Unlock()
+	if count != 1 {
+		t.Fatalf("expected the buffered series flushed in one object on close, got %d", count)
+	}
+	if _, err := s.Store(context.Background(), series("c")); err == nil {
+		t.Fatal("expected writes to fail once closed")
+	}
+}
+
+func TestS3ClientMultipartUpload(t *testing.T) {
+	b, url := newBucket(t)
+	cfg := config(url)
+	cfg.FlushSize = 1 << 20
+	cfg.PartSize = 5 << 20
+	s, err := s3client.NewS3Client("archive", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Random bytes don't compress, so the object spans two parts of the
+	// minimum part size of 5MiB.
+	value := make([]byte, 6<<20)
+	if _, err := rand.Read(value); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Store(context.Background(), series(string(value))); err != nil {
+		t.Fatal(err)
+	}
+	objects := b.wait(t, 1)
+	b.lock.Lock()
+	parts := make([]string, 0, len(b.parts))
+	for part := range b.parts {
+		parts = append(parts, part)
+	}
+	b.lock.Unlock()
+	sort.Strings(parts)
+	if len(parts) != 2 || parts[0] != "upload-1/1" || parts[1] != "upload-1/2" {
+		t.Fatalf("expected two parts, got %v", parts)
+	}
+	for _, data := range objects {
+		if !strings.Contains(string(data), string(value)) {
+			t.Fatal("expected the parts to be assembled into the object")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package s3client
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_s3"
+
+var (
+	s3Timeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of timeseries buffered for upload",
+		}, []string{"route_name"})
+	s3FalseTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_false_total",
+			Help:      "Count of timeseries lost to encoding or upload failures",
+		}, []string{"route_name"})
+	s3ObjectsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_written_total",
+			Help:      "Count of all objects uploaded to S3",
+		}, []string{"route_name"})
+	s3ObjectsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "objects_failed_total",
+			Help:      "Count of all object upload failures to S3",
+		}, []string{"route_name"})
+	s3WrittenBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "written_bytes_total",
+			Help:      "Count of compressed bytes uploaded to S3",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(s3Timeseries)
+	defaultTelemetry.Register(s3FalseTimeseries)
+	defaultTelemetry.Register(s3ObjectsWritten)
+	defaultTelemetry.Register(s3ObjectsFailed)
+	defaultTelemetry.Register(s3WrittenBytes)
+}
@@ -0,0 +1,11 @@
+package serialize
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+}
@@ -0,0 +1,19 @@
+package setting
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// AggregationConf aggregates the series of a route over Interval and sends
+// the results to the route's upstream. Series are selected with
+// MetricRelabelConfigs the same way routes select them; an empty list
+// selects every series of the route.
+type AggregationConf struct {
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs,omitempty"`
+	Interval             model.Duration    `yaml:"interval"`
+	By                   []string          `yaml:"by,omitempty"`
+	Without              []string          `yaml:"without,omitempty"`
+	Outputs              []string          `yaml:"outputs"`
+	DropInputs           bool              `yaml:"drop_inputs,omitempty"`
+}
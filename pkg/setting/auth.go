@@ -0,0 +1,54 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// AuthConf authenticates the receive endpoints. Requests must present
+// credentials accepted by one of the configured methods: a client
+// certificate, a static bearer token, a JWT verified against JWKSFile or
+// basic auth checked against BasicAuthFile, a file of `user:bcrypt-hash`
+// lines. Files are reloaded every ReloadInterval.
+//
+// The authenticated identity is mapped through Tenants, and used as the
+// request tenant with IdentityAsTenant, overriding the path and header
+// ones. It's set as the IdentityLabel label of every series when named.
+type AuthConf struct {
+	BearerTokens     []BearerTokenConf   `yaml:"bearer_tokens,omitempty"`
+	BasicAuthFile    string              `yaml:"basic_auth_file,omitempty"`
+	ClientCert       *ClientCertAuthConf `yaml:"client_cert,omitempty"`
+	JWT              *JWTAuthConf        `yaml:"jwt,omitempty"`
+	ReloadInterval   model.Duration      `yaml:"reload_interval,omitempty"`
+	Tenants          map[string]string   `yaml:"tenants,omitempty"`
+	IdentityAsTenant bool                `yaml:"identity_as_tenant,omitempty"`
+	IdentityLabel    string              `yaml:"identity_label,omitempty"`
+}
+
+// BearerTokenConf is a static token, read from TokenFile when set, and
+// the identity it authenticates.
+type BearerTokenConf struct {
+	Token     config.Secret `yaml:"token,omitempty"`
+	TokenFile string        `yaml:"token_file,omitempty"`
+	Identity  string        `yaml:"identity"`
+}
+
+// ClientCertAuthConf verifies client certificates against CAFile. The
+// identity is the subject common name, or with Identity `san` the first
+// DNS, email or URI SAN listed in Tenants, else the first one. The
+// listener must serve TLS and ask for client certificates.
+type ClientCertAuthConf struct {
+	CAFile   string `yaml:"ca_file"`
+	Identity string `yaml:"identity,omitempty"`
+}
+
+// JWTAuthConf verifies bearer JWTs signed with a key of JWKSFile. Issuer
+// and Audience are checked when set, and expiry with Leeway. The identity
+// is the IdentityClaim claim, `sub` by default.
+type JWTAuthConf struct {
+	JWKSFile      string         `yaml:"jwks_file"`
+	Issuer        string         `yaml:"issuer,omitempty"`
+	Audience      string         `yaml:"audience,omitempty"`
+	IdentityClaim string         `yaml:"identity_claim,omitempty"`
+	Leeway        model.Duration `yaml:"leeway,omitempty"`
+}
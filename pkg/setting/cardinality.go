@@ -0,0 +1,8 @@
+package setting
+
+// CardinalityLimitsConf caps the number of unique series accepted per hour
+// and per day. New series over a cap are dropped; zero disables a cap.
+type CardinalityLimitsConf struct {
+	MaxHourlySeries int `yaml:"max_hourly_series,omitempty"`
+	MaxDailySeries  int `yaml:"max_daily_series,omitempty"`
+}
@@ -0,0 +1,31 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type ClickHouseConfig struct {
+	URL                string            `yaml:"url"`
+	Database           string            `yaml:"database,omitempty"`
+	Table              string            `yaml:"table"`
+	Username           string            `yaml:"username,omitempty"`
+	Password           string            `yaml:"password,omitempty"`
+	Format             string            `yaml:"format,omitempty"`
+	Columns            ClickHouseColumns `yaml:"columns,omitempty"`
+	AsyncInsert        bool              `yaml:"async_insert,omitempty"`
+	WaitForAsyncInsert bool              `yaml:"wait_for_async_insert,omitempty"`
+	BatchSize          int               `yaml:"batch_size,omitempty"`
+	FlushInterval      model.Duration    `yaml:"flush_interval,omitempty"`
+	Concurrency        int               `yaml:"concurrency,omitempty"`
+	Timeout            model.Duration    `yaml:"timeout,omitempty"`
+	MaxRetries         *int              `yaml:"max_retries,omitempty"`
+	TLSConfig          config.TLSConfig  `yaml:"tls_config,omitempty"`
+}
+
+type ClickHouseColumns struct {
+	Name      string `yaml:"name,omitempty"`
+	Labels    string `yaml:"labels,omitempty"`
+	Timestamp string `yaml:"timestamp,omitempty"`
+	Value     string `yaml:"value,omitempty"`
+}
@@ -3,6 +3,7 @@ package setting
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v2"
@@ -18,8 +19,14 @@ var (
 )
 
 type Config struct {
-	GlobalConfig GlobalConf       `yaml:"global"`
-	RouterRule   []RouterRuleConf `yaml:"router_rules"`
+	GlobalConfig      GlobalConf            `yaml:"global"`
+	HADedup           HADedupConf           `yaml:"ha_dedup,omitempty"`
+	CardinalityLimits CardinalityLimitsConf `yaml:"cardinality_limits,omitempty"`
+	Validation        *ValidationConf       `yaml:"validation,omitempty"`
+	Tenant            *TenantConf           `yaml:"tenant,omitempty"`
+	Auth              *AuthConf             `yaml:"auth,omitempty"`
+	RequestLimits     *RequestLimitsConf    `yaml:"request_limits,omitempty"`
+	RouterRule        []RouterRuleConf      `yaml:"router_rules"`
 }
 
 type GlobalConf struct {
@@ -30,26 +37,71 @@ type RouterRuleConf struct {
 	RouterName string        `yaml:"router_name"`
 	UpStreams  UpStreamsConf `yaml:"upstreams"`
 
-	HashLabels           HashLabels        `yaml:"hash_labels,omitempty"`
-	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs,omitempty"`
+	HashLabels           HashLabels            `yaml:"hash_labels,omitempty"`
+	MetricRelabelConfigs []*relabel.Config     `yaml:"metric_relabel_configs,omitempty"`
+	Aggregations         []AggregationConf     `yaml:"aggregations,omitempty"`
+	CardinalityLimits    CardinalityLimitsConf `yaml:"cardinality_limits,omitempty"`
+	Downsample           *DownsampleConf       `yaml:"downsample,omitempty"`
 }
 
+// UpStreamsConf configures the upstream of a route. For remote write
+// clusters ReplicationFactor writes every series to as many distinct
+// upstreams, and a batch succeeds once each of its series reached
+// WriteQuorum of them, by default a majority.
 type UpStreamsConf struct {
-	UpStreamsType RemoteType  `yaml:"upstream_type"`
-	UpstreamUrls  []string    `yaml:"upstream_urls,omitempty"`
-	KafkaConfig   KafkaConfig `yaml:"kafka_config,omitempty"`
+	UpStreamsType       RemoteType          `yaml:"upstream_type"`
+	UpstreamUrls        []string            `yaml:"upstream_urls,omitempty"`
+	UpstreamEndpoints   []UpstreamEndpoint  `yaml:"upstream_endpoints,omitempty"`
+	ReplicationFactor   int                 `yaml:"replication_factor,omitempty"`
+	WriteQuorum         int                 `yaml:"write_quorum,omitempty"`
+	HealthCheck         *HealthCheckConf    `yaml:"health_check,omitempty"`
+	HTTPClientConfig    *HTTPClientConf     `yaml:"http_client_config,omitempty"`
+	TenantHeader        string              `yaml:"tenant_header,omitempty"`
+	KafkaConfig         KafkaConfig         `yaml:"kafka_config,omitempty"`
+	FileConfig          FileConfig          `yaml:"file_config,omitempty"`
+	S3Config            S3Config            `yaml:"s3_config,omitempty"`
+	NatsConfig          NatsConfig          `yaml:"nats_config,omitempty"`
+	RedisConfig         RedisConfig         `yaml:"redis_config,omitempty"`
+	MqttConfig          MqttConfig          `yaml:"mqtt_config,omitempty"`
+	InfluxConfig        InfluxConfig        `yaml:"influx_config,omitempty"`
+	ClickHouseConfig    ClickHouseConfig    `yaml:"clickhouse_config,omitempty"`
+	ElasticsearchConfig ElasticsearchConfig `yaml:"elasticsearch_config,omitempty"`
+	WebhookConfig       WebhookConfig       `yaml:"webhook_config,omitempty"`
+	GraphiteConfig      GraphiteConfig      `yaml:"graphite_config,omitempty"`
 }
 
 type RemoteType string
 
 const (
-	Kafka        RemoteType = "kafka"
-	RemoteWriter RemoteType = "remotewriter"
+	Kafka         RemoteType = "kafka"
+	RemoteWriter  RemoteType = "remotewriter"
+	File          RemoteType = "file"
+	S3            RemoteType = "s3"
+	Nats          RemoteType = "nats"
+	RedisStream   RemoteType = "redis_stream"
+	Mqtt          RemoteType = "mqtt"
+	Influx        RemoteType = "influx"
+	ClickHouse    RemoteType = "clickhouse"
+	Elasticsearch RemoteType = "elasticsearch"
+	Webhook       RemoteType = "webhook"
+	Graphite      RemoteType = "graphite"
 )
 
 type HashLabels struct {
 	Mode   int      `yaml:"mode"`
 	Labels []string `yaml:"labels"`
+	// Algorithm picks the upstream of a series: `modulo`, the default, or
+	// `consistent` for a hash ring with VirtualNodes points per unit of
+	// endpoint weight, which moves only ~1/N of the series when an
+	// upstream is added or removed.
+	Algorithm    string `yaml:"algorithm,omitempty"`
+	VirtualNodes int    `yaml:"virtual_nodes,omitempty"`
+	// With Mode above 0, series are assigned a shard in [0, Mode) sent
+	// upstream as ShardTarget: a `label` added to the series, the default,
+	// a request `header` or a URL `param`, named ShardName, by default
+	// stream_task_id.
+	ShardTarget string `yaml:"shard_target,omitempty"`
+	ShardName   string `yaml:"shard_name,omitempty"`
 }
 
 func (c Config) String() string {
@@ -89,10 +141,23 @@ func loadFile(filename string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
 	}
+	cfg.SetDirectory(filepath.Dir(filename))
 
 	return cfg, nil
 }
 
+// SetDirectory resolves the relative file paths of the upstream HTTP
+// client configs against dir.
+func (c *Config) SetDirectory(dir string) {
+	for i := range c.RouterRule {
+		upstreams := &c.RouterRule[i].UpStreams
+		upstreams.HTTPClientConfig.SetDirectory(dir)
+		for j := range upstreams.UpstreamEndpoints {
+			upstreams.UpstreamEndpoints[j].HTTPClientConfig.SetDirectory(dir)
+		}
+	}
+}
+
 func Load(s string) (*Config, error) {
 	cfg := &Config{}
 	//cfg = DefaultConfig
@@ -1,6 +1,9 @@
 package setting_test
 
 import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
 	"stream-metrics-route/pkg/setting"
 	"testing"
 )
@@ -27,3 +30,114 @@ router_rules:
 
 	t.Log("\n", cfg.String())
 }
+
+func TestUpstreamEndpoints(t *testing.T) {
+	testYaml := `
+router_rules:
+  - router_name: vmagent
+    upstreams:
+      upstream_type: remotewriter
+      upstream_urls:
+        - http://a/api/v1/write
+      upstream_endpoints:
+        - url: http://b/api/v1/write
+          weight: 2
+        - url: http://c/api/v1/write
+          http_client_config:
+            proxy_url: http://proxy:3128
+            follow_redirects: false
+      http_client_config:
+        timeout: 10s
+        bearer_token: secret
+        headers:
+          X-Scope-OrgID: tenant-a
+`
+	cfg, err := setting.Load(testYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := cfg.RouterRule[0].UpStreams
+	eps := upstreams.Endpoints()
+	if len(eps) != 3 || eps[0].Weight != 1 || eps[1].Weight != 2 || eps[2].Weight != 1 {
+		t.Fatalf("unexpected endpoints %+v", eps)
+	}
+	if eps[0].HTTPClientConfig != upstreams.HTTPClientConfig || eps[1].HTTPClientConfig != upstreams.HTTPClientConfig {
+		t.Fatal("expected endpoints without client config to use the route one")
+	}
+	own := eps[2].HTTPClientConfig.ClientConfig()
+	if own.ProxyURL.String() != "http://proxy:3128" || own.FollowRedirects || !own.EnableHTTP2 {
+		t.Fatalf("unexpected endpoint client config %+v", own)
+	}
+	if h := upstreams.HTTPClientConfig; h.Headers["X-Scope-OrgID"] != "tenant-a" || h.ClientConfig().BearerToken != "secret" {
+		t.Fatalf("unexpected route client config %+v", h)
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "web.yaml")
+	content := `
+tls_server_config:
+  cert_file: tls/cert.pem
+  key_file: /etc/tls/key.pem
+  min_version: TLS13
+http_server_config:
+  http2: false
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := setting.LoadWebConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TLSServerConfig.CertFile != filepath.Join(dir, "tls/cert.pem") || cfg.TLSServerConfig.KeyFile != "/etc/tls/key.pem" {
+		t.Fatalf("expected paths relative to the config file, got %+v", cfg.TLSServerConfig)
+	}
+	if cfg.TLSServerConfig.MinVersion != tls.VersionTLS13 || *cfg.HTTPServerConfig.HTTP2 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if err := os.WriteFile(file, []byte("tls_server_config:\n  cert_file: cert.pem\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setting.LoadWebConfig(file); err == nil {
+		t.Fatal("expected an error without key_file")
+	}
+}
+
+func TestLoadFileRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	content := `
+router_rules:
+  - router_name: vmagent
+    upstreams:
+      upstream_type: remotewriter
+      upstream_urls:
+        - https://vmagent-0:8429/api/v1/write
+      upstream_endpoints:
+        - url: https://vmagent-1:8429/api/v1/write
+          http_client_config:
+            tls_config:
+              ca_file: /etc/ssl/ca.pem
+      http_client_config:
+        bearer_token_file: secrets/token
+        tls_config:
+          ca_file: certs/ca.pem
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := setting.LoadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := cfg.RouterRule[0].UpStreams
+	if h := upstreams.HTTPClientConfig; h.BearerTokenFile != filepath.Join(dir, "secrets/token") || h.TLSConfig.CAFile != filepath.Join(dir, "certs/ca.pem") {
+		t.Fatalf("expected paths relative to the config file, got %q and %q", h.BearerTokenFile, h.TLSConfig.CAFile)
+	}
+	if ca := upstreams.UpstreamEndpoints[0].HTTPClientConfig.TLSConfig.CAFile; ca != "/etc/ssl/ca.pem" {
+		t.Fatalf("expected absolute paths to be kept, got %q", ca)
+	}
+}
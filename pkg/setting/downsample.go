@@ -0,0 +1,14 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+// DownsampleConf reduces every series of a route to one sample per
+// Interval, computed with Function: `last`, the default, `min`, `max`,
+// `avg` or `sum`. Series state lives in Shards maps and is forgotten after
+// IdleTimeout without samples, by default twice the interval.
+type DownsampleConf struct {
+	Interval    model.Duration `yaml:"interval"`
+	Function    string         `yaml:"function,omitempty"`
+	Shards      int            `yaml:"shards,omitempty"`
+	IdleTimeout model.Duration `yaml:"idle_timeout,omitempty"`
+}
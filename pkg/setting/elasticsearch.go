@@ -0,0 +1,23 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type ElasticsearchConfig struct {
+	URLs          []string         `yaml:"urls"`
+	Username      string           `yaml:"username,omitempty"`
+	Password      string           `yaml:"password,omitempty"`
+	APIKey        string           `yaml:"api_key,omitempty"`
+	Index         string           `yaml:"index"`
+	DataStream    bool             `yaml:"data_stream,omitempty"`
+	Mapping       string           `yaml:"mapping,omitempty"`
+	BulkSize      int              `yaml:"bulk_size,omitempty"`
+	FlushInterval model.Duration   `yaml:"flush_interval,omitempty"`
+	Concurrency   int              `yaml:"concurrency,omitempty"`
+	Gzip          bool             `yaml:"gzip,omitempty"`
+	Timeout       model.Duration   `yaml:"timeout,omitempty"`
+	MaxRetries    *int             `yaml:"max_retries,omitempty"`
+	TLSConfig     config.TLSConfig `yaml:"tls_config,omitempty"`
+}
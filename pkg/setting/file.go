@@ -0,0 +1,12 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+type FileConfig struct {
+	Path           string         `yaml:"path"`
+	Format         string         `yaml:"format,omitempty"`
+	MaxSize        int64          `yaml:"max_size,omitempty"`
+	RotateInterval model.Duration `yaml:"rotate_interval,omitempty"`
+	Compression    string         `yaml:"compression,omitempty"`
+	Retention      int            `yaml:"retention,omitempty"`
+}
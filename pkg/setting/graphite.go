@@ -0,0 +1,17 @@
+package setting
+
+import (
+	"github.com/prometheus/common/model"
+)
+
+type GraphiteConfig struct {
+	Address       string         `yaml:"address"`
+	Format        string         `yaml:"format,omitempty"`
+	Prefix        string         `yaml:"prefix,omitempty"`
+	Labels        []string       `yaml:"labels,omitempty"`
+	PoolSize      int            `yaml:"pool_size,omitempty"`
+	BatchSize     int            `yaml:"batch_size,omitempty"`
+	FlushInterval model.Duration `yaml:"flush_interval,omitempty"`
+	Timeout       model.Duration `yaml:"timeout,omitempty"`
+	MaxRetries    *int           `yaml:"max_retries,omitempty"`
+}
@@ -0,0 +1,30 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// HADedupConf accepts the samples of a single replica per HA cluster,
+// identified by the cluster and replica labels Prometheus attaches as
+// external labels.
+type HADedupConf struct {
+	Enabled         bool           `yaml:"enabled"`
+	ClusterLabel    string         `yaml:"cluster_label,omitempty"`
+	ReplicaLabel    string         `yaml:"replica_label,omitempty"`
+	FailoverTimeout model.Duration `yaml:"failover_timeout,omitempty"`
+	UpdateTimeout   model.Duration `yaml:"update_timeout,omitempty"`
+	Redis           *HADedupRedis  `yaml:"redis,omitempty"`
+}
+
+// HADedupRedis shares the elected replicas between route processes.
+type HADedupRedis struct {
+	Addrs      []string         `yaml:"addrs"`
+	MasterName string           `yaml:"master_name,omitempty"`
+	Cluster    bool             `yaml:"cluster,omitempty"`
+	Username   string           `yaml:"username,omitempty"`
+	Password   string           `yaml:"password,omitempty"`
+	DB         int              `yaml:"db,omitempty"`
+	KeyPrefix  string           `yaml:"key_prefix,omitempty"`
+	TLSConfig  config.TLSConfig `yaml:"tls_config,omitempty"`
+}
@@ -0,0 +1,15 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+// HealthCheckConf marks remote write upstreams down after FailureThreshold
+// consecutive failed writes or probes, moving their series to healthy
+// peers until they recover. With Path set, upstreams are probed with a GET
+// on that path every Interval; without it, a down upstream gets live
+// traffic again once Interval elapsed.
+type HealthCheckConf struct {
+	Path             string         `yaml:"path,omitempty"`
+	Interval         model.Duration `yaml:"interval,omitempty"`
+	Timeout          model.Duration `yaml:"timeout,omitempty"`
+	FailureThreshold int            `yaml:"failure_threshold,omitempty"`
+}
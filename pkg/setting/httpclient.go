@@ -0,0 +1,91 @@
+package setting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// reservedHeaders are set by the remote write protocol and can't be
+// overridden by Headers.
+var reservedHeaders = map[string]bool{
+	"content-encoding":                  true,
+	"content-length":                    true,
+	"content-type":                      true,
+	"user-agent":                        true,
+	"x-prometheus-remote-write-version": true,
+}
+
+// HTTPClientConf is the Prometheus http_client_config of remote write
+// upstreams, plus the request Timeout and static Headers such as
+// X-Scope-OrgID. Redirects are followed and HTTP/2 enabled unless turned
+// off.
+type HTTPClientConf struct {
+	BasicAuth          *config.BasicAuth     `yaml:"basic_auth,omitempty"`
+	Authorization      *config.Authorization `yaml:"authorization,omitempty"`
+	OAuth2             *config.OAuth2        `yaml:"oauth2,omitempty"`
+	BearerToken        config.Secret         `yaml:"bearer_token,omitempty"`
+	BearerTokenFile    string                `yaml:"bearer_token_file,omitempty"`
+	TLSConfig          config.TLSConfig      `yaml:"tls_config,omitempty"`
+	FollowRedirects    *bool                 `yaml:"follow_redirects,omitempty"`
+	EnableHTTP2        *bool                 `yaml:"enable_http2,omitempty"`
+	config.ProxyConfig `yaml:",inline"`
+	Timeout            model.Duration    `yaml:"timeout,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+}
+
+// ClientConfig returns the Prometheus client config of c.
+func (c *HTTPClientConf) ClientConfig() config.HTTPClientConfig {
+	cfg := config.DefaultHTTPClientConfig
+	if c == nil {
+		return cfg
+	}
+	cfg.BasicAuth = c.BasicAuth
+	cfg.Authorization = c.Authorization
+	cfg.OAuth2 = c.OAuth2
+	cfg.BearerToken = c.BearerToken
+	cfg.BearerTokenFile = c.BearerTokenFile
+	cfg.TLSConfig = c.TLSConfig
+	cfg.ProxyConfig = c.ProxyConfig
+	if c.FollowRedirects != nil {
+		cfg.FollowRedirects = *c.FollowRedirects
+	}
+	if c.EnableHTTP2 != nil {
+		cfg.EnableHTTP2 = *c.EnableHTTP2
+	}
+	return cfg
+}
+
+// SetDirectory joins the relative file paths of c, such as ca_file or
+// bearer_token_file, to dir, like Prometheus resolves them against the
+// directory of the config file.
+func (c *HTTPClientConf) SetDirectory(dir string) {
+	if c == nil {
+		return
+	}
+	c.BasicAuth.SetDirectory(dir)
+	c.Authorization.SetDirectory(dir)
+	c.OAuth2.SetDirectory(dir)
+	c.TLSConfig.SetDirectory(dir)
+	c.BearerTokenFile = config.JoinDir(dir, c.BearerTokenFile)
+}
+
+// Validate checks the auth modes are exclusive and no reserved header is
+// overridden.
+func (c *HTTPClientConf) Validate() error {
+	if c == nil {
+		return nil
+	}
+	cfg := c.ClientConfig()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for name := range c.Headers {
+		if reservedHeaders[strings.ToLower(name)] {
+			return fmt.Errorf("%s is a reserved header. It must not be changed", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type InfluxConfig struct {
+	URL             string           `yaml:"url"`
+	APIVersion      string           `yaml:"api_version,omitempty"`
+	Database        string           `yaml:"database,omitempty"`
+	RetentionPolicy string           `yaml:"retention_policy,omitempty"`
+	Username        string           `yaml:"username,omitempty"`
+	Password        string           `yaml:"password,omitempty"`
+	Org             string           `yaml:"org,omitempty"`
+	Bucket          string           `yaml:"bucket,omitempty"`
+	Token           string           `yaml:"token,omitempty"`
+	Precision       string           `yaml:"precision,omitempty"`
+	Measurement     string           `yaml:"measurement,omitempty"`
+	MeasurementName string           `yaml:"measurement_name,omitempty"`
+	FieldKey        string           `yaml:"field_key,omitempty"`
+	FieldLabels     []string         `yaml:"field_labels,omitempty"`
+	DropLabels      []string         `yaml:"drop_labels,omitempty"`
+	BatchSize       int              `yaml:"batch_size,omitempty"`
+	FlushInterval   model.Duration   `yaml:"flush_interval,omitempty"`
+	Concurrency     int              `yaml:"concurrency,omitempty"`
+	Gzip            bool             `yaml:"gzip,omitempty"`
+	Timeout         model.Duration   `yaml:"timeout,omitempty"`
+	MaxRetries      *int             `yaml:"max_retries,omitempty"`
+	TLSConfig       config.TLSConfig `yaml:"tls_config,omitempty"`
+}
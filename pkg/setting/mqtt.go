@@ -0,0 +1,24 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type MqttConfig struct {
+	Brokers             []string         `yaml:"brokers"`
+	ProtocolVersion     string           `yaml:"protocol_version,omitempty"`
+	ClientID            string           `yaml:"client_id,omitempty"`
+	Username            string           `yaml:"username,omitempty"`
+	Password            string           `yaml:"password,omitempty"`
+	Topic               string           `yaml:"topic"`
+	Match               string           `yaml:"match,omitempty"`
+	SerializationFormat string           `yaml:"serialization_format,omitempty"`
+	QoS                 byte             `yaml:"qos,omitempty"`
+	Retained            bool             `yaml:"retained,omitempty"`
+	KeepAlive           model.Duration   `yaml:"keep_alive,omitempty"`
+	ConnectTimeout      model.Duration   `yaml:"connect_timeout,omitempty"`
+	BufferSize          int              `yaml:"buffer_size,omitempty"`
+	MaxInflight         int              `yaml:"max_inflight,omitempty"`
+	TLSConfig           config.TLSConfig `yaml:"tls_config,omitempty"`
+}
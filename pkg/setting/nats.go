@@ -0,0 +1,22 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type NatsConfig struct {
+	Servers             string           `yaml:"servers"`
+	Subject             string           `yaml:"subject"`
+	Match               string           `yaml:"match,omitempty"`
+	SerializationFormat string           `yaml:"serialization_format,omitempty"`
+	JetStream           bool             `yaml:"jetstream,omitempty"`
+	Async               bool             `yaml:"async,omitempty"`
+	MaxPending          int              `yaml:"max_pending,omitempty"`
+	AckTimeout          model.Duration   `yaml:"ack_timeout,omitempty"`
+	CredsFile           string           `yaml:"creds_file,omitempty"`
+	Username            string           `yaml:"username,omitempty"`
+	Password            string           `yaml:"password,omitempty"`
+	Token               string           `yaml:"token,omitempty"`
+	TLSConfig           config.TLSConfig `yaml:"tls_config,omitempty"`
+}
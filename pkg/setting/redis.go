@@ -0,0 +1,20 @@
+package setting
+
+import "github.com/prometheus/common/config"
+
+type RedisConfig struct {
+	Addrs               []string         `yaml:"addrs"`
+	MasterName          string           `yaml:"master_name,omitempty"`
+	Cluster             bool             `yaml:"cluster,omitempty"`
+	Username            string           `yaml:"username,omitempty"`
+	Password            string           `yaml:"password,omitempty"`
+	SentinelPassword    string           `yaml:"sentinel_password,omitempty"`
+	DB                  int              `yaml:"db,omitempty"`
+	PoolSize            int              `yaml:"pool_size,omitempty"`
+	Stream              string           `yaml:"stream"`
+	SerializationFormat string           `yaml:"serialization_format,omitempty"`
+	MaxLen              int64            `yaml:"max_len,omitempty"`
+	ApproxMaxLen        bool             `yaml:"approx_max_len,omitempty"`
+	PipelineSize        int              `yaml:"pipeline_size,omitempty"`
+	TLSConfig           config.TLSConfig `yaml:"tls_config,omitempty"`
+}
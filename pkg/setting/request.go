@@ -0,0 +1,10 @@
+package setting
+
+// RequestLimitsConf bounds the remote write request bodies received, in
+// bytes. Larger bodies are refused with 413 before being read or
+// decompressed in full. Zero values use the defaults of 32MiB compressed
+// and 128MiB decompressed.
+type RequestLimitsConf struct {
+	MaxCompressedBytes   int64 `yaml:"max_compressed_bytes,omitempty"`
+	MaxDecompressedBytes int64 `yaml:"max_decompressed_bytes,omitempty"`
+}
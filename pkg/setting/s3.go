@@ -0,0 +1,19 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+type S3Config struct {
+	Endpoint        string         `yaml:"endpoint"`
+	Region          string         `yaml:"region,omitempty"`
+	Bucket          string         `yaml:"bucket"`
+	Prefix          string         `yaml:"prefix,omitempty"`
+	AccessKeyID     string         `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string         `yaml:"secret_access_key,omitempty"`
+	SessionToken    string         `yaml:"session_token,omitempty"`
+	ForcePathStyle  bool           `yaml:"force_path_style,omitempty"`
+	Format          string         `yaml:"format,omitempty"`
+	FlushSize       int64          `yaml:"flush_size,omitempty"`
+	FlushInterval   model.Duration `yaml:"flush_interval,omitempty"`
+	PartSize        int64          `yaml:"part_size,omitempty"`
+	Timeout         model.Duration `yaml:"timeout,omitempty"`
+}
@@ -0,0 +1,41 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+// TenantConf enables tenancy. The tenant of a series is taken from the
+// `/api/v1/write/<tenant>` path, then from Header, then from Label, and
+// falls back to Default. It's exposed to metric_relabel_configs as the
+// __tenant__ pseudo-label, and propagated by remote write upstreams
+// setting tenant_header.
+//
+// Limits apply to every tenant but those of OverridesFile, a YAML file of
+// TenantOverrides reloaded every OverridesReloadInterval. The buckets of a
+// tenant idle for IdleTimeout, 10m by default, are forgotten once full.
+// Throttling metrics are labelled with the tenants of OverridesFile and the
+// first MaxMetricTenants others, 100 by default, the rest as `other`.
+type TenantConf struct {
+	Header                  string           `yaml:"header,omitempty"`
+	Label                   string           `yaml:"label,omitempty"`
+	DropLabel               bool             `yaml:"drop_label,omitempty"`
+	Default                 string           `yaml:"default,omitempty"`
+	Limits                  TenantLimitsConf `yaml:"limits,omitempty"`
+	OverridesFile           string           `yaml:"overrides_file,omitempty"`
+	OverridesReloadInterval model.Duration   `yaml:"overrides_reload_interval,omitempty"`
+	IdleTimeout             model.Duration   `yaml:"idle_timeout,omitempty"`
+	MaxMetricTenants        int              `yaml:"max_metric_tenants,omitempty"`
+}
+
+// TenantLimitsConf rate limits the ingestion of a tenant with token
+// buckets. A zero rate disables its limit; a zero burst allows one second
+// worth of the rate.
+type TenantLimitsConf struct {
+	SamplesPerSecond float64 `yaml:"samples_per_second,omitempty"`
+	SamplesBurst     int     `yaml:"samples_burst,omitempty"`
+	BytesPerSecond   float64 `yaml:"bytes_per_second,omitempty"`
+	BytesBurst       int     `yaml:"bytes_burst,omitempty"`
+}
+
+// TenantOverrides is the content of the tenant overrides file.
+type TenantOverrides struct {
+	Overrides map[string]TenantLimitsConf `yaml:"overrides"`
+}
@@ -0,0 +1,30 @@
+package setting
+
+// UpstreamEndpoint is a remote write URL with its weight on the consistent
+// hash ring, a zero weight counting as 1, and its HTTP client config
+// replacing the one of the route.
+type UpstreamEndpoint struct {
+	URL              string          `yaml:"url"`
+	Weight           int             `yaml:"weight,omitempty"`
+	HTTPClientConfig *HTTPClientConf `yaml:"http_client_config,omitempty"`
+}
+
+// Endpoints returns upstream_urls, each weighing 1, followed by
+// upstream_endpoints, all using the route HTTP client config unless they
+// have their own.
+func (u UpStreamsConf) Endpoints() []UpstreamEndpoint {
+	endpoints := make([]UpstreamEndpoint, 0, len(u.UpstreamUrls)+len(u.UpstreamEndpoints))
+	for _, url := range u.UpstreamUrls {
+		endpoints = append(endpoints, UpstreamEndpoint{URL: url, Weight: 1, HTTPClientConfig: u.HTTPClientConfig})
+	}
+	for _, e := range u.UpstreamEndpoints {
+		if e.Weight == 0 {
+			e.Weight = 1
+		}
+		if e.HTTPClientConfig == nil {
+			e.HTTPClientConfig = u.HTTPClientConfig
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
@@ -0,0 +1,21 @@
+package setting
+
+import "github.com/prometheus/common/model"
+
+// ValidationConf checks received series before routing. Zero values
+// disable the corresponding limit. With Action `reject` a request holding
+// any invalid sample is refused with 400; with `drop`, the default, only
+// the invalid samples are dropped. Stale markers and NaN values are valid
+// and only dropped when asked to, whatever the action.
+type ValidationConf struct {
+	Action                 string         `yaml:"action,omitempty"`
+	MaxSampleAge           model.Duration `yaml:"max_sample_age,omitempty"`
+	MaxFutureSkew          model.Duration `yaml:"max_future_skew,omitempty"`
+	MaxLabelNamesPerSeries int            `yaml:"max_label_names_per_series,omitempty"`
+	MaxLabelNameLength     int            `yaml:"max_label_name_length,omitempty"`
+	MaxLabelValueLength    int            `yaml:"max_label_value_length,omitempty"`
+	ValidateNames          bool           `yaml:"validate_names,omitempty"`
+	RejectDuplicateLabels  bool           `yaml:"reject_duplicate_labels,omitempty"`
+	DropStaleMarkers       bool           `yaml:"drop_stale_markers,omitempty"`
+	DropNaN                bool           `yaml:"drop_nan,omitempty"`
+}
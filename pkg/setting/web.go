@@ -0,0 +1,63 @@
+package setting
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+)
+
+// WebConf is the web config file of the listener, in the format of
+// Prometheus' --web.config.file. Without TLSServerConfig the listener
+// serves plaintext HTTP/1.1 and h2c.
+type WebConf struct {
+	TLSServerConfig  *TLSServerConf `yaml:"tls_server_config,omitempty"`
+	HTTPServerConfig HTTPServerConf `yaml:"http_server_config,omitempty"`
+}
+
+// TLSServerConf terminates TLS. The certificate, key and client CA files
+// are reloaded when they change. ClientAuthType is a Go tls.ClientAuthType
+// name, RequireAndVerifyClientCert by default with a ClientCAFile.
+// MinVersion defaults to TLS12 and CipherSuites, Go cipher suite names,
+// only apply up to TLS 1.2.
+type TLSServerConf struct {
+	CertFile       string            `yaml:"cert_file"`
+	KeyFile        string            `yaml:"key_file"`
+	ClientCAFile   string            `yaml:"client_ca_file,omitempty"`
+	ClientAuthType string            `yaml:"client_auth_type,omitempty"`
+	MinVersion     config.TLSVersion `yaml:"min_version,omitempty"`
+	MaxVersion     config.TLSVersion `yaml:"max_version,omitempty"`
+	CipherSuites   []string          `yaml:"cipher_suites,omitempty"`
+}
+
+// HTTPServerConf turns off HTTP/2, over TLS and h2c, with HTTP2 false.
+type HTTPServerConf struct {
+	HTTP2 *bool `yaml:"http2,omitempty"`
+}
+
+// LoadWebConfig reads a web config file. Relative paths are resolved
+// against its directory.
+func LoadWebConfig(filename string) (*WebConf, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &WebConf{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
+	}
+	if tlsCfg := cfg.TLSServerConfig; tlsCfg != nil {
+		if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+			return nil, fmt.Errorf("%s: tls_server_config requires cert_file and key_file", filename)
+		}
+		dir := filepath.Dir(filename)
+		for _, file := range []*string{&tlsCfg.CertFile, &tlsCfg.KeyFile, &tlsCfg.ClientCAFile} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(dir, *file)
+			}
+		}
+	}
+	return cfg, nil
+}
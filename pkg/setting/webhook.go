@@ -0,0 +1,28 @@
+package setting
+
+import (
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+type WebhookConfig struct {
+	URL           string             `yaml:"url"`
+	Method        string             `yaml:"method,omitempty"`
+	Headers       map[string]string  `yaml:"headers,omitempty"`
+	ContentType   string             `yaml:"content_type,omitempty"`
+	BodyTemplate  string             `yaml:"body_template,omitempty"`
+	Gzip          bool               `yaml:"gzip,omitempty"`
+	BatchSize     int                `yaml:"batch_size,omitempty"`
+	FlushInterval model.Duration     `yaml:"flush_interval,omitempty"`
+	Concurrency   int                `yaml:"concurrency,omitempty"`
+	Timeout       model.Duration     `yaml:"timeout,omitempty"`
+	RetryPolicy   WebhookRetryPolicy `yaml:"retry_policy,omitempty"`
+	TLSConfig     config.TLSConfig   `yaml:"tls_config,omitempty"`
+}
+
+type WebhookRetryPolicy struct {
+	MaxRetries    *int           `yaml:"max_retries,omitempty"`
+	MinBackoff    model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff    model.Duration `yaml:"max_backoff,omitempty"`
+	RetryOnStatus []int          `yaml:"retry_on_status,omitempty"`
+}
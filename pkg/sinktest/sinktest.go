@@ -0,0 +1,119 @@
+package sinktest
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Request is a request received by a Server, with its body decompressed.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Handler answers a request with a status and a body. A Server calls it for
+// one request at a time.
+type Handler func(r Request) (int, string)
+
+// Statuses answers the first requests with statuses in turn, then with 204.
+func Statuses(statuses ...int) Handler {
+	return func(Request) (int, string) {
+		if len(statuses) == 0 {
+			return http.StatusNoContent, ""
+		}
+		status := statuses[0]
+		statuses = statuses[1:]
+		return status, http.StatusText(status)
+	}
+}
+
+// Server is an HTTP stand-in for the upstreams of the sinks, recording every
+// request.
+type Server struct {
+	*httptest.Server
+	lock     sync.Mutex
+	handler  Handler
+	requests []Request
+}
+
+// NewServer starts a Server closed once the test finished.
+func NewServer(t *testing.T, handler Handler) *Server {
+	s := &Server{handler: handler}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header, Body: data}
+		s.lock.Lock()
+		s.requests = append(s.requests, req)
+		status, resp := s.handler(req)
+		s.lock.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// WaitRequests returns the requests received once there are at least n.
+func (s *Server) WaitRequests(t *testing.T, n int) []Request {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if requests := s.Requests(); len(requests) >= n {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d requests", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Series returns a series named name with the label name and value pairs.
+func Series(name string, labels []string, samples ...prompb.Sample) prompb.TimeSeries {
+	ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: name}}, Samples: samples}
+	for i := 0; i+1 < len(labels); i += 2 {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return ts
+}
+
+// ConfigErrors fails the test unless newSink refuses every config.
+func ConfigErrors[C any](t *testing.T, newSink func(C) error, cfgs ...C) {
+	t.Helper()
+	for _, cfg := range cfgs {
+		if err := newSink(cfg); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
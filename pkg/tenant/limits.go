@@ -0,0 +1,266 @@
+package tenant
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	defaultOverridesReloadInterval = 10 * time.Second
+	defaultIdleTimeout             = 10 * time.Minute
+	defaultMaxMetricTenants        = 100
+)
+
+// otherTenant labels the metrics of the tenants past max_metric_tenants.
+const otherTenant = "other"
+
+// Limits rate limits tenants with token buckets on samples and bytes.
+type Limits struct {
+	defaults         setting.TenantLimitsConf
+	maxMetricTenants int
+
+	lock          sync.Mutex
+	overrides     map[string]setting.TenantLimitsConf
+	tenants       map[string]*tenantBuckets
+	metricTenants map[string]struct{}
+}
+
+type tenantBuckets struct {
+	limits   setting.TenantLimitsConf
+	samples  *bucket
+	bytes    *bucket
+	lastSeen time.Time
+}
+
+// NewLimits returns nil, limiting nothing, when cfg sets neither limits
+// nor an overrides file. The overrides file must load at startup; later
+// reload failures keep the previous overrides.
+func NewLimits(cfg *setting.TenantConf) (*Limits, error) {
+	if cfg == nil || (cfg.Limits == setting.TenantLimitsConf{} && cfg.OverridesFile == "") {
+		return nil, nil
+	}
+	l := &Limits{
+		defaults:         cfg.Limits,
+		maxMetricTenants: cfg.MaxMetricTenants,
+		tenants:          make(map[string]*tenantBuckets),
+		metricTenants:    make(map[string]struct{}),
+	}
+	if l.maxMetricTenants <= 0 {
+		l.maxMetricTenants = defaultMaxMetricTenants
+	}
+	if cfg.OverridesFile != "" {
+		if err := l.loadOverrides(cfg.OverridesFile); err != nil {
+			return nil, err
+		}
+	}
+	idleTimeout := time.Duration(cfg.IdleTimeout)
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	go func() {
+		for now := range time.Tick(idleTimeout) {
+			l.evict(now, idleTimeout)
+		}
+	}()
+	if cfg.OverridesFile == "" {
+		return l, nil
+	}
+	interval := time.Duration(cfg.OverridesReloadInterval)
+	if interval <= 0 {
+		interval = defaultOverridesReloadInterval
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := l.loadOverrides(cfg.OverridesFile); err != nil {
+				defaultTelemetry.Logger.Error("tenant overrides reload error", "file", cfg.OverridesFile, "err", err)
+			}
+		}
+	}()
+	return l, nil
+}
+
+func (l *Limits) loadOverrides(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var overrides setting.TenantOverrides
+	if err := yaml.UnmarshalStrict(b, &overrides); err != nil {
+		return fmt.Errorf("parsing tenant overrides %s: %v", file, err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !reflect.DeepEqual(l.overrides, overrides.Overrides) {
+		defaultTelemetry.Logger.Info("tenant overrides loaded", "file", file, "tenants", len(overrides.Overrides))
+	}
+	l.overrides = overrides.Overrides
+	return nil
+}
+
+// Allow takes the samples and bytes of every tenant group from their
+// buckets. When a tenant is over its limits nothing is taken, and Allow
+// returns false with the delay after which a retry may succeed.
+func (l *Limits) Allow(groups map[string][]prompb.TimeSeries) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	done := make([]taken, 0, len(groups))
+	for id, tss := range groups {
+		tb := l.buckets(id, now)
+		samples, bytes := count(tss)
+		if wait := tb.samples.take(samples, now); wait > 0 {
+			tenantThrottledSamples.WithLabelValues(l.metricTenant(id), "samples").Add(samples)
+			refund(done)
+			return false, wait
+		}
+		if wait := tb.bytes.take(bytes, now); wait > 0 {
+			tb.samples.refund(samples)
+			tenantThrottledSamples.WithLabelValues(l.metricTenant(id), "bytes").Add(samples)
+			refund(done)
+			return false, wait
+		}
+		done = append(done, taken{tb, samples, bytes})
+	}
+	return true, 0
+}
+
+// taken is what a tenant group took from its buckets, refunded when a
+// later group of the request is throttled.
+type taken struct {
+	tb             *tenantBuckets
+	samples, bytes float64
+}
+
+func refund(done []taken) {
+	for _, t := range done {
+		t.tb.samples.refund(t.samples)
+		t.tb.bytes.refund(t.bytes)
+	}
+}
+
+// buckets returns the buckets of a tenant seen at now, recreating them
+// when its limits changed.
+func (l *Limits) buckets(id string, now time.Time) *tenantBuckets {
+	limits, ok := l.overrides[id]
+	if !ok {
+		limits = l.defaults
+	}
+	tb, ok := l.tenants[id]
+	if !ok || tb.limits != limits {
+		tb = &tenantBuckets{
+			limits:  limits,
+			samples: newBucket(limits.SamplesPerSecond, limits.SamplesBurst),
+			bytes:   newBucket(limits.BytesPerSecond, limits.BytesBurst),
+		}
+		l.tenants[id] = tb
+		tenantLimitedTenants.Set(float64(len(l.tenants)))
+	}
+	tb.lastSeen = now
+	return tb
+}
+
+// evict forgets the buckets of the tenants idle for idleTimeout once they
+// are full, as new ones would be.
+func (l *Limits) evict(now time.Time, idleTimeout time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for id, tb := range l.tenants {
+		if now.Sub(tb.lastSeen) >= idleTimeout && tb.samples.full(now) && tb.bytes.full(now) {
+			delete(l.tenants, id)
+		}
+	}
+	tenantLimitedTenants.Set(float64(len(l.tenants)))
+}
+
+// metricTenant returns the tenant label of the metrics of id. Tenant IDs
+// come from clients, so only those of the overrides file and the first
+// max_metric_tenants others get their own series.
+func (l *Limits) metricTenant(id string) string {
+	if _, ok := l.overrides[id]; ok {
+		return id
+	}
+	if _, ok := l.metricTenants[id]; ok {
+		return id
+	}
+	if len(l.metricTenants) < l.maxMetricTenants {
+		l.metricTenants[id] = struct{}{}
+		return id
+	}
+	return otherTenant
+}
+
+func count(tss []prompb.TimeSeries) (samples, bytes float64) {
+	for _, ts := range tss {
+		samples += float64(len(ts.Samples) + len(ts.Histograms))
+		bytes += float64(ts.Size())
+	}
+	return samples, bytes
+}
+
+// bucket is a token bucket which may go into debt: a request larger than
+// the burst passes once the bucket is full, and the following ones wait
+// for the debt to be paid back.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns nil, allowing everything, for a zero rate.
+func newBucket(rate float64, burst int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(rate, 1)
+	}
+	return &bucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// take takes n tokens, or returns how long to wait for them. The wait of
+// a refused take is at least a nanosecond, as a zero wait means taken.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	need := math.Min(n, b.burst)
+	if b.tokens < need {
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		if wait < time.Nanosecond {
+			wait = time.Nanosecond
+		}
+		return wait
+	}
+	b.tokens -= n
+	return 0
+}
+
+// full reports whether the bucket is full at now, allowing as much as a
+// new one.
+func (b *bucket) full(now time.Time) bool {
+	return b == nil || b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+func (b *bucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
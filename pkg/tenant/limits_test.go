@@ -0,0 +1,107 @@
+package tenant_test
+
+import (
+	"os"
+	"path/filepath"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func samples(n int) []prompb.TimeSeries {
+	ts := series("__name__", "up")
+	for i := 0; i < n; i++ {
+		ts.Samples = append(ts.Samples, prompb.Sample{Value: 1, Timestamp: int64(i)})
+	}
+	return []prompb.TimeSeries{ts}
+}
+
+func TestLimits(t *testing.T) {
+	if l, err := tenant.NewLimits(&setting.TenantConf{Header: "X-Scope-OrgID"}); l != nil || err != nil {
+		t.Fatal("expected no limits without config")
+	}
+
+	file := filepath.Join(t.TempDir(), "overrides.yaml")
+	if err := os.WriteFile(file, []byte("overrides:\n  big:\n    samples_per_second: 1000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := tenant.NewLimits(&setting.TenantConf{
+		Limits:                  setting.TenantLimitsConf{SamplesPerSecond: 10, BytesPerSecond: 1 << 20},
+		OverridesFile:           file,
+		OverridesReloadInterval: model.Duration(50 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"a": samples(10)}); !ok {
+		t.Fatal("expected the burst to pass")
+	}
+	ok, wait := l.Allow(map[string][]prompb.TimeSeries{"a": samples(5)})
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("expected a to be throttled for about half a second, got %v %v", ok, wait)
+	}
+	// A throttled tenant refunds what the other tenants of the request took.
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"b": samples(10), "a": samples(5)}); ok {
+		t.Fatal("expected the request to be throttled")
+	}
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"b": samples(10)}); !ok {
+		t.Fatal("expected b to have been refunded")
+	}
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"big": samples(500)}); !ok {
+		t.Fatal("expected the override to apply")
+	}
+
+	if err := os.WriteFile(file, []byte("overrides:\n  a:\n    samples_per_second: 1000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"a": samples(500)}); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the overrides reload")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := tenant.NewLimits(&setting.TenantConf{OverridesFile: filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Fatal("expected an error for a missing overrides file")
+	}
+}
+
+func TestLimitsIdleTenants(t *testing.T) {
+	l, err := tenant.NewLimits(&setting.TenantConf{
+		Limits:      setting.TenantLimitsConf{SamplesPerSecond: 10},
+		IdleTimeout: model.Duration(20 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A request larger than the burst leaves the bucket ten seconds in
+	// debt, which outlives the idle timeout.
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"a": samples(110)}); !ok {
+		t.Fatal("expected a full bucket to pass")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"a": samples(1)}); ok {
+		t.Fatal("expected the debt to be kept while the bucket isn't full")
+	}
+	// Tenants idle with full buckets are forgotten without changing their
+	// limits.
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"b": samples(1)}); !ok {
+		t.Fatal("expected b to pass")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"b": samples(10)}); !ok {
+		t.Fatal("expected b to pass after being idle")
+	}
+	if ok, _ := l.Allow(map[string][]prompb.TimeSeries{"b": samples(1)}); ok {
+		t.Fatal("expected b to be throttled")
+	}
+}
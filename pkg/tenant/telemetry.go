@@ -0,0 +1,32 @@
+package tenant
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_tenant"
+
+var (
+	tenantThrottledSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "throttled_samples_total",
+			Help:      "Count of samples refused because a tenant exceeded its rate limit",
+		}, []string{"tenant", "limit"})
+	tenantLimitedTenants = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "limited_tenants",
+			Help:      "Number of tenants whose rate limit buckets are tracked",
+		})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(tenantThrottledSamples)
+	defaultTelemetry.Register(tenantLimitedTenants)
+}
@@ -0,0 +1,103 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"stream-metrics-route/pkg/setting"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Label is the pseudo-label holding the tenant of a series during
+// relabeling. It's never sent upstream.
+const Label = "__tenant__"
+
+type contextKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant id.
+func WithTenant(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant carried by ctx, if any.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Resolver extracts the tenant of requests and series.
+type Resolver struct {
+	header    string
+	label     string
+	dropLabel bool
+	def       string
+}
+
+// New returns nil, resolving no tenant, when cfg is nil.
+func New(cfg *setting.TenantConf) *Resolver {
+	if cfg == nil {
+		return nil
+	}
+	return &Resolver{
+		header:    cfg.Header,
+		label:     cfg.Label,
+		dropLabel: cfg.DropLabel,
+		def:       cfg.Default,
+	}
+}
+
+// FromRequest returns the tenant of a whole request: pathTenant, taken
+// from the URL path, or else the configured header.
+func (r *Resolver) FromRequest(req *http.Request, pathTenant string) string {
+	if r == nil {
+		return ""
+	}
+	if pathTenant != "" {
+		return pathTenant
+	}
+	if r.header != "" {
+		return req.Header.Get(r.header)
+	}
+	return ""
+}
+
+// Split groups tss by tenant. Series get the request tenant id, or else
+// the value of the tenant label, or else the default tenant. The tenant
+// label is removed from the series when configured so.
+func (r *Resolver) Split(id string, tss []prompb.TimeSeries) map[string][]prompb.TimeSeries {
+	if r == nil {
+		return map[string][]prompb.TimeSeries{id: tss}
+	}
+	if id == "" && r.label == "" {
+		id = r.def
+	}
+	if r.label == "" || (id != "" && !r.dropLabel) {
+		return map[string][]prompb.TimeSeries{id: tss}
+	}
+	groups := make(map[string][]prompb.TimeSeries)
+	for _, ts := range tss {
+		seriesID := id
+		for i, l := range ts.Labels {
+			if l.Name != r.label {
+				continue
+			}
+			if seriesID == "" {
+				seriesID = l.Value
+			}
+			if r.dropLabel {
+				lbs := make([]prompb.Label, 0, len(ts.Labels)-1)
+				lbs = append(lbs, ts.Labels[:i]...)
+				ts.Labels = append(lbs, ts.Labels[i+1:]...)
+			}
+			break
+		}
+		if seriesID == "" {
+			seriesID = r.def
+		}
+		groups[seriesID] = append(groups[seriesID], ts)
+	}
+	return groups
+}
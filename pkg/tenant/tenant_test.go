@@ -0,0 +1,63 @@
+package tenant_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/tenant"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func series(kv ...string) prompb.TimeSeries {
+	var lbs []prompb.Label
+	for i := 0; i < len(kv); i += 2 {
+		lbs = append(lbs, prompb.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return prompb.TimeSeries{Labels: lbs}
+}
+
+func TestResolver(t *testing.T) {
+	if tenant.FromContext(context.Background()) != "" || tenant.FromContext(tenant.WithTenant(context.Background(), "a")) != "a" {
+		t.Fatal("unexpected context tenant")
+	}
+
+	var disabled *tenant.Resolver
+	req := httptest.NewRequest("POST", "/api/v1/write", nil)
+	req.Header.Set("X-Scope-OrgID", "team-a")
+	if id := disabled.FromRequest(req, "team-b"); id != "" {
+		t.Fatalf("expected no tenant without config, got %q", id)
+	}
+
+	r := tenant.New(&setting.TenantConf{Header: "X-Scope-OrgID", Label: "team", DropLabel: true, Default: "anonymous"})
+	if id := r.FromRequest(req, "team-b"); id != "team-b" {
+		t.Fatalf("expected the path tenant first, got %q", id)
+	}
+	if id := r.FromRequest(req, ""); id != "team-a" {
+		t.Fatalf("expected the header tenant, got %q", id)
+	}
+
+	tss := []prompb.TimeSeries{
+		series("__name__", "up", "team", "a"),
+		series("__name__", "up", "team", "b"),
+		series("__name__", "up"),
+	}
+	groups := r.Split("", tss)
+	if len(groups) != 3 || len(groups["a"]) != 1 || len(groups["b"]) != 1 || len(groups["anonymous"]) != 1 {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+	if len(groups["a"][0].Labels) != 1 || tss[0].Labels[1].Name != "team" {
+		t.Fatal("expected the tenant label dropped from a copy of the series")
+	}
+	// The request tenant wins over labels, which are still dropped.
+	groups = r.Split("c", tss)
+	if len(groups) != 1 || len(groups["c"]) != 3 || len(groups["c"][1].Labels) != 1 {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+
+	keep := tenant.New(&setting.TenantConf{Label: "team"})
+	if groups := keep.Split("", tss); len(groups) != 3 || len(groups[""]) != 1 || len(groups["a"][0].Labels) != 2 {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+}
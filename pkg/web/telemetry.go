@@ -0,0 +1,25 @@
+package web
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_web"
+
+var (
+	tlsReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "tls_reloads_total",
+			Help:      "Count of TLS certificate and client CA reloads by result",
+		}, []string{"result"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(tlsReloads)
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"stream-metrics-route/pkg/setting"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// ListenAndServe serves handler on addr as configured by cfg, which may
+// be nil.
+func ListenAndServe(addr string, handler http.Handler, cfg *setting.WebConf) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l, handler, cfg)
+}
+
+// Serve serves handler on l over TLS when cfg configures it, and HTTP/2
+// unless turned off: negotiated with ALPN over TLS, or h2c in plaintext.
+func Serve(l net.Listener, handler http.Handler, cfg *setting.WebConf) error {
+	if cfg == nil {
+		cfg = &setting.WebConf{}
+	}
+	enableHTTP2 := cfg.HTTPServerConfig.HTTP2 == nil || *cfg.HTTPServerConfig.HTTP2
+	srv := &http.Server{Handler: handler}
+	if cfg.TLSServerConfig == nil {
+		if enableHTTP2 {
+			srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		return srv.Serve(l)
+	}
+	tlsConfig, err := NewTLSConfig(cfg.TLSServerConfig)
+	if err != nil {
+		return err
+	}
+	if enableHTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	} else {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	srv.TLSConfig = tlsConfig
+	return srv.ServeTLS(l, "", "")
+}
+
+// NewTLSConfig returns a server TLS config which reloads the certificate,
+// key and client CA files when they change.
+func NewTLSConfig(cfg *setting.TLSServerConf) (*tls.Config, error) {
+	clientAuth := tls.NoClientCert
+	if cfg.ClientCAFile != "" {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.ClientAuthType != "" {
+		var ok bool
+		if clientAuth, ok = clientAuthTypes[cfg.ClientAuthType]; !ok {
+			return nil, fmt.Errorf("unknown client_auth_type %q", cfg.ClientAuthType)
+		}
+	}
+	if cfg.ClientCAFile == "" && (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("client_auth_type %s requires a client_ca_file", cfg.ClientAuthType)
+	}
+	minVersion := uint16(cfg.MinVersion)
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	maxVersion := uint16(cfg.MaxVersion)
+	if maxVersion != 0 && maxVersion < minVersion {
+		return nil, fmt.Errorf("max_version must not be lower than min_version")
+	}
+	var cipherSuites []uint16
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuiteIDs()[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	r := &reloader{cfg: cfg, base: &tls.Config{
+		MinVersion:   minVersion,
+		MaxVersion:   maxVersion,
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+	}}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.server = &tls.Config{
+		MinVersion:         minVersion,
+		MaxVersion:         maxVersion,
+		GetConfigForClient: r.getConfigForClient,
+		// Only checked by http.Server.ServeTLS to find a certificate.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			return &r.config.Certificates[0], nil
+		},
+	}
+	return r.server, nil
+}
+
+func cipherSuiteIDs() map[string]uint16 {
+	ids := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		ids[cs.Name] = cs.ID
+	}
+	return ids
+}
+
+// reloader builds the TLS config of every handshake, reloading its files
+// once they changed. The server config only sets the protocols offered.
+type reloader struct {
+	cfg    *setting.TLSServerConf
+	base   *tls.Config
+	server *tls.Config
+
+	lock    sync.Mutex
+	modTime map[string]time.Time
+	config  *tls.Config
+}
+
+func (r *reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.changed() {
+		if err := r.reloadLocked(); err != nil {
+			tlsReloads.WithLabelValues("failure").Inc()
+			defaultTelemetry.Logger.Error("TLS reload error, keeping the previous certificate", "err", err)
+		} else {
+			tlsReloads.WithLabelValues("success").Inc()
+			defaultTelemetry.Logger.Info("TLS certificate reloaded", "cert_file", r.cfg.CertFile)
+		}
+	}
+	config := r.config.Clone()
+	config.NextProtos = r.server.NextProtos
+	return config, nil
+}
+
+func (r *reloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// changed reports whether a file was modified since the last reload. A
+// failed reload is retried on the next change only.
+func (r *reloader) changed() bool {
+	for _, file := range r.files() {
+		fi, err := os.Stat(file)
+		if err == nil && !fi.ModTime().Equal(r.modTime[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reloader) reloadLocked() error {
+	modTime := make(map[string]time.Time)
+	for _, file := range r.files() {
+		if fi, err := os.Stat(file); err == nil {
+			modTime[file] = fi.ModTime()
+		}
+	}
+	r.modTime = modTime
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := r.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+	if r.cfg.ClientCAFile != "" {
+		b, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate found in %s", r.cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	r.config = config
+	return nil
+}
@@ -0,0 +1,204 @@
+package web_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/web"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"golang.org/x/net/http2"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key,
+// and returns the certificate.
+func writeCert(t *testing.T, dir string, serial int64, modTime time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "stream-metrics-route"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: der},
+		"key.pem":  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// serve serves a handler answering with the protocol of requests, and
+// returns its address.
+func serve(t *testing.T, cfg *setting.WebConf) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go web.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}), cfg)
+	return l.Addr().String()
+}
+
+func get(client *http.Client, url string) (*http.Response, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+func tlsClient(cert *x509.Certificate, tlsConfig *tls.Config) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	tlsConfig.RootCAs = roots
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	cert := writeCert(t, dir, 1, modTime)
+	addr := serve(t, &setting.WebConf{TLSServerConfig: &setting.TLSServerConf{
+		CertFile:   filepath.Join(dir, "cert.pem"),
+		KeyFile:    filepath.Join(dir, "key.pem"),
+		MinVersion: config.TLSVersion(tls.VersionTLS13),
+	}})
+
+	resp, err := get(tlsClient(cert, &tls.Config{}), "https://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 || resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 1 {
+		t.Fatalf("expected HTTP/2 with the first certificate, got %s and serial %v", resp.Proto, resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+	if _, err := get(tlsClient(cert, &tls.Config{MaxVersion: tls.VersionTLS12}), "https://"+addr); err == nil {
+		t.Fatal("expected TLS 1.2 to be refused")
+	}
+
+	// New connections get the certificate once its files changed.
+	rotated := writeCert(t, dir, 2, time.Now())
+	resp, err = get(tlsClient(rotated, &tls.Config{}), "https://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Fatalf("expected the reloaded certificate, got serial %d", serial)
+	}
+}
+
+func TestServeClientCA(t *testing.T) {
+	dir := t.TempDir()
+	cert := writeCert(t, dir, 1, time.Now())
+	key, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled := false
+	addr := serve(t, &setting.WebConf{
+		TLSServerConfig: &setting.TLSServerConf{
+			CertFile:     filepath.Join(dir, "cert.pem"),
+			KeyFile:      filepath.Join(dir, "key.pem"),
+			ClientCAFile: filepath.Join(dir, "cert.pem"),
+		},
+		HTTPServerConfig: setting.HTTPServerConf{HTTP2: &disabled},
+	})
+
+	if _, err := get(tlsClient(cert, &tls.Config{}), "https://"+addr); err == nil {
+		t.Fatal("expected a client without certificate to be refused")
+	}
+	resp, err := get(tlsClient(cert, &tls.Config{Certificates: []tls.Certificate{key}}), "https://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 1 {
+		t.Fatalf("expected HTTP/1.1 with http2 disabled, got %s", resp.Proto)
+	}
+}
+
+func TestServeH2C(t *testing.T) {
+	addr := serve(t, nil)
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := get(client, "http://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected h2c, got %s", resp.Proto)
+	}
+	resp, err = get(http.DefaultClient, "http://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 1 {
+		t.Fatalf("expected HTTP/1.1, got %s", resp.Proto)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, 1, time.Now())
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for _, cfg := range []*setting.TLSServerConf{
+		{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")},
+		{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "RequireAndVerifyClientCert"},
+		{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "Always"},
+		{CertFile: certFile, KeyFile: keyFile, MinVersion: config.TLSVersion(tls.VersionTLS13), MaxVersion: config.TLSVersion(tls.VersionTLS12)},
+	} {
+		if _, err := web.NewTLSConfig(cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+	if _, err := web.NewTLSConfig(&setting.TLSServerConf{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+package webhookclient
+
+import (
+	"stream-metrics-route/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultTelemetry telemetry.Telemetry
+
+var metricNamespace string = "stream_webhook"
+
+var (
+	webhookTimeseries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "timeseries_total",
+			Help:      "Count of handle timeseries total",
+		}, []string{"route_name"})
+	webhookRequestsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "requests_sent_total",
+			Help:      "Count of all webhook requests accepted by the receiver",
+		}, []string{"route_name"})
+	webhookRequestsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "requests_failed_total",
+			Help:      "Count of all webhook batches that couldn't be rendered or delivered",
+		}, []string{"route_name"})
+)
+
+func init() {
+	defaultTelemetry = telemetry.NewTelemetry()
+	defaultTelemetry.Register(webhookTimeseries)
+	defaultTelemetry.Register(webhookRequestsSent)
+	defaultTelemetry.Register(webhookRequestsFailed)
+}
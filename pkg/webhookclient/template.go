@@ -0,0 +1,87 @@
+package webhookclient
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"stream-metrics-route/pkg/serialize"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// defaultBodyTemplate posts the batch as a JSON array of series.
+const defaultBodyTemplate = "{{ json .Series }}"
+
+// Batch is the data a body template is rendered with.
+type Batch struct {
+	RouteName string   `json:"route_name"`
+	Series    []Series `json:"series"`
+}
+
+type Series struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels"`
+	Samples []Sample          `json:"samples"`
+}
+
+type Sample struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// MarshalJSON writes NaN and Inf, which encoding/json refuses, as strings
+// the same way the Prometheus HTTP API does.
+func (s Sample) MarshalJSON() ([]byte, error) {
+	value := strconv.FormatFloat(s.Value, 'g', -1, 64)
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		value = strconv.Quote(value)
+	}
+	return []byte(`{"timestamp":` + strconv.FormatInt(s.Timestamp, 10) + `,"value":` + value + `}`), nil
+}
+
+func newSeries(ts prompb.TimeSeries) Series {
+	s := Series{Labels: make(map[string]string, len(ts.Labels)), Samples: make([]Sample, 0, len(ts.Samples))}
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			s.Name = l.Value
+			continue
+		}
+		s.Labels[l.Name] = l.Value
+	}
+	for _, sample := range ts.Samples {
+		s.Samples = append(s.Samples, Sample{Timestamp: sample.Timestamp, Value: sample.Value})
+	}
+	return s
+}
+
+// ParseBodyTemplate parses a request body template. On top of the topic
+// template helpers it offers:
+//
+//	json       marshals any value to JSON
+//	rfc3339    formats a millisecond timestamp as RFC 3339 in UTC
+//	sortedKeys returns the keys of a label map in order
+func ParseBodyTemplate(tpl string) (*template.Template, error) {
+	if tpl == "" {
+		tpl = defaultBodyTemplate
+	}
+	return template.New("body").Funcs(serialize.TemplateFuncMap()).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"rfc3339": func(ts int64) string {
+			return time.UnixMilli(ts).UTC().Format(time.RFC3339Nano)
+		},
+		"sortedKeys": func(m map[string]string) []string {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+	}).Parse(tpl)
+}
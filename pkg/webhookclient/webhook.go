@@ -0,0 +1,215 @@
+package webhookclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"stream-metrics-route/pkg/batcher"
+	"stream-metrics-route/pkg/setting"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultConcurrency   = 2
+	defaultTimeout       = 10 * time.Second
+	defaultMaxRetries    = 3
+	defaultMinBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff    = 5 * time.Second
+)
+
+const maxErrMsgLen = 1024
+
+// WebhookClient batches routed series and sends each batch as one request
+// whose body is rendered from a text/template.
+type WebhookClient struct {
+	name        string
+	url         string
+	method      string
+	headers     map[string]string
+	contentType string
+	gzip        bool
+	timeout     time.Duration
+	maxRetries  int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	retryOn     map[int]bool
+	body        *template.Template
+	client      *http.Client
+	batcher     *batcher.Batcher[Series]
+}
+
+func NewWebhook(name string, cfg setting.WebhookConfig) (*WebhookClient, error) {
+	if _, err := url.Parse(cfg.URL); err != nil || cfg.URL == "" {
+		return nil, fmt.Errorf("couldn't parse the webhook url %q", cfg.URL)
+	}
+	body, err := ParseBodyTemplate(cfg.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the webhook body template: %v", err)
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	cfg.Method = strings.ToUpper(cfg.Method)
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+
+	httpConfig := config.DefaultHTTPClientConfig
+	httpConfig.TLSConfig = cfg.TLSConfig
+	httpClient, err := config.NewClientFromConfig(httpConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WebhookClient{
+		name:        name,
+		url:         cfg.URL,
+		method:      cfg.Method,
+		headers:     cfg.Headers,
+		contentType: cfg.ContentType,
+		gzip:        cfg.Gzip,
+		timeout:     time.Duration(cfg.Timeout),
+		maxRetries:  defaultMaxRetries,
+		minBackoff:  time.Duration(cfg.RetryPolicy.MinBackoff),
+		maxBackoff:  time.Duration(cfg.RetryPolicy.MaxBackoff),
+		retryOn:     make(map[int]bool),
+		body:        body,
+		client:      httpClient,
+	}
+	if w.timeout <= 0 {
+		w.timeout = defaultTimeout
+	}
+	if cfg.RetryPolicy.MaxRetries != nil {
+		if *cfg.RetryPolicy.MaxRetries < 0 {
+			return nil, fmt.Errorf("webhook max_retries must not be negative")
+		}
+		w.maxRetries = *cfg.RetryPolicy.MaxRetries
+	}
+	if w.minBackoff <= 0 {
+		w.minBackoff = defaultMinBackoff
+	}
+	if w.maxBackoff < w.minBackoff {
+		w.maxBackoff = defaultMaxBackoff
+		if w.maxBackoff < w.minBackoff {
+			w.maxBackoff = w.minBackoff
+		}
+	}
+	for _, code := range cfg.RetryPolicy.RetryOnStatus {
+		w.retryOn[code] = true
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	w.batcher = batcher.New(cfg.BatchSize, flushInterval, cfg.Concurrency, w.send)
+	defaultTelemetry.Logger.Debug("create webhook client", "name", name, "url", cfg.URL, "method", cfg.Method)
+	return w, nil
+}
+
+func (w *WebhookClient) Store(ctx context.Context, req []prompb.TimeSeries) (int, error) {
+	defer ctx.Done()
+	webhookTimeseries.WithLabelValues(w.name).Add(float64(len(req)))
+	series := make([]Series, 0, len(req))
+	for _, ts := range req {
+		series = append(series, newSeries(ts))
+	}
+	w.batcher.Add(series...)
+	return 0, nil
+}
+
+// Close sends the buffered series and waits for the requests in flight.
+func (w *WebhookClient) Close() error {
+	w.batcher.Close()
+	return nil
+}
+
+func (w *WebhookClient) send(series []Series) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var gz *gzip.Writer
+	if w.gzip {
+		gz = gzip.NewWriter(&buf)
+		out = gz
+	}
+	if err := w.body.Execute(out, Batch{RouteName: w.name, Series: series}); err != nil {
+		webhookRequestsFailed.WithLabelValues(w.name).Inc()
+		defaultTelemetry.Logger.Error("webhook template error", "name", w.name, "err", err)
+		return
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	body := buf.Bytes()
+
+	ctx, cancel := context.WithTimeout(context.Background(), (w.timeout+w.maxBackoff)*time.Duration(w.maxRetries+1))
+	defer cancel()
+	err := batcher.Retry(ctx, w.maxRetries, w.minBackoff, w.maxBackoff, func() (bool, error) {
+		return w.do(ctx, body)
+	})
+	if err != nil {
+		webhookRequestsFailed.WithLabelValues(w.name).Inc()
+		defaultTelemetry.Logger.Error("webhook send error", "name", w.name, "series", len(series), "err", err)
+		return
+	}
+	webhookRequestsSent.WithLabelValues(w.name).Inc()
+}
+
+// do sends one request and reports whether a failure is worth retrying:
+// network errors always are, statuses are when listed in retry_on_status
+// or, without that list, for 5xx and 429.
+func (w *WebhookClient) do(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", w.contentType)
+	req.Header.Set("User-Agent", "stream-metrics-route")
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+	line := ""
+	if scanner.Scan() {
+		line = scanner.Text()
+	}
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	if len(w.retryOn) > 0 {
+		return w.retryOn[resp.StatusCode], err
+	}
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,126 @@
+package webhookclient_test
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"stream-metrics-route/pkg/setting"
+	"stream-metrics-route/pkg/sinktest"
+	"stream-metrics-route/pkg/webhookclient"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		sinktest.Series("up", []string{"job", "node"}, prompb.Sample{Value: 1, Timestamp: 1700000000123}),
+		sinktest.Series("temp", []string{"room", "lab"}, prompb.Sample{Value: math.NaN(), Timestamp: 1700000000456}),
+	}
+}
+
+func TestWebhookDefaultTemplate(t *testing.T) {
+	s := sinktest.NewServer(t, sinktest.Statuses(http.StatusServiceUnavailable))
+	w, err := webhookclient.NewWebhook("test", setting.WebhookConfig{
+		URL:           s.URL,
+		Headers:       map[string]string{"X-Api-Key": "secret"},
+		Gzip:          true,
+		BatchSize:     2,
+		FlushInterval: model.Duration(time.Minute),
+		RetryPolicy:   setting.WebhookRetryPolicy{MinBackoff: model.Duration(10 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Store(context.Background(), testSeries()); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.WaitRequests(t, 2)
+	want := `[{"name":"up","labels":{"job":"node"},"samples":[{"timestamp":1700000000123,"value":1}]},` +
+		`{"name":"temp","labels":{"room":"lab"},"samples":[{"timestamp":1700000000456,"value":"NaN"}]}]`
+	if string(requests[1].Body) != want {
+		t.Fatalf("body = %s\nwant %s", requests[1].Body, want)
+	}
+	r := requests[1]
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "secret" {
+		t.Fatalf("unexpected request %s %v", r.Method, r.Header)
+	}
+}
+
+func TestWebhookCustomTemplate(t *testing.T) {
+	s := sinktest.NewServer(t, sinktest.Statuses(http.StatusBadRequest))
+	w, err := webhookclient.NewWebhook("test", setting.WebhookConfig{
+		URL:           s.URL,
+		Method:        "put",
+		ContentType:   "text/plain",
+		BodyTemplate:  `{{range $s := .Series}}{{.Name}}{{range $k := sortedKeys .Labels}},{{$k}}={{index $s.Labels $k | replace "o" "0"}}{{end}}{{range .Samples}} {{rfc3339 .Timestamp}}{{end}}{{"\n"}}{{end}}`,
+		BatchSize:     1,
+		FlushInterval: model.Duration(time.Minute),
+		RetryPolicy:   setting.WebhookRetryPolicy{RetryOnStatus: []int{http.StatusConflict}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Store(context.Background(), testSeries()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.WaitRequests(t, 1)
+	// 400 is not in retry_on_status so the batch is not resent.
+	time.Sleep(100 * time.Millisecond)
+	if n := len(s.Requests()); n != 1 {
+		t.Fatalf("expected no retry, got %d requests", n)
+	}
+	r := requests[0]
+	if r.Method != http.MethodPut || r.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected request %s %v", r.Method, r.Header)
+	}
+	if want := "up,job=n0de 2023-11-14T22:13:20.123Z\n"; string(r.Body) != want {
+		t.Fatalf("body = %q, want %q", r.Body, want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	zero := 0
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		policy   setting.WebhookRetryPolicy
+		requests int
+	}{
+		{"default statuses", []int{http.StatusTooManyRequests, http.StatusBadGateway}, setting.WebhookRetryPolicy{}, 3},
+		{"retry_on_status", []int{http.StatusConflict, http.StatusServiceUnavailable}, setting.WebhookRetryPolicy{RetryOnStatus: []int{http.StatusConflict}}, 2},
+		{"retries disabled", []int{http.StatusServiceUnavailable}, setting.WebhookRetryPolicy{MaxRetries: &zero}, 1},
+	} {
+		s := sinktest.NewServer(t, sinktest.Statuses(tc.statuses...))
+		tc.policy.MinBackoff = model.Duration(time.Millisecond)
+		w, err := webhookclient.NewWebhook("test", setting.WebhookConfig{URL: s.URL, RetryPolicy: tc.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Store(context.Background(), testSeries()[:1]); err != nil {
+			t.Fatal(err)
+		}
+		// Close sends the buffered series before returning.
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.Requests()); n != tc.requests {
+			t.Fatalf("%s: expected %d requests, got %d", tc.name, tc.requests, n)
+		}
+	}
+}
+
+func TestWebhookConfigErrors(t *testing.T) {
+	negative := -1
+	sinktest.ConfigErrors(t, func(cfg setting.WebhookConfig) error {
+		_, err := webhookclient.NewWebhook("test", cfg)
+		return err
+	},
+		setting.WebhookConfig{},
+		setting.WebhookConfig{URL: "http://localhost", BodyTemplate: "{{range .Series}"},
+		setting.WebhookConfig{URL: "http://localhost", BodyTemplate: "{{unknown .Series}}"},
+		setting.WebhookConfig{URL: "http://localhost", RetryPolicy: setting.WebhookRetryPolicy{MaxRetries: &negative}},
+	)
+}